@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ducksouplab/ducksoup/datastore"
+	"github.com/ducksouplab/ducksoup/encryption"
+	"github.com/ducksouplab/ducksoup/storage"
+)
+
+// administration commands, run instead of the server with: ducksoup [command] [flags]
+var commands = map[string]func(args []string) error{
+	"delete-participant": deleteParticipantCommand,
+	"reidentify":         reidentifyCommand,
+	"retention":          retentionCommand,
+	"keygen":             keygenCommand,
+	"decrypt":            decryptCommand,
+}
+
+// the server must not be running an interaction of the namespace (they would not be skipped)
+func deleteParticipantCommand(args []string) error {
+	flags := flag.NewFlagSet("delete-participant", flag.ExitOnError)
+	namespace := flags.String("namespace", "", "namespace of the interactions")
+	userId := flags.String("user", "", "userId of the participant")
+	flags.Parse(args)
+	if len(*namespace) == 0 || len(*userId) == 0 {
+		flags.Usage()
+		return fmt.Errorf("namespace and user are required")
+	}
+
+	backend, err := storage.FromEnv()
+	if err != nil {
+		return err
+	}
+	if datastore.PseudonymsEnabled() {
+		*userId = datastore.Pseudonym(*namespace, *userId)
+	}
+	report, err := datastore.DeleteParticipant(*namespace, *userId, nil, backend)
+	if err != nil {
+		return err
+	}
+	datastore.Audit().Info().
+		Str("context", "data").
+		Str("login", "command").
+		Str("namespace", *namespace).
+		Str("user", *userId).
+		Int("files", report.DeletedFiles).
+		Int("redactedLines", report.RedactedLines).
+		Msg("participant_data_deleted")
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func reidentifyCommand(args []string) error {
+	flags := flag.NewFlagSet("reidentify", flag.ExitOnError)
+	namespace := flags.String("namespace", "", "namespace of the interactions")
+	pseudonym := flags.String("pseudonym", "", "pseudonym found in data")
+	flags.Parse(args)
+	if len(*namespace) == 0 || len(*pseudonym) == 0 {
+		flags.Usage()
+		return fmt.Errorf("namespace and pseudonym are required")
+	}
+
+	userId, err := datastore.Reidentify(*namespace, *pseudonym)
+	if err != nil {
+		return err
+	}
+	datastore.Audit().Info().
+		Str("context", "data").
+		Str("login", "command").
+		Str("namespace", *namespace).
+		Str("user", *pseudonym).
+		Msg("pseudonym_reidentified")
+	fmt.Println(userId)
+	return nil
+}
+
+// the server must not be running (interactions would not be skipped)
+func retentionCommand(args []string) error {
+	flags := flag.NewFlagSet("retention", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "list files to be deleted without deleting them")
+	flags.Parse(args)
+
+	report := datastore.ApplyRetention(nil, *dryRun)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// writes a private key file and prints the public key to be set in config/data.yml
+func keygenCommand(args []string) error {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := flags.String("out", "", "private key file to be created")
+	flags.Parse(args)
+	if len(*out) == 0 {
+		flags.Usage()
+		return fmt.Errorf("out is required")
+	}
+
+	key, err := encryption.GenerateKey()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(encryption.EncodeKey(key.Bytes()) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Println("public key:", encryption.EncodeKey(key.PublicKey().Bytes()))
+	fmt.Println("fingerprint:", encryption.Fingerprint(key.PublicKey()))
+	return nil
+}
+
+func decryptCommand(args []string) error {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyFile := flags.String("key", "", "private key file (see keygen)")
+	flags.Parse(args)
+	if len(*keyFile) == 0 || flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: decrypt -key private_key_file file.enc...")
+		flags.Usage()
+		return fmt.Errorf("key and files are required")
+	}
+
+	contents, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := encryption.ParsePrivateKey(string(contents))
+	if err != nil {
+		return err
+	}
+	for _, file := range flags.Args() {
+		if !strings.HasSuffix(file, encryption.Extension) {
+			return fmt.Errorf("%v: not a %v file", file, encryption.Extension)
+		}
+		if err := encryption.DecryptFile(strings.TrimSuffix(file, encryption.Extension), file, key); err != nil {
+			return fmt.Errorf("%v: %w", file, err)
+		}
+		fmt.Println("decrypted:", file)
+	}
+	return nil
+}
+
+// returns false if args don't start with a known command
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		return false
+	}
+	if err := command(args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return true
+}
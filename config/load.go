@@ -2,9 +2,11 @@ package config
 
 import (
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/ducksouplab/ducksoup/helpers"
+	"github.com/ducksouplab/ducksoup/types"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v2"
 )
@@ -14,15 +16,147 @@ type SFUConfig struct {
 		MTU                  int           `yaml:"mtu"`
 		EncoderControlPeriod int           `yaml:"encoderControlPeriod"`
 		TWCCInterval         time.Duration `yaml:"twccInterval"`
+		RetransmissionBuffer int           `yaml:"retransmissionBuffer"` // in packets, per outgoing video track
 	}
 	Audio SFUStream
 	Video SFUStream
+	Opus  struct {
+		Default   types.OpusConfig         `yaml:"default"`
+		Templates map[string]yaml.MapSlice `yaml:"templates"` // only overridden properties
+	}
 }
 
 type SFUStream struct {
 	DefaultBitrate int `yaml:"defaultBitrate"`
 	MinBitrate     int `yaml:"minBitrate"`
 	MaxBitrate     int `yaml:"maxBitrate"`
+	// video only: bitrates from which each tier is selected for a receiver, the last tier
+	// being the main encoder (see sfu/tiers.go)
+	Tiers []int `yaml:"tiers"`
+	// video only: resolution and framerate steps under congestion (see sfu/adaptation.go)
+	Adaptation SFUAdaptation `yaml:"adaptation"`
+	// audio only: level measures of decoded audio (see sfu/audio_level.go)
+	Level SFULevel `yaml:"level"`
+	// audio only: voice activity detection on measured levels (see sfu/vad.go)
+	VAD SFUVAD `yaml:"vad"`
+	// audio only: levels sent to participants and shown on the stats page (see sfu/audio_level.go)
+	Meter SFUMeter `yaml:"meter"`
+}
+
+type SFUMeter struct {
+	Period int `yaml:"period"` // ms between audio_level messages, 0 to disable them
+}
+
+type SFULevel struct {
+	Interval int `yaml:"interval"` // ms between level measures, 0 to disable them
+}
+
+type SFUVAD struct {
+	Enabled    bool    `yaml:"enabled"`    // needs level measures
+	Threshold  float64 `yaml:"threshold"`  // RMS level (dBFS) above which audio is speech
+	MinSpeech  int     `yaml:"minSpeech"`  // ms above threshold for speech to start
+	MinSilence int     `yaml:"minSilence"` // ms below threshold for speech to end
+}
+
+type SFUAdaptation struct {
+	// a step is undone when the target bitrate exceeds its threshold by this ratio
+	RecoverRatio float64             `yaml:"recoverRatio"`
+	Steps        []SFUAdaptationStep `yaml:"steps"`
+}
+
+type SFUAdaptationStep struct {
+	Below     int     `yaml:"below"`     // target bitrate (bit/s) under which the step is applied
+	Scale     float64 `yaml:"scale"`     // share of the interaction width and height
+	Framerate float64 `yaml:"framerate"` // share of the interaction framerate
+}
+
+const defaultRecoverRatio = 1.3
+
+// steps are sorted from the highest threshold and shares are restricted to ]0, 1]
+func sanitizeAdaptation(a SFUAdaptation) SFUAdaptation {
+	if a.RecoverRatio <= 1 {
+		a.RecoverRatio = defaultRecoverRatio
+	}
+	for i, s := range a.Steps {
+		if s.Scale <= 0 || s.Scale > 1 {
+			a.Steps[i].Scale = 1
+		}
+		if s.Framerate <= 0 || s.Framerate > 1 {
+			a.Steps[i].Framerate = 1
+		}
+	}
+	slices.SortFunc(a.Steps, func(s1, s2 SFUAdaptationStep) int {
+		return s2.Below - s1.Below
+	})
+	return a
+}
+
+// Opus packet durations (in ms) that can be negotiated
+var opusPTimes = []int{10, 20, 40, 60}
+
+const (
+	opusMinBitrate = 6000
+	opusMaxBitrate = 510000
+)
+
+// SanitizeOpus fills missing bitrates with audio ones and restricts values to what opusenc supports
+func SanitizeOpus(c types.OpusConfig) types.OpusConfig {
+	if !slices.Contains(opusPTimes, c.PTime) {
+		c.PTime = 20
+	}
+	c.Complexity = min(max(c.Complexity, 0), 10)
+	if c.MinBitrate <= 0 {
+		c.MinBitrate = SFU.Audio.MinBitrate
+	}
+	if c.MaxBitrate <= 0 {
+		c.MaxBitrate = SFU.Audio.MaxBitrate
+	}
+	if c.DefaultBitrate <= 0 {
+		c.DefaultBitrate = SFU.Audio.DefaultBitrate
+	}
+	c.MinBitrate = min(max(c.MinBitrate, opusMinBitrate), opusMaxBitrate)
+	c.MaxBitrate = min(max(c.MaxBitrate, c.MinBitrate), opusMaxBitrate)
+	c.DefaultBitrate = min(max(c.DefaultBitrate, c.MinBitrate), c.MaxBitrate)
+	return c
+}
+
+// OpusTemplate returns the named template, or the default settings if it does not exist
+func OpusTemplate(name string) (c types.OpusConfig, ok bool) {
+	if c, ok = opusTemplates[name]; ok {
+		return
+	}
+	return opusTemplates[""], false
+}
+
+type DataConfig struct {
+	MinFreeSpace    int                        `yaml:"minFreeSpace"`    // in MB
+	WarnFreeSpace   int                        `yaml:"warnFreeSpace"`   // in MB
+	Quotas          map[string]int             `yaml:"quotas"`          // per namespace, in MB
+	CheckPeriod     int                        `yaml:"checkPeriod"`     // in seconds
+	Retention       map[string]RetentionPolicy `yaml:"retention"`       // per namespace, or "default"
+	RetentionPeriod int                        `yaml:"retentionPeriod"` // in minutes, 0 to disable
+	EncryptionKeys  map[string]string          `yaml:"encryptionKeys"`  // per namespace, base64 X25519 public keys
+}
+
+// ages in days since last modification, 0 meaning files are kept
+type RetentionPolicy struct {
+	Plots      int `yaml:"plots"`
+	Logs       int `yaml:"logs"`
+	Recordings int `yaml:"recordings"`
+	// delete recordings as soon as their upload to remote storage has been verified
+	UploadedRecordings bool `yaml:"uploadedRecordings"`
+}
+
+func (p RetentionPolicy) IsZero() bool {
+	return p == RetentionPolicy{}
+}
+
+// RetentionFor returns the namespace policy, falling back to the "default" one
+func (d DataConfig) RetentionFor(namespace string) RetentionPolicy {
+	if policy, ok := d.Retention[namespace]; ok {
+		return policy
+	}
+	return d.Retention["default"]
 }
 
 type versionConfig struct {
@@ -31,6 +165,8 @@ type versionConfig struct {
 }
 
 var SFU SFUConfig
+var opusTemplates map[string]types.OpusConfig // sanitized, "" being the default
+var Data DataConfig
 var FrontendVersion, BackendVersion string
 
 func init() {
@@ -47,6 +183,38 @@ func init() {
 		log.Fatal().Err(err)
 	}
 
+	// templates override default Opus settings
+	opusTemplates = map[string]types.OpusConfig{"": SanitizeOpus(SFU.Opus.Default)}
+	for name, overrides := range SFU.Opus.Templates {
+		c := SFU.Opus.Default
+		if out, err := yaml.Marshal(overrides); err == nil {
+			err = yaml.Unmarshal(out, &c)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Str("template", name).Msg("opus_template_invalid")
+		}
+		opusTemplates[name] = SanitizeOpus(c)
+	}
+
+	slices.Sort(SFU.Video.Tiers)
+	SFU.Video.Adaptation = sanitizeAdaptation(SFU.Video.Adaptation)
+
+	// Data folder
+	f, err = helpers.Open("config/data.yml")
+	if err != nil {
+		log.Fatal().Err(err)
+	}
+	defer f.Close()
+
+	decoder = yaml.NewDecoder(f)
+	err = decoder.Decode(&Data)
+	if err != nil {
+		log.Fatal().Err(err)
+	}
+	if Data.CheckPeriod < 1 {
+		Data.CheckPeriod = 10
+	}
+
 	// Front-end
 	f, err = helpers.Open("config/version.yml")
 	if err != nil {
@@ -65,5 +233,7 @@ func init() {
 
 	// log
 	log.Info().Str("context", "init").Str("config", fmt.Sprintf("%+v", SFU)).Msg("sfu_config_loaded")
+	log.Info().Str("context", "init").Str("config", fmt.Sprintf("%+v", opusTemplates)).Msg("opus_templates_loaded")
+	log.Info().Str("context", "init").Str("config", fmt.Sprintf("%+v", Data)).Msg("data_config_loaded")
 	log.Info().Str("context", "init").Str("value", FrontendVersion).Msg("frontend_version")
 }
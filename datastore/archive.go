@@ -0,0 +1,127 @@
+package datastore
+
+import (
+	"archive/zip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// already compressed formats are stored as is
+var storedExtensions = []string{".mkv", ".mp4", ".webm", ".ogg", ".opus", ".pdf", ".png", ".zip", ".gz", ".enc"}
+
+type ArchiveStats struct {
+	Interactions int
+	Files        int
+	Bytes        int64 // uncompressed
+}
+
+// Skipper tells if an interaction should be left out (for instance if it is running)
+type Skipper func(namespace, interaction string) bool
+
+func compressionMethod(name string) uint16 {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, stored := range storedExtensions {
+		if ext == stored {
+			return zip.Store
+		}
+	}
+	return zip.Deflate
+}
+
+func addFile(zw *zip.Writer, filePath, name string, info fs.FileInfo, stats *ArchiveStats) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = compressionMethod(name)
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	// copied by chunks, files are never entirely loaded in memory
+	n, err := io.Copy(w, f)
+	stats.Files++
+	stats.Bytes += n
+	return err
+}
+
+// files are archived under namespace/interaction/, the cache folder being left out
+func addInteraction(zw *zip.Writer, namespace, interaction string, stats *ArchiveStats) error {
+	folder := InteractionFolder(namespace, interaction)
+	err := filepath.WalkDir(folder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(folder, p)
+		if d.IsDir() {
+			if rel == "cache" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return addFile(zw, p, path.Join(namespace, interaction, filepath.ToSlash(rel)), info, stats)
+	})
+	if err == nil {
+		stats.Interactions++
+	}
+	return err
+}
+
+// WriteInteractionArchive streams a zip of one interaction folder to w
+func WriteInteractionArchive(w io.Writer, namespace, interaction string) (stats ArchiveStats, err error) {
+	if !ValidName(namespace) || !ValidName(interaction) {
+		return stats, ErrInvalidName
+	}
+	if _, err = os.Stat(InteractionFolder(namespace, interaction)); err != nil {
+		return
+	}
+	zw := zip.NewWriter(w)
+	if err = addInteraction(zw, namespace, interaction, &stats); err != nil {
+		return
+	}
+	err = zw.Close()
+	return
+}
+
+// WriteNamespaceArchive streams a zip of the interactions of a namespace created
+// within [from, to) (zero values meaning no bound) to w
+func WriteNamespaceArchive(w io.Writer, namespace string, from, to time.Time, skip Skipper) (stats ArchiveStats, skipped []string, err error) {
+	interactions, err := Interactions(namespace)
+	if err != nil {
+		return
+	}
+	zw := zip.NewWriter(w)
+	for _, s := range interactions {
+		if (!from.IsZero() && s.CreatedAt.Before(from)) || (!to.IsZero() && !s.CreatedAt.Before(to)) {
+			continue
+		}
+		if skip != nil && skip(namespace, s.Name) {
+			skipped = append(skipped, s.Name)
+			continue
+		}
+		if err = addInteraction(zw, namespace, s.Name, &stats); err != nil {
+			return
+		}
+	}
+	err = zw.Close()
+	return
+}
@@ -0,0 +1,79 @@
+package datastore
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path, contents string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func zipNames(t *testing.T, buf *bytes.Buffer) (names []string) {
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func TestArchive(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	writeTestFile(t, "data/ns/old/manifest.json", `{"createdAt":"2024-01-10T10:00:00Z"}`)
+	writeTestFile(t, "data/ns/old/recordings/u1-dry.mkv", "recording")
+	writeTestFile(t, "data/ns/old/cache/tmp", "cache")
+	writeTestFile(t, "data/ns/recent/manifest.json", `{"createdAt":"2024-02-10T10:00:00Z"}`)
+	writeTestFile(t, "data/ns/running/interaction.log", "log")
+
+	t.Run("interaction", func(t *testing.T) {
+		var buf bytes.Buffer
+		stats, err := WriteInteractionArchive(&buf, "ns", "old")
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := zipNames(t, &buf)
+		if stats.Files != 2 || len(names) != 2 || names[0] != "ns/old/manifest.json" || names[1] != "ns/old/recordings/u1-dry.mkv" {
+			t.Errorf("unexpected archive contents: %v", names)
+		}
+	})
+
+	t.Run("namespace filtered by date", func(t *testing.T) {
+		var buf bytes.Buffer
+		from := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+		skip := func(namespace, interaction string) bool { return interaction == "running" }
+		stats, skipped, err := WriteNamespaceArchive(&buf, "ns", from, time.Time{}, skip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := zipNames(t, &buf)
+		if stats.Interactions != 1 || len(names) != 1 || names[0] != "ns/recent/manifest.json" {
+			t.Errorf("unexpected archive contents: %v", names)
+		}
+		if len(skipped) != 1 {
+			t.Errorf("running interaction should be skipped")
+		}
+	})
+
+	t.Run("path traversal", func(t *testing.T) {
+		if _, err := WriteInteractionArchive(&bytes.Buffer{}, "ns", ".."); err != ErrInvalidName {
+			t.Errorf("expected invalid name error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package datastore
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const AuditFile = "audit.log"
+
+var (
+	auditOnce   sync.Once
+	auditLogger zerolog.Logger
+)
+
+// Audit returns the logger tracking accesses to and operations on data, written
+// to data/audit.log (or to global logs if this file can't be opened)
+func Audit() *zerolog.Logger {
+	auditOnce.Do(func() {
+		auditLogger = log.Logger
+		if err := os.MkdirAll(Root, 0775); err != nil {
+			log.Error().Str("context", "data").Err(err).Msg("audit_log_open_failed")
+			return
+		}
+		fileWriter, err := os.OpenFile(filepath.Join(Root, AuditFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+		if err != nil {
+			log.Error().Str("context", "data").Err(err).Msg("audit_log_open_failed")
+			return
+		}
+		auditLogger = zerolog.New(fileWriter).With().Timestamp().Logger()
+	})
+	return &auditLogger
+}
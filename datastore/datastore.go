@@ -0,0 +1,120 @@
+// Package datastore gives structured access to the data folder (data/namespace/interaction) for export and maintenance
+package datastore
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/helpers"
+)
+
+const (
+	Root         = "data"
+	ManifestFile = "manifest.json"
+	maxNameSize  = 50
+)
+
+var (
+	ErrInvalidName = errors.New("invalid name")
+	validName      = regexp.MustCompile("^[a-zA-Z0-9-_]+$")
+)
+
+type NamespaceSummary struct {
+	Name             string `json:"name"`
+	InteractionCount int    `json:"interactionCount"`
+}
+
+type InteractionSummary struct {
+	Name      string          `json:"name"`
+	CreatedAt time.Time       `json:"createdAt"` // from manifest if any, else folder modification time
+	Size      int64           `json:"size"`
+	Manifest  json.RawMessage `json:"manifest,omitempty"`
+}
+
+// names are sanitized the same way when joining (see sfu.ParseString), which also prevents path traversals
+func ValidName(name string) bool {
+	return len(name) <= maxNameSize && validName.MatchString(name)
+}
+
+func NamespaceFolder(namespace string) string {
+	return filepath.Join(Root, namespace)
+}
+
+func InteractionFolder(namespace, interaction string) string {
+	return filepath.Join(Root, namespace, interaction)
+}
+
+func subFolders(path string) (names []string, err error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() && ValidName(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	return
+}
+
+func Namespaces() ([]NamespaceSummary, error) {
+	names, err := subFolders(Root)
+	if err != nil {
+		return nil, err
+	}
+	summaries := []NamespaceSummary{}
+	for _, name := range names {
+		interactions, _ := subFolders(NamespaceFolder(name))
+		summaries = append(summaries, NamespaceSummary{name, len(interactions)})
+	}
+	return summaries, nil
+}
+
+func readInteraction(namespace, name string) (InteractionSummary, error) {
+	folder := InteractionFolder(namespace, name)
+	info, err := os.Stat(folder)
+	if err != nil {
+		return InteractionSummary{}, err
+	}
+	s := InteractionSummary{
+		Name:      name,
+		CreatedAt: info.ModTime(),
+	}
+	s.Size, _ = helpers.DirSize(folder)
+	if contents, err := os.ReadFile(filepath.Join(folder, ManifestFile)); err == nil && json.Valid(contents) {
+		s.Manifest = contents
+		var m struct {
+			CreatedAt time.Time `json:"createdAt"`
+		}
+		if json.Unmarshal(contents, &m) == nil && !m.CreatedAt.IsZero() {
+			s.CreatedAt = m.CreatedAt
+		}
+	}
+	return s, nil
+}
+
+// Interactions lists the interactions of a namespace, sorted by creation date
+func Interactions(namespace string) ([]InteractionSummary, error) {
+	if !ValidName(namespace) {
+		return nil, ErrInvalidName
+	}
+	names, err := subFolders(NamespaceFolder(namespace))
+	if err != nil {
+		return nil, err
+	}
+	summaries := []InteractionSummary{}
+	for _, name := range names {
+		if s, err := readInteraction(namespace, name); err == nil {
+			summaries = append(summaries, s)
+		}
+	}
+	sort.Slice(summaries, func(a, b int) bool {
+		return summaries[a].CreatedAt.Before(summaries[b].CreatedAt)
+	})
+	return summaries, nil
+}
@@ -0,0 +1,412 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/storage"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog"
+)
+
+const AudioTestResultsFile = "audio_test_results.json"
+
+// DeletionReport lists what has been removed for a participant who withdrew
+type DeletionReport struct {
+	Namespace     string                `json:"namespace"`
+	UserId        string                `json:"userId"`
+	At            time.Time             `json:"at"`
+	DeletedFiles  int                   `json:"deletedFiles"`
+	RedactedLines int                   `json:"redactedLines"`
+	Interactions  []InteractionDeletion `json:"interactions"` // only the ones where data was found
+	Skipped       []string              `json:"skipped"`      // running interactions, to be processed once ended
+	// the mapping of the pseudonym to the original user id has been removed (if pseudonyms are enabled)
+	PseudonymForgotten bool `json:"pseudonymForgotten"`
+}
+
+type InteractionDeletion struct {
+	Interaction     string         `json:"interaction"`
+	DeletedFiles    []string       `json:"deletedFiles"` // relative to the interaction data folder
+	RedactedLogs    map[string]int `json:"redactedLogs"` // redacted line count per log file
+	ManifestUpdated bool           `json:"manifestUpdated"`
+	RemoteDeleted   []string       `json:"remoteDeleted"` // object keys
+	RemoteUpdated   []string       `json:"remoteUpdated"` // redacted logs and manifest uploaded again
+	Errors          []string       `json:"errors"`
+}
+
+// ids (user, interaction...) are made of letters, digits, - and _ (see sfu.ParseString),
+// so they are delimited by any other character
+const (
+	idStart = `(?:^|[^\w-])`
+	idEnd   = `(?:$|[^\w-])`
+)
+
+// matches the files and log lines of a participant within one interaction. Since ids may
+// contain hyphens, file prefixes are matched as a whole (from the namespace and interaction
+// segments to the connection count and the recording suffix) and not as a -u-[userId]- substring
+type participantMatcher struct {
+	userId      string
+	fileRegex   *regexp.Regexp // recordings: gst filePrefix followed by a recording suffix
+	prefixRegex *regexp.Regexp // same, found in free text
+	mozzaRegex  *regexp.Regexp // mozza user-id: r-[randomId]-u-[userId]
+	dumpRegex   *regexp.Regexp
+	plotRegex   *regexp.Regexp
+}
+
+func newParticipantMatcher(namespace, interaction, userId string) *participantMatcher {
+	quoted := regexp.QuoteMeta(userId)
+	prefix := `i-[0-9a-f]+-a-\d{8}-\d{6}\.\d{3}-s-` + regexp.QuoteMeta(namespace) +
+		`-n-` + regexp.QuoteMeta(interaction) + `-u-` + quoted + `-c-\d+` +
+		`(?:-(?:audio-|video-)?(?:dry|wet)\.|` + idEnd + `)`
+	return &participantMatcher{
+		userId:      userId,
+		fileRegex:   regexp.MustCompile(`^` + prefix),
+		prefixRegex: regexp.MustCompile(idStart + prefix),
+		mozzaRegex:  regexp.MustCompile(idStart + `r-[0-9a-f]+-u-` + quoted + idEnd),
+		dumpRegex:   regexp.MustCompile(`^pipeline-u-` + quoted + `-\d{8}-\d{6}\.\d{3}\.txt$`),
+		plotRegex:   regexp.MustCompile(`^(audio|video)-` + quoted + `-(bitrates|buffer)\.pdf$`),
+	}
+}
+
+// recordings, pipeline dumps and plots (audio test results need their contents to be checked)
+func (pm *participantMatcher) matchesFile(name string) bool {
+	base := path.Base(name)
+	return pm.fileRegex.MatchString(base) || pm.dumpRegex.MatchString(base) || pm.plotRegex.MatchString(base)
+}
+
+// free text (file paths, pipeline descriptions...) referring to the participant
+func (pm *participantMatcher) matchesText(s string) bool {
+	return pm.prefixRegex.MatchString(s) || pm.mozzaRegex.MatchString(s)
+}
+
+// true if any user-related field of a (decoded) JSON value refers to the participant
+func (pm *participantMatcher) matchesJSON(v any, key string) bool {
+	switch value := v.(type) {
+	case map[string]any:
+		for k, sub := range value {
+			if pm.matchesJSON(sub, k) {
+				return true
+			}
+		}
+	case []any:
+		for _, sub := range value {
+			if pm.matchesJSON(sub, key) {
+				return true
+			}
+		}
+	case string:
+		lowerKey := strings.ToLower(key)
+		if lowerKey == "user" || strings.HasSuffix(lowerKey, "userid") {
+			return value == pm.userId
+		}
+		return pm.matchesText(value)
+	}
+	return false
+}
+
+func (pm *participantMatcher) matchesLogLine(line []byte) bool {
+	var entry map[string]any
+	if err := json.Unmarshal(line, &entry); err != nil {
+		// not a structured line (GStreamer output for instance)
+		return pm.matchesText(string(line)) || bytes.Contains(line, []byte(`"`+pm.userId+`"`))
+	}
+	return pm.matchesJSON(entry, "")
+}
+
+// redacted lines are kept (with their time) so that log files still show something happened
+func redactedLine(line []byte) []byte {
+	var entry struct {
+		Time string `json:"time"`
+	}
+	json.Unmarshal(line, &entry)
+	redacted, _ := json.Marshal(map[string]string{"level": "info", "context": "data", "time": entry.Time, "message": "line_redacted"})
+	return redacted
+}
+
+// redactLog rewrites the log file without the participant lines, returning the number of redacted lines
+func (pm *participantMatcher) redactLog(logPath string) (count int, err error) {
+	in, err := os.Open(logPath)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	tmpPath := logPath + ".redacting"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmpPath) // no-op once renamed
+
+	reader := bufio.NewReader(in)
+	writer := bufio.NewWriter(out)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			content := bytes.TrimRight(line, "\n")
+			if pm.matchesLogLine(content) {
+				count++
+				line = append(redactedLine(content), '\n')
+			}
+			if _, err = writer.Write(line); err != nil {
+				out.Close()
+				return
+			}
+		}
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			out.Close()
+			return 0, readErr
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		out.Close()
+		return
+	}
+	if err = out.Close(); err != nil || count == 0 {
+		return
+	}
+	err = os.Rename(tmpPath, logPath)
+	return
+}
+
+// audio test results don't follow the file naming convention: they are attributed
+// with their userId field or, for older results, if the participant is the only one
+// in the interaction manifest
+func (pm *participantMatcher) matchesAudioTestResults(filePath string, manifest *types.Manifest) bool {
+	var results struct {
+		UserId string `json:"userId"`
+	}
+	contents, err := os.ReadFile(filePath)
+	if err != nil || json.Unmarshal(contents, &results) != nil {
+		return false
+	}
+	if len(results.UserId) > 0 {
+		return results.UserId == pm.userId
+	}
+	return manifest != nil && len(manifest.Participants) == 1 && manifest.Participants[0].UserId == pm.userId
+}
+
+func readManifest(folder string) (*types.Manifest, error) {
+	contents, err := os.ReadFile(filepath.Join(folder, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	m := &types.Manifest{}
+	if err := json.Unmarshal(contents, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func writeManifest(folder string, m *types.Manifest) error {
+	contents, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(folder, ManifestFile), contents, 0666)
+}
+
+// elements of list for which keep is true (never nil, for JSON)
+func filter[T any](list []T, keep func(T) bool) []T {
+	kept := []T{}
+	for _, e := range list {
+		if keep(e) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// changes (see types.ManifestChange) neither made nor undergone by userId
+func withoutChanges[T interface{ Involves(string) bool }](list []T, userId string) []T {
+	return filter(list, func(c T) bool { return !c.Involves(userId) })
+}
+
+// removes every reference to the participant from the manifest
+func (pm *participantMatcher) redactManifest(m *types.Manifest, deleted map[string]bool) {
+	m.Participants = filter(m.Participants, func(p *types.ManifestParticipant) bool { return p.UserId != pm.userId })
+	m.NotRecorded = filter(m.NotRecorded, func(userId string) bool { return userId != pm.userId })
+	m.Pipelines = filter(m.Pipelines, func(p types.ManifestPipeline) bool { return p.UserId != pm.userId })
+	m.Recordings = filter(m.Recordings, func(r types.ManifestFile) bool { return r.UserId != pm.userId && !deleted[r.Path] })
+	m.FxChanges = withoutChanges(m.FxChanges, pm.userId)
+	m.Impairments = withoutChanges(m.Impairments, pm.userId)
+	m.Delays = withoutChanges(m.Delays, pm.userId)
+	m.AVOffsets = withoutChanges(m.AVOffsets, pm.userId)
+	m.Interventions = withoutChanges(m.Interventions, pm.userId)
+	m.Speech = filter(m.Speech, func(s types.ManifestSpeech) bool { return s.UserId != pm.userId })
+	// overlaps and gaps depend on the remaining participants
+	m.TurnTaking = types.TurnTaking(m.Speech)
+}
+
+func (d *InteractionDeletion) addError(err error) {
+	d.Errors = append(d.Errors, err.Error())
+}
+
+func (d *InteractionDeletion) empty() bool {
+	return len(d.DeletedFiles) == 0 && len(d.RedactedLogs) == 0 && !d.ManifestUpdated && len(d.RemoteDeleted) == 0 && len(d.Errors) == 0
+}
+
+func (pm *participantMatcher) deleteInInteraction(namespace, interaction string, backend storage.Backend, logger zerolog.Logger) InteractionDeletion {
+	folder := InteractionFolder(namespace, interaction)
+	d := InteractionDeletion{
+		Interaction:   interaction,
+		DeletedFiles:  []string{},
+		RedactedLogs:  map[string]int{},
+		RemoteDeleted: []string{},
+		RemoteUpdated: []string{},
+		Errors:        []string{},
+	}
+	manifest, _ := readManifest(folder)
+
+	var toDelete, logs []string
+	filepath.WalkDir(folder, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(folder, p)
+		rel = filepath.ToSlash(rel)
+		switch {
+		case pm.matchesFile(rel):
+			toDelete = append(toDelete, rel)
+		case entry.Name() == AudioTestResultsFile:
+			if pm.matchesAudioTestResults(p, manifest) {
+				toDelete = append(toDelete, rel)
+			}
+		case strings.HasSuffix(entry.Name(), ".log"):
+			logs = append(logs, rel)
+		}
+		return nil
+	})
+
+	deleted := make(map[string]bool)
+	for _, rel := range toDelete {
+		if err := os.Remove(filepath.Join(folder, rel)); err != nil {
+			d.addError(err)
+			continue
+		}
+		deleted[rel] = true
+		d.DeletedFiles = append(d.DeletedFiles, rel)
+	}
+	for _, rel := range logs {
+		count, err := pm.redactLog(filepath.Join(folder, rel))
+		if err != nil {
+			d.addError(err)
+		}
+		if count > 0 {
+			d.RedactedLogs[rel] = count
+		}
+	}
+
+	if manifest == nil || !pm.inManifest(manifest) && d.empty() {
+		return d
+	}
+	pm.redactManifest(manifest, deleted)
+	// remote copies (possibly the only ones left if local files have been deleted after upload)
+	if manifest.Storage != nil {
+		objects := []types.StoredObject{}
+		for _, object := range manifest.Storage.Objects {
+			if deleted[object.Path] || pm.matchesFile(object.Path) {
+				if backend == nil {
+					d.addError(errors.New("no storage backend configured to delete " + object.Key))
+				} else if err := backend.Delete(object.Key); err != nil {
+					d.addError(err)
+				} else {
+					d.RemoteDeleted = append(d.RemoteDeleted, object.Key)
+					continue
+				}
+			} else if _, ok := d.RedactedLogs[object.Path]; ok && backend != nil {
+				uploaded := storage.Upload(backend, filepath.Join(folder, object.Path), object.Key, logger)
+				uploaded.Path = object.Path
+				object = uploaded
+				d.RemoteUpdated = append(d.RemoteUpdated, object.Key)
+			} else if strings.HasSuffix(object.Path, ".log") && manifest.Storage.LocalDeleted {
+				if _, err := os.Stat(filepath.Join(folder, object.Path)); err != nil {
+					d.addError(errors.New("remote log not redacted (no local copy): " + object.Key))
+				}
+			}
+			objects = append(objects, object)
+		}
+		manifest.Storage.Objects = objects
+	}
+	if err := writeManifest(folder, manifest); err != nil {
+		d.addError(err)
+		return d
+	}
+	d.ManifestUpdated = true
+	if manifest.Storage != nil && backend != nil {
+		key := storage.ObjectKey(namespace, interaction, ManifestFile)
+		if object := storage.Upload(backend, filepath.Join(folder, ManifestFile), key, logger); object.Status == types.UploadVerified {
+			d.RemoteUpdated = append(d.RemoteUpdated, key)
+		} else {
+			d.addError(errors.New("manifest upload failed: " + object.Error))
+		}
+	}
+	return d
+}
+
+func (pm *participantMatcher) inManifest(m *types.Manifest) bool {
+	for _, p := range m.Participants {
+		if p.UserId == pm.userId {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteParticipant removes the data of a participant (recordings, pipeline dumps, plots,
+// audio test results and remote copies) from all interactions of a namespace, and
+// redacts logs and manifests. Running interactions are left untouched and reported as skipped
+func DeleteParticipant(namespace, userId string, skip Skipper, backend storage.Backend) (report DeletionReport, err error) {
+	if !ValidName(namespace) || len(userId) == 0 {
+		return report, ErrInvalidName
+	}
+	names, err := subFolders(NamespaceFolder(namespace))
+	if err != nil {
+		return
+	}
+	report = DeletionReport{
+		Namespace:    namespace,
+		UserId:       userId,
+		At:           time.Now(),
+		Interactions: []InteractionDeletion{},
+		Skipped:      []string{},
+	}
+	logger := Audit().With().Str("namespace", namespace).Logger()
+	for _, interaction := range names {
+		if skip != nil && skip(namespace, interaction) {
+			report.Skipped = append(report.Skipped, interaction)
+			continue
+		}
+		pm := newParticipantMatcher(namespace, interaction, userId)
+		d := pm.deleteInInteraction(namespace, interaction, backend, logger.With().Str("interaction", interaction).Logger())
+		if d.empty() {
+			continue
+		}
+		report.DeletedFiles += len(d.DeletedFiles) + len(d.RemoteDeleted)
+		for _, count := range d.RedactedLogs {
+			report.RedactedLines += count
+		}
+		report.Interactions = append(report.Interactions, d)
+	}
+	if PseudonymsEnabled() {
+		// userId is the pseudonym, the original id must not be kept
+		var forgetErr error
+		report.PseudonymForgotten, forgetErr = forgetPseudonym(namespace, userId)
+		if forgetErr != nil {
+			logger.Error().Str("context", "data").Str("user", userId).Err(forgetErr).Msg("pseudonym_forget_failed")
+		}
+	}
+	return
+}
@@ -0,0 +1,125 @@
+package datastore
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/env"
+)
+
+func TestDeleteParticipant(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	folder := "data/ns/interaction/"
+	writeTestFile(t, folder+"recordings/i-abc-a-20240101-100000.000-s-ns-n-interaction-u-u1-c-1-dry.mkv", "u1")
+	writeTestFile(t, folder+"recordings/i-abc-a-20240101-100000.000-s-ns-n-interaction-u-u10-c-1-dry.mkv", "u10")
+	writeTestFile(t, folder+"pipeline-u-u1-20240101-100000.000.txt", "dump")
+	writeTestFile(t, folder+"plots/video-u1-bitrates.pdf", "plot")
+	writeTestFile(t, folder+"audio_test_results.json", `{"userId":"u1","passed":true}`)
+	writeTestFile(t, folder+"interaction-a-20240101-100000.000.log", strings.Join([]string{
+		`{"level":"info","context":"interaction","time":"20240101-100000.000","message":"interaction_created"}`,
+		`{"level":"info","context":"peer","user":"u1","time":"20240101-100000.001","message":"peer_joined"}`,
+		`{"level":"info","context":"peer","payload":{"userId":"u1"},"time":"20240101-100000.002","message":"join_payload"}`,
+		`{"level":"info","context":"peer","user":"u10","time":"20240101-100000.003","message":"peer_joined"}`,
+	}, "\n")+"\n")
+	writeTestFile(t, folder+ManifestFile, `{"participants":[{"userId":"u1"},{"userId":"u10"}],"notRecorded":["u1"],"fxChanges":[{"userId":"u10","fromUserId":"u1"}],"delays":[{"userId":"u1","fromUserId":"u10"},{"userId":"u10","fromUserId":"u10","delay":100}]}`)
+
+	report, err := DeleteParticipant("ns", "u1", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DeletedFiles != 4 || report.RedactedLines != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if _, err := os.Stat(folder + "recordings/i-abc-a-20240101-100000.000-s-ns-n-interaction-u-u10-c-1-dry.mkv"); err != nil {
+		t.Errorf("other participant recording should be kept")
+	}
+	log, _ := os.ReadFile(folder + "interaction-a-20240101-100000.000.log")
+	if strings.Contains(string(log), `"u1"`) || !strings.Contains(string(log), `"u10"`) || strings.Count(string(log), "line_redacted") != 2 {
+		t.Errorf("unexpected redacted log:\n%s", log)
+	}
+	manifest, _ := readManifest(folder)
+	if len(manifest.Participants) != 1 || len(manifest.NotRecorded) != 0 || len(manifest.FxChanges) != 0 || len(manifest.Delays) != 1 || manifest.Delays[0].Delay != 100 {
+		t.Errorf("unexpected redacted manifest: %+v", manifest)
+	}
+}
+
+func TestDeleteParticipantHyphenatedIds(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+
+	// the interaction name contains -u-u1-, and u1-x starts like u1
+	folder := "data/ns/a-u-u1-b/"
+	kept := []string{
+		folder + "recordings/i-abc-a-20240101-100000.000-s-ns-n-a-u-u1-b-u-u1-x-c-1-dry.mkv",
+		folder + "recordings/i-abc-a-20240101-100000.000-s-ns-n-a-u-u1-b-u-u1-x-c-1-audio-wet.ogg",
+		folder + "recordings/i-abc-a-20240101-100000.000-s-ns-n-a-u-u1-b-u-u2-c-1-dry.mkv",
+		folder + "pipeline-u-u1-x-20240101-100000.000.txt",
+	}
+	for _, file := range kept {
+		writeTestFile(t, file, "kept")
+	}
+	writeTestFile(t, folder+"recordings/i-abc-a-20240101-100000.000-s-ns-n-a-u-u1-b-u-u1-c-2-dry.mkv", "u1")
+	writeTestFile(t, folder+"recordings/i-abc-a-20240101-100000.000-s-ns-n-a-u-u1-b-u-u1-c-2.video.x264_pass.log", "u1")
+	writeTestFile(t, folder+"a-u-u1-b-a-20240101-100000.000.log", strings.Join([]string{
+		`{"level":"info","context":"pipeline","user":"u1-x","fx":"mozza user-id=r-abc-u-u1-x","time":"20240101-100000.000","message":"pipeline_created"}`,
+		`{"level":"info","context":"pipeline","user":"u2","file":"data/ns/a-u-u1-b/recordings/i-abc-a-20240101-100000.000-s-ns-n-a-u-u1-b-u-u2-c-1-dry.mkv","time":"20240101-100000.001","message":"recording"}`,
+		`{"level":"info","context":"pipeline","fx":"mozza user-id=r-abc-u-u1","time":"20240101-100000.002","message":"pipeline_created"}`,
+		`0:00:01.000 WARN filesink location=data/ns/a-u-u1-b/recordings/i-abc-a-20240101-100000.000-s-ns-n-a-u-u1-b-u-u1-c-2-dry.mkv`,
+		`0:00:01.001 WARN filesink location=data/ns/a-u-u1-b/recordings/i-abc-a-20240101-100000.000-s-ns-n-a-u-u1-b-u-u1-x-c-1-dry.mkv`,
+	}, "\n")+"\n")
+
+	report, err := DeleteParticipant("ns", "u1", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DeletedFiles != 2 || report.RedactedLines != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	for _, file := range kept {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("file of another participant should be kept: %v", file)
+		}
+	}
+	log, _ := os.ReadFile(folder + "a-u-u1-b-a-20240101-100000.000.log")
+	if !strings.Contains(string(log), "r-abc-u-u1-x") || !strings.Contains(string(log), "u-u2-c-1") || !strings.Contains(string(log), "u-u1-x-c-1") {
+		t.Errorf("lines of other participants should be kept:\n%s", log)
+	}
+}
+
+func TestDeleteParticipantForgetsPseudonym(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+	secret, dir := env.PseudonymSecret, env.PseudonymDir
+	env.PseudonymSecret, env.PseudonymDir = "secret", "pseudonyms"
+	defer func() { env.PseudonymSecret, env.PseudonymDir = secret, dir }()
+
+	withdrawn := PseudonymizeUser("ns", "worker-1")
+	other := PseudonymizeUser("ns", "worker-2")
+	writeTestFile(t, "data/ns/interaction/"+ManifestFile, `{"participants":[{"userId":"`+withdrawn+`"},{"userId":"`+other+`"}]}`)
+
+	report, err := DeleteParticipant("ns", withdrawn, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.PseudonymForgotten {
+		t.Errorf("pseudonym should be reported as forgotten")
+	}
+	if mapping, _ := os.ReadFile(mappingFile("ns")); strings.Contains(string(mapping), "worker-1") || !strings.Contains(string(mapping), "worker-2") {
+		t.Errorf("unexpected mapping file:\n%s", mapping)
+	}
+	if _, err := Reidentify("ns", withdrawn); err != ErrUnknownPseudonym {
+		t.Errorf("withdrawn pseudonym should not be reidentified")
+	}
+	if userId, err := Reidentify("ns", other); err != nil || userId != "worker-2" {
+		t.Errorf("other pseudonym should still be reidentified: %v %v", userId, err)
+	}
+	if report, _ := DeleteParticipant("ns", withdrawn, nil, nil); report.PseudonymForgotten {
+		t.Errorf("pseudonym should only be forgotten once")
+	}
+}
@@ -0,0 +1,171 @@
+package datastore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/rs/zerolog/log"
+)
+
+const pseudonymPrefix = "p-"
+
+var ErrUnknownPseudonym = errors.New("unknown pseudonym")
+
+type mappingEntry struct {
+	Pseudonym string    `json:"pseudonym"`
+	UserId    string    `json:"userId"`
+	At        time.Time `json:"at"`
+}
+
+// per namespace index of already mapped pseudonyms
+var mappings = struct {
+	sync.Mutex
+	index map[string]map[string]string
+}{index: make(map[string]map[string]string)}
+
+func PseudonymsEnabled() bool {
+	return len(env.PseudonymSecret) > 0
+}
+
+// each namespace has its own secret, derived from DUCKSOUP_PSEUDONYM_SECRET
+func namespaceSecret(namespace string) []byte {
+	h := hmac.New(sha256.New, []byte(env.PseudonymSecret))
+	h.Write([]byte("ducksoup-namespace:" + namespace))
+	return h.Sum(nil)
+}
+
+// Pseudonym derives the keyed hash of userId, stable for a given namespace
+func Pseudonym(namespace, userId string) string {
+	h := hmac.New(sha256.New, namespaceSecret(namespace))
+	h.Write([]byte(userId))
+	return pseudonymPrefix + hex.EncodeToString(h.Sum(nil))[:20]
+}
+
+func mappingFile(namespace string) string {
+	return filepath.Join(env.PseudonymDir, namespace+".jsonl")
+}
+
+// should be called with mappings locked
+func loadMapping(namespace string) map[string]string {
+	if m, ok := mappings.index[namespace]; ok {
+		return m
+	}
+	m := make(map[string]string)
+	if f, err := os.Open(mappingFile(namespace)); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var entry mappingEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+				m[entry.Pseudonym] = entry.UserId
+			}
+		}
+		f.Close()
+	}
+	mappings.index[namespace] = m
+	return m
+}
+
+// the mapping file is kept apart from data (and never exported or uploaded) and only readable by its owner
+func appendMapping(namespace string, entry mappingEntry) error {
+	if err := os.MkdirAll(env.PseudonymDir, 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(mappingFile(namespace), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	line, _ := json.Marshal(entry)
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// PseudonymizeUser returns the pseudonym to be used instead of userId (or userId
+// itself if pseudonyms are disabled), recording it in the namespace mapping file
+func PseudonymizeUser(namespace, userId string) string {
+	if !PseudonymsEnabled() || len(userId) == 0 {
+		return userId
+	}
+	pseudonym := Pseudonym(namespace, userId)
+	if !ValidName(namespace) {
+		// the mapping file path is derived from namespace
+		log.Error().Str("context", "data").Str("user", pseudonym).Err(ErrInvalidName).Msg("pseudonym_mapping_failed")
+		return pseudonym
+	}
+
+	mappings.Lock()
+	defer mappings.Unlock()
+	m := loadMapping(namespace)
+	if _, ok := m[pseudonym]; !ok {
+		if err := appendMapping(namespace, mappingEntry{pseudonym, userId, time.Now()}); err != nil {
+			// not logging userId, it's the point
+			log.Error().Str("context", "data").Str("namespace", namespace).Str("user", pseudonym).Err(err).Msg("pseudonym_mapping_failed")
+		} else {
+			m[pseudonym] = userId
+		}
+	}
+	return pseudonym
+}
+
+// forgetPseudonym rewrites the namespace mapping file without pseudonym (the only place
+// where the original user id is kept), returning true if it was found
+func forgetPseudonym(namespace, pseudonym string) (found bool, err error) {
+	mappings.Lock()
+	defer mappings.Unlock()
+	// the file may have been written by another process
+	delete(mappings.index, namespace)
+
+	path := mappingFile(namespace)
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return
+	}
+	var kept []byte
+	for _, line := range bytes.SplitAfter(contents, []byte("\n")) {
+		var entry mappingEntry
+		if json.Unmarshal(bytes.TrimSpace(line), &entry) == nil && entry.Pseudonym == pseudonym {
+			found = true
+			continue
+		}
+		kept = append(kept, line...)
+	}
+	if !found {
+		return
+	}
+	tmpPath := path + ".rewriting"
+	if err = os.WriteFile(tmpPath, kept, 0600); err != nil {
+		return
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+	}
+	return
+}
+
+// Reidentify looks for pseudonym in the namespace mapping file
+func Reidentify(namespace, pseudonym string) (string, error) {
+	if !ValidName(namespace) {
+		return "", ErrInvalidName
+	}
+	mappings.Lock()
+	defer mappings.Unlock()
+	// the file may have been written by another process
+	delete(mappings.index, namespace)
+	if userId, ok := loadMapping(namespace)[pseudonym]; ok {
+		return userId, nil
+	}
+	return "", ErrUnknownPseudonym
+}
@@ -0,0 +1,36 @@
+package datastore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/env"
+)
+
+func TestPseudonymizeUser(t *testing.T) {
+	secret, dir := env.PseudonymSecret, env.PseudonymDir
+	env.PseudonymSecret, env.PseudonymDir = "secret", t.TempDir()
+	defer func() { env.PseudonymSecret, env.PseudonymDir = secret, dir }()
+
+	pseudonym := PseudonymizeUser("ns", "worker-123")
+	if pseudonym == "worker-123" || !ValidName(pseudonym) {
+		t.Errorf("invalid pseudonym: %v", pseudonym)
+	}
+	if PseudonymizeUser("ns", "worker-123") != pseudonym {
+		t.Errorf("pseudonym should be stable")
+	}
+	if PseudonymizeUser("other", "worker-123") == pseudonym {
+		t.Errorf("pseudonym should depend on namespace")
+	}
+	if userId, err := Reidentify("ns", pseudonym); err != nil || userId != "worker-123" {
+		t.Errorf("reidentification failed: %v %v", userId, err)
+	}
+	if _, err := Reidentify("other", pseudonym); err != ErrUnknownPseudonym {
+		t.Errorf("pseudonym should not be found in another namespace")
+	}
+	PseudonymizeUser("../ns", "worker-123")
+	if entries, _ := os.ReadDir(filepath.Dir(env.PseudonymDir)); len(entries) != 1 {
+		t.Errorf("no mapping file should be written outside the pseudonym folder: %v", entries)
+	}
+}
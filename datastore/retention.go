@@ -0,0 +1,192 @@
+package datastore
+
+import (
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/storage"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog/log"
+)
+
+const day = 24 * time.Hour
+
+type RetentionReport struct {
+	At      time.Time     `json:"at"`
+	DryRun  bool          `json:"dryRun"`
+	Removed []RemovedFile `json:"removed"`
+	Bytes   int64         `json:"bytes"`
+	Skipped []string      `json:"skipped"` // running interactions (namespace/interaction)
+	Errors  []string      `json:"errors"`
+}
+
+type RemovedFile struct {
+	Namespace   string `json:"namespace"`
+	Interaction string `json:"interaction"`
+	Path        string `json:"path"` // relative to the interaction data folder
+	Category    string `json:"category"`
+	Reason      string `json:"reason"`
+	Size        int64  `json:"size"`
+}
+
+func retentionCategory(rel string) string {
+	switch {
+	case strings.HasPrefix(rel, "plots/"):
+		return "plots"
+	case strings.HasPrefix(rel, "recordings/"):
+		return "recordings"
+	case strings.HasSuffix(rel, ".log"):
+		return "logs"
+	}
+	return ""
+}
+
+// true if the file has been uploaded, verified, and has not changed since
+func uploadVerified(manifest *types.Manifest, folder, rel string) bool {
+	if manifest == nil || manifest.Storage == nil {
+		return false
+	}
+	for _, object := range manifest.Storage.Objects {
+		if object.Path == rel && object.Status == types.UploadVerified {
+			sum, err := storage.ComputeChecksum(filepath.Join(folder, rel))
+			return err == nil && hex.EncodeToString(sum.SHA256) == object.SHA256
+		}
+	}
+	return false
+}
+
+// returns an empty reason if the file is kept
+func retentionReason(policy config.RetentionPolicy, category string, age time.Duration, uploaded func() bool) string {
+	maxAge := 0
+	switch category {
+	case "plots":
+		maxAge = policy.Plots
+	case "logs":
+		maxAge = policy.Logs
+	case "recordings":
+		maxAge = policy.Recordings
+		if policy.UploadedRecordings && uploaded() {
+			return "uploaded"
+		}
+	}
+	if maxAge > 0 && age > time.Duration(maxAge)*day {
+		return "expired"
+	}
+	return ""
+}
+
+func (r *RetentionReport) applyToInteraction(namespace, interaction string, policy config.RetentionPolicy) {
+	folder := InteractionFolder(namespace, interaction)
+	manifest, _ := readManifest(folder)
+	filepath.WalkDir(folder, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(folder, p)
+		rel = filepath.ToSlash(rel)
+		category := retentionCategory(rel)
+		if len(category) == 0 {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		reason := retentionReason(policy, category, r.At.Sub(info.ModTime()), func() bool {
+			return uploadVerified(manifest, folder, rel)
+		})
+		if len(reason) == 0 {
+			return nil
+		}
+		if !r.DryRun {
+			if err := os.Remove(p); err != nil {
+				r.Errors = append(r.Errors, err.Error())
+				return nil
+			}
+			Audit().Info().
+				Str("context", "data").
+				Str("namespace", namespace).
+				Str("interaction", interaction).
+				Str("file", rel).
+				Str("category", category).
+				Str("reason", reason).
+				Int64("size", info.Size()).
+				Msg("retention_file_deleted")
+		}
+		r.Removed = append(r.Removed, RemovedFile{namespace, interaction, rel, category, reason, info.Size()})
+		r.Bytes += info.Size()
+		return nil
+	})
+}
+
+// ApplyRetention deletes files according to the retention policies of config/data.yml,
+// leaving running interactions untouched. If dryRun, files are only listed
+func ApplyRetention(skip Skipper, dryRun bool) RetentionReport {
+	r := RetentionReport{
+		At:      time.Now(),
+		DryRun:  dryRun,
+		Removed: []RemovedFile{},
+		Skipped: []string{},
+		Errors:  []string{},
+	}
+	namespaces, err := subFolders(Root)
+	if err != nil {
+		r.Errors = append(r.Errors, err.Error())
+		return r
+	}
+	for _, namespace := range namespaces {
+		policy := config.Data.RetentionFor(namespace)
+		if policy.IsZero() {
+			continue
+		}
+		interactions, _ := subFolders(NamespaceFolder(namespace))
+		for _, interaction := range interactions {
+			if skip != nil && skip(namespace, interaction) {
+				r.Skipped = append(r.Skipped, namespace+"/"+interaction)
+				continue
+			}
+			r.applyToInteraction(namespace, interaction, policy)
+		}
+	}
+	if !dryRun {
+		Audit().Info().
+			Str("context", "data").
+			Int("count", len(r.Removed)).
+			Int64("bytes", r.Bytes).
+			Strs("skipped", r.Skipped).
+			Strs("errors", r.Errors).
+			Msg("retention_applied")
+	}
+	return r
+}
+
+func retentionEnabled() bool {
+	for _, policy := range config.Data.Retention {
+		if !policy.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// StartRetentionJob applies retention policies every config.Data.RetentionPeriod minutes
+func StartRetentionJob(skip Skipper) {
+	if config.Data.RetentionPeriod <= 0 || !retentionEnabled() {
+		log.Info().Str("context", "data").Msg("retention_job_disabled")
+		return
+	}
+	log.Info().Str("context", "data").Int("period", config.Data.RetentionPeriod).Msg("retention_job_started")
+	go func() {
+		ticker := time.NewTicker(time.Duration(config.Data.RetentionPeriod) * time.Minute)
+		defer ticker.Stop()
+		for {
+			ApplyRetention(skip, false)
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,59 @@
+package datastore
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/storage"
+)
+
+func TestApplyRetention(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	defer os.Chdir(wd)
+	retention := config.Data.Retention
+	config.Data.Retention = map[string]config.RetentionPolicy{
+		"ns": {Plots: 7, Logs: 90, UploadedRecordings: true},
+	}
+	defer func() { config.Data.Retention = retention }()
+
+	old := time.Now().Add(-10 * day)
+	writeTestFile(t, "data/ns/done/plots/video-u1-bitrates.pdf", "plot")
+	os.Chtimes("data/ns/done/plots/video-u1-bitrates.pdf", old, old)
+	writeTestFile(t, "data/ns/done/interaction.log", "log")
+	os.Chtimes("data/ns/done/interaction.log", old, old)
+	writeTestFile(t, "data/ns/done/recordings/uploaded.mkv", "uploaded")
+	writeTestFile(t, "data/ns/done/recordings/failed.mkv", "failed")
+	sum, _ := storage.ComputeChecksum("data/ns/done/recordings/uploaded.mkv")
+	writeTestFile(t, "data/ns/done/"+ManifestFile, fmt.Sprintf(`{"storage":{"objects":[
+		{"path":"recordings/uploaded.mkv","status":"verified","sha256":"%v"},
+		{"path":"recordings/failed.mkv","status":"failed"}
+	]}}`, hex.EncodeToString(sum.SHA256)))
+	writeTestFile(t, "data/ns/running/plots/audio-u1-bitrates.pdf", "plot")
+	os.Chtimes("data/ns/running/plots/audio-u1-bitrates.pdf", old, old)
+	writeTestFile(t, "data/other/done/plots/audio-u1-bitrates.pdf", "plot")
+	os.Chtimes("data/other/done/plots/audio-u1-bitrates.pdf", old, old)
+
+	skip := func(namespace, interaction string) bool { return interaction == "running" }
+	report := ApplyRetention(skip, false)
+
+	removed := map[string]string{}
+	for _, f := range report.Removed {
+		removed[f.Namespace+"/"+f.Interaction+"/"+f.Path] = f.Reason
+	}
+	if len(removed) != 2 || removed["ns/done/plots/video-u1-bitrates.pdf"] != "expired" || removed["ns/done/recordings/uploaded.mkv"] != "uploaded" {
+		t.Errorf("unexpected removed files: %v", removed)
+	}
+	for _, kept := range []string{"ns/done/interaction.log", "ns/done/recordings/failed.mkv", "ns/running/plots/audio-u1-bitrates.pdf", "other/done/plots/audio-u1-bitrates.pdf"} {
+		if _, err := os.Stat("data/" + kept); err != nil {
+			t.Errorf("%v should be kept", kept)
+		}
+	}
+	if len(report.Skipped) != 1 {
+		t.Errorf("running interaction should be skipped")
+	}
+}
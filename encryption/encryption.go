@@ -0,0 +1,263 @@
+// Package encryption encrypts recordings at rest for a recipient X25519 public key, so that
+// only the owner of the private key can decrypt them.
+//
+// Encrypted files start with a header (magic string, ephemeral X25519 public key, chunk size)
+// followed by AES-256-GCM sealed chunks. The AES key is derived (HKDF-SHA256) from the
+// X25519 shared secret between the ephemeral key and the recipient key. Each chunk nonce
+// contains the chunk counter and a final chunk flag, so that reordered, altered or
+// truncated files fail to decrypt.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	Scheme    = "x25519-hkdf-sha256-aes256gcm-stream"
+	Extension = ".enc"
+	magic     = "DUCKSOUP-ENC-V1\n"
+	chunkSize = 64 * 1024
+	keyInfo   = "ducksoup-recording-v1"
+)
+
+var (
+	ErrInvalidKey    = errors.New("invalid X25519 key")
+	ErrInvalidFormat = errors.New("invalid encrypted file format")
+	ErrTruncated     = errors.New("encrypted file is truncated")
+)
+
+// ParsePublicKey decodes a base64 (standard encoding) X25519 public key
+func ParsePublicKey(encoded string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	key, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// ParsePrivateKey decodes a base64 (standard encoding) X25519 private key
+func ParsePrivateKey(encoded string) (*ecdh.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+func GenerateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+func EncodeKey(raw []byte) string {
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// Fingerprint identifies a public key (hex SHA-256 of its bytes)
+func Fingerprint(key *ecdh.PublicKey) string {
+	sum := sha256.Sum256(key.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// HKDF-SHA256 (RFC 5869) limited to a single output block
+func deriveKey(secret, salt []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(keyInfo))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+func newAEAD(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(shared, append(append([]byte{}, ephemeral...), recipient...)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// Encrypt reads src until EOF and writes its encrypted form to dst
+func Encrypt(dst io.Writer, src io.Reader, recipient *ecdh.PublicKey) error {
+	ephemeral, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(shared, ephemeral.PublicKey().Bytes(), recipient.Bytes())
+	if err != nil {
+		return err
+	}
+
+	header := append([]byte(magic), ephemeral.PublicKey().Bytes()...)
+	header = binary.BigEndian.AppendUint32(header, chunkSize)
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	// one chunk is read ahead to know which one is the final one
+	current := make([]byte, chunkSize)
+	next := make([]byte, chunkSize)
+	n, err := io.ReadFull(src, current)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	sealed := make([]byte, 0, chunkSize+aead.Overhead())
+	for counter := uint64(0); ; counter++ {
+		final := n < chunkSize
+		var m int
+		if !final {
+			m, err = io.ReadFull(src, next)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			final = m == 0
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(counter, final), current[:n], nil)
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		current, next, n = next, current, m
+	}
+}
+
+// Decrypt reads the encrypted src and writes the decrypted content to dst. On error,
+// dst may have received partial content that should be discarded
+func Decrypt(dst io.Writer, src io.Reader, key *ecdh.PrivateKey) error {
+	header := make([]byte, len(magic)+32+4)
+	if _, err := io.ReadFull(src, header); err != nil || string(header[:len(magic)]) != magic {
+		return ErrInvalidFormat
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(header[len(magic) : len(magic)+32])
+	if err != nil {
+		return ErrInvalidFormat
+	}
+	size := int(binary.BigEndian.Uint32(header[len(magic)+32:]))
+	if size <= 0 || size > 16*1024*1024 {
+		return ErrInvalidFormat
+	}
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(shared, ephemeral.Bytes(), key.PublicKey().Bytes())
+	if err != nil {
+		return err
+	}
+
+	sealedSize := size + aead.Overhead()
+	current := make([]byte, sealedSize)
+	next := make([]byte, sealedSize)
+	n, err := io.ReadFull(src, current)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	plain := make([]byte, 0, size)
+	for counter := uint64(0); ; counter++ {
+		final := n < sealedSize
+		var m int
+		if !final {
+			m, err = io.ReadFull(src, next)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			final = m == 0
+		}
+		plain, err = aead.Open(plain[:0], chunkNonce(counter, final), current[:n], nil)
+		if err != nil {
+			if final {
+				// a missing final chunk shows as a non-final chunk authenticated with the final flag
+				return ErrTruncated
+			}
+			return err
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		current, next, n = next, current, m
+	}
+}
+
+// EncryptFile writes src encrypted to dst (removed on failure), leaving src untouched
+func EncryptFile(dst, src string, recipient *ecdh.PublicKey) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	if err = Encrypt(out, in, recipient); err != nil {
+		return
+	}
+	return out.Sync()
+}
+
+// DecryptFile writes src decrypted to dst (removed on failure)
+func DecryptFile(dst, src string, key *ecdh.PrivateKey) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	return Decrypt(out, in, key)
+}
@@ -0,0 +1,54 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, _ := GenerateKey()
+	other, _ := GenerateKey()
+
+	for _, size := range []int{0, 10, chunkSize, 3*chunkSize + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		var encrypted bytes.Buffer
+		if err := Encrypt(&encrypted, bytes.NewReader(plain), key.PublicKey()); err != nil {
+			t.Fatal(err)
+		}
+
+		var decrypted bytes.Buffer
+		if err := Decrypt(&decrypted, bytes.NewReader(encrypted.Bytes()), key); err != nil || !bytes.Equal(decrypted.Bytes(), plain) {
+			t.Errorf("[size %v] round trip failed: %v", size, err)
+		}
+		if err := Decrypt(&bytes.Buffer{}, bytes.NewReader(encrypted.Bytes()), other); err == nil {
+			t.Errorf("[size %v] decryption with another key should fail", size)
+		}
+		if size > chunkSize {
+			truncated := encrypted.Bytes()[:len(magic)+36+chunkSize+16]
+			if err := Decrypt(&bytes.Buffer{}, bytes.NewReader(truncated), key); err != ErrTruncated {
+				t.Errorf("[size %v] truncation should be detected, got: %v", size, err)
+			}
+			altered := bytes.Clone(encrypted.Bytes())
+			altered[len(altered)-1]++
+			if err := Decrypt(&bytes.Buffer{}, bytes.NewReader(altered), key); err == nil {
+				t.Errorf("[size %v] alteration should be detected", size)
+			}
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	key, _ := GenerateKey()
+	pub, err := ParsePublicKey(EncodeKey(key.PublicKey().Bytes()))
+	if err != nil || Fingerprint(pub) != Fingerprint(key.PublicKey()) {
+		t.Errorf("public key parsing failed: %v", err)
+	}
+	if _, err := ParsePrivateKey(EncodeKey(key.Bytes()) + "\n"); err != nil {
+		t.Errorf("private key parsing failed: %v", err)
+	}
+	if _, err := ParsePublicKey("not a key"); err != ErrInvalidKey {
+		t.Errorf("invalid key should be rejected")
+	}
+}
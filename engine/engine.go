@@ -3,10 +3,12 @@ package engine
 // inspired by https://github.com/jech/galene group package
 
 import (
+	"fmt"
 	"regexp"
 
 	"github.com/ducksouplab/ducksoup/config"
 	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/types"
 	"github.com/pion/ice/v2"
 	"github.com/pion/interceptor"
 	"github.com/pion/interceptor/pkg/cc"
@@ -25,11 +27,10 @@ var (
 	maxBitrate        int
 	videoRTCPFeedback []webrtc.RTCPFeedback
 	// exported
-	OpusCodecs []webrtc.RTPCodecParameters
 	H264Codecs []webrtc.RTPCodecParameters
 	VP8Codecs  []webrtc.RTPCodecParameters
-	// VP9 is not supported for the time being (GStreamer pipelines remained to be defined)
 	VP9Codecs  []webrtc.RTPCodecParameters
+	AV1Codecs  []webrtc.RTPCodecParameters
 	ssrcRegexp *regexp.Regexp
 )
 
@@ -48,17 +49,6 @@ func init() {
 		{Type: "nack", Parameter: "pli"},
 		{Type: "transport-cc", Parameter: ""},
 	}
-	OpusCodecs = []webrtc.RTPCodecParameters{
-		{
-			RTPCodecCapability: webrtc.RTPCodecCapability{
-				MimeType:     "audio/opus",
-				ClockRate:    48000,
-				Channels:     2,
-				SDPFmtpLine:  "minptime=10;useinbandfec=1;stereo=0",
-				RTCPFeedback: nil},
-			PayloadType: 111,
-		},
-	}
 	// Constrained-baseline if forced, other profiles being commented out
 	H264Codecs = []webrtc.RTPCodecParameters{
 		// {
@@ -128,22 +118,65 @@ func init() {
 				RTCPFeedback: videoRTCPFeedback},
 			PayloadType: 98,
 		},
+	}
+	AV1Codecs = []webrtc.RTPCodecParameters{
 		{
 			RTPCodecCapability: webrtc.RTPCodecCapability{
-				MimeType:     "video/VP9",
+				MimeType:     "video/AV1",
 				ClockRate:    90000,
 				Channels:     0,
-				SDPFmtpLine:  "profile-id=1",
+				SDPFmtpLine:  "level-idx=5;profile=0;tier=0",
 				RTCPFeedback: videoRTCPFeedback},
-			PayloadType: 100,
+			PayloadType: 45,
+		},
+	}
+}
+
+func flag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// see https://datatracker.ietf.org/doc/html/rfc7587#section-6.1 (ptime is not a fmtp parameter
+// but is read from there by browsers)
+func OpusFmtpLine(opus types.OpusConfig) string {
+	return fmt.Sprintf(
+		"minptime=10;ptime=%d;useinbandfec=%d;usedtx=%d;stereo=%d;sprop-stereo=%d;maxaveragebitrate=%d",
+		opus.PTime, flag(opus.FEC), flag(opus.DTX), flag(opus.Stereo), flag(opus.Stereo), opus.MaxBitrate,
+	)
+}
+
+func OpusCodecs(opus types.OpusConfig) []webrtc.RTPCodecParameters {
+	return []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     "audio/opus",
+				ClockRate:    48000,
+				Channels:     2,
+				SDPFmtpLine:  OpusFmtpLine(opus),
+				RTCPFeedback: nil},
+			PayloadType: 111,
 		},
 	}
 }
 
+// see https://datatracker.ietf.org/doc/html/rfc2198, blocks being Opus ones
+var OpusREDCodec = webrtc.RTPCodecParameters{
+	RTPCodecCapability: webrtc.RTPCodecCapability{
+		MimeType:     "audio/red",
+		ClockRate:    48000,
+		Channels:     2,
+		SDPFmtpLine:  "111/111",
+		RTCPFeedback: nil},
+	PayloadType: 63,
+}
+
 // APIs are used to create peer connections, possible codecs are set once for all (at API level)
 // but preferred codecs for a given track are set at transceiver level
 // currently NewWebRTCAPI (rather than pion default one) prevents a freeze/lag observed after ~20 seconds
-func NewWebRTCAPI(estimatorCh chan cc.BandwidthEstimator, logger zerolog.Logger) (*webrtc.API, error) {
+func NewWebRTCAPI(opus types.OpusConfig, twcc bool, estimatorCh chan cc.BandwidthEstimator, logger zerolog.Logger) (*webrtc.API, error) {
 	s := webrtc.SettingEngine{}
 	s.SetSRTPReplayProtectionWindow(512)
 	s.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
@@ -152,11 +185,16 @@ func NewWebRTCAPI(estimatorCh chan cc.BandwidthEstimator, logger zerolog.Logger)
 	// initialize media engine
 	m := &webrtc.MediaEngine{}
 	// always include opus
-	for _, c := range OpusCodecs {
+	for _, c := range OpusCodecs(opus) {
 		if err := m.RegisterCodec(c, webrtc.RTPCodecTypeAudio); err != nil {
 			return nil, err
 		}
 	}
+	if opus.RED {
+		if err := m.RegisterCodec(OpusREDCodec, webrtc.RTPCodecTypeAudio); err != nil {
+			return nil, err
+		}
+	}
 	// select video codecs
 	for _, c := range VP8Codecs {
 		if err := m.RegisterCodec(c, webrtc.RTPCodecTypeVideo); err != nil {
@@ -168,12 +206,22 @@ func NewWebRTCAPI(estimatorCh chan cc.BandwidthEstimator, logger zerolog.Logger)
 			return nil, err
 		}
 	}
+	for _, c := range VP9Codecs {
+		if err := m.RegisterCodec(c, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range AV1Codecs {
+		if err := m.RegisterCodec(c, webrtc.RTPCodecTypeVideo); err != nil {
+			return nil, err
+		}
+	}
 
 	// initialize interceptor registry
 	i := &interceptor.Registry{}
 
 	// enhance them
-	if err := configureAPIOptions(m, i, estimatorCh, logger); err != nil {
+	if err := configureAPIOptions(m, i, twcc, estimatorCh, logger); err != nil {
 		logger.Error().Err(err).Str("context", "peer").Msg("configure_api_failed")
 	}
 
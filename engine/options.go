@@ -8,6 +8,7 @@ import (
 	"github.com/pion/interceptor"
 	"github.com/pion/interceptor/pkg/cc"
 	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/interceptor/pkg/nack"
 	"github.com/pion/interceptor/pkg/packetdump"
 	"github.com/pion/interceptor/pkg/twcc"
 	"github.com/pion/sdp/v3"
@@ -16,7 +17,8 @@ import (
 )
 
 // adapted from https://github.com/pion/webrtc/blob/v3.2.8/interceptor.go
-func configureAPIOptions(m *webrtc.MediaEngine, r *interceptor.Registry, estimatorCh chan cc.BandwidthEstimator, logger zerolog.Logger) error {
+// if twcc is false, transport-wide congestion control is not negotiated so that browsers send REMB
+func configureAPIOptions(m *webrtc.MediaEngine, r *interceptor.Registry, twcc bool, estimatorCh chan cc.BandwidthEstimator, logger zerolog.Logger) error {
 	// order matters!
 	if env.LogLevel == 4 {
 		if err := configurePacketDump(r, logger); err != nil {
@@ -24,7 +26,7 @@ func configureAPIOptions(m *webrtc.MediaEngine, r *interceptor.Registry, estimat
 		}
 	}
 
-	if err := webrtc.ConfigureNack(m, r); err != nil {
+	if err := configureNack(m, r); err != nil {
 		return err
 	}
 
@@ -32,7 +34,7 @@ func configureAPIOptions(m *webrtc.MediaEngine, r *interceptor.Registry, estimat
 		return err
 	}
 
-	if env.GCC {
+	if env.GCC && twcc {
 		// keep configurations here in that order
 		if err := configureEstimator(r, estimatorCh); err != nil {
 			return err
@@ -42,11 +44,13 @@ func configureAPIOptions(m *webrtc.MediaEngine, r *interceptor.Registry, estimat
 		estimatorCh <- nil
 	}
 
-	if err := webrtc.ConfigureTWCCHeaderExtensionSender(m, r); err != nil {
-		return err
+	if twcc {
+		if err := webrtc.ConfigureTWCCHeaderExtensionSender(m, r); err != nil {
+			return err
+		}
 	}
 
-	if env.GenerateTWCC {
+	if env.GenerateTWCC && twcc {
 		if err := configureTWCCSender(m, r); err != nil {
 			return err
 		}
@@ -63,6 +67,20 @@ func configureAPIOptions(m *webrtc.MediaEngine, r *interceptor.Registry, estimat
 	return nil
 }
 
+// adapted from webrtc.ConfigureNack, with our own responder (see retransmission.go)
+func configureNack(m *webrtc.MediaEngine, r *interceptor.Registry) error {
+	generator, err := nack.NewGeneratorInterceptor()
+	if err != nil {
+		return err
+	}
+
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack"}, webrtc.RTPCodecTypeVideo)
+	m.RegisterFeedback(webrtc.RTCPFeedback{Type: "nack", Parameter: "pli"}, webrtc.RTPCodecTypeVideo)
+	r.Add(newRetransmissionFactory(config.SFU.Common.RetransmissionBuffer))
+	r.Add(generator)
+	return nil
+}
+
 func configureEstimator(r *interceptor.Registry, estimatorCh chan cc.BandwidthEstimator) error {
 	// Create a Congestion Controller. This analyzes inbound and outbound data and provides
 	// suggestions on how much we should be sending.
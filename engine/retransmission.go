@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// Retransmission of server-sent video packets in response to NACKs (RFC 4585), replacing pion
+// nack responder to count losses and recoveries. Since pion v3 does not signal RTX streams
+// (RFC 4588, with their own SSRC), packets are resent on the media SSRC
+
+const defaultRetransmissionBufferSize = 1024
+
+type RetransmissionStats struct {
+	Nacked        uint64 `json:"nacked"`        // packets reported lost by the receiver
+	Retransmitted uint64 `json:"retransmitted"` // packets found in the buffer and resent
+	Missed        uint64 `json:"missed"`        // packets no longer in the buffer
+}
+
+type retransmissionStream struct {
+	sync.Mutex
+	packets []*rtp.Packet // ring buffer indexed by sequence number
+	writer  interceptor.RTPWriter
+	stats   RetransmissionStats
+}
+
+// SSRCs of outgoing streams are randomly chosen so we index them server-wide
+var retransmissionIndex = struct {
+	sync.Mutex
+	streams map[uint32]*retransmissionStream
+}{streams: map[uint32]*retransmissionStream{}}
+
+// RetransmissionStatsFor returns cumulative stats of the outgoing stream identified by ssrc
+func RetransmissionStatsFor(ssrc uint32) (stats RetransmissionStats, ok bool) {
+	retransmissionIndex.Lock()
+	s, ok := retransmissionIndex.streams[ssrc]
+	retransmissionIndex.Unlock()
+	if !ok {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.stats, true
+}
+
+type retransmissionFactory struct {
+	size int
+}
+
+// size is rounded up to a power of 2 so that the ring buffer wraps with sequence numbers
+func newRetransmissionFactory(size int) *retransmissionFactory {
+	if size <= 0 {
+		size = defaultRetransmissionBufferSize
+	}
+	rounded := 1
+	for rounded < size && rounded < 1<<15 {
+		rounded <<= 1
+	}
+	return &retransmissionFactory{size: rounded}
+}
+
+func (f *retransmissionFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &retransmissionInterceptor{
+		size:    f.size,
+		streams: map[uint32]*retransmissionStream{},
+	}, nil
+}
+
+type retransmissionInterceptor struct {
+	interceptor.NoOp
+	size    int
+	mu      sync.Mutex
+	streams map[uint32]*retransmissionStream
+}
+
+func supportsNack(info *interceptor.StreamInfo) bool {
+	for _, fb := range info.RTCPFeedback {
+		if fb.Type == "nack" && fb.Parameter == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (ri *retransmissionInterceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return 0, nil, err
+		}
+		if attr == nil {
+			attr = make(interceptor.Attributes)
+		}
+		pkts, err := attr.GetRTCPPackets(b[:n])
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, packet := range pkts {
+			if nack, ok := packet.(*rtcp.TransportLayerNack); ok {
+				go ri.resend(nack)
+			}
+		}
+		return n, attr, nil
+	})
+}
+
+func (ri *retransmissionInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	if !strings.HasPrefix(strings.ToLower(info.MimeType), "video/") || !supportsNack(info) {
+		return writer
+	}
+
+	s := &retransmissionStream{packets: make([]*rtp.Packet, ri.size), writer: writer}
+	ri.mu.Lock()
+	ri.streams[info.SSRC] = s
+	ri.mu.Unlock()
+	retransmissionIndex.Lock()
+	retransmissionIndex.streams[info.SSRC] = s
+	retransmissionIndex.Unlock()
+
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		s.add(header, payload)
+		return writer.Write(header, payload, attributes)
+	})
+}
+
+func (ri *retransmissionInterceptor) UnbindLocalStream(info *interceptor.StreamInfo) {
+	ri.mu.Lock()
+	delete(ri.streams, info.SSRC)
+	ri.mu.Unlock()
+	retransmissionIndex.Lock()
+	delete(retransmissionIndex.streams, info.SSRC)
+	retransmissionIndex.Unlock()
+}
+
+func (ri *retransmissionInterceptor) resend(nack *rtcp.TransportLayerNack) {
+	ri.mu.Lock()
+	s, ok := ri.streams[nack.MediaSSRC]
+	ri.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	for _, pair := range nack.Nacks {
+		pair.Range(func(seq uint16) bool {
+			if p := s.get(seq); p != nil {
+				s.writer.Write(&p.Header, p.Payload, interceptor.Attributes{})
+			}
+			return true
+		})
+	}
+}
+
+// packets are copied since buffers may be reused by the caller
+func (s *retransmissionStream) add(header *rtp.Header, payload []byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	index := int(header.SequenceNumber) % len(s.packets)
+	p := s.packets[index]
+	if p == nil {
+		p = &rtp.Packet{}
+		s.packets[index] = p
+	}
+	p.Header = header.Clone()
+	p.Payload = append(p.Payload[:0], payload...)
+}
+
+// returns a copy of the packet and updates stats
+func (s *retransmissionStream) get(seq uint16) *rtp.Packet {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stats.Nacked++
+	p := s.packets[int(seq)%len(s.packets)]
+	if p == nil || p.SequenceNumber != seq {
+		s.stats.Missed++
+		return nil
+	}
+	s.stats.Retransmitted++
+	return &rtp.Packet{Header: p.Header.Clone(), Payload: append([]byte(nil), p.Payload...)}
+}
@@ -15,9 +15,10 @@ const (
 	TimeFormat = "20060102-150405.000"
 )
 
-var ExplicitHostCandidate, ForceOverlay, GCC, GSTTracking, GeneratePlots, GenerateTWCC, InterceptGSTLogs, LogStdout, NoRecording, NVCodec, NVCuda bool
+var ExplicitHostCandidate, ForceOverlay, GCC, GSTTracking, GeneratePlots, GenerateTWCC, InterceptGSTLogs, LogStdout, NoRecording, NVCodec, NVCuda, StorageDeleteLocal bool
 var JitterBuffer, LogLevel int
-var LogFile, Mode, Port, PublicIP, TestLogin, TestPassword, TurnAddress, TurnPort, WebPrefix string
+var DataLogin, DataPassword, LogFile, Mode, Port, PseudonymDir, PseudonymSecret, PublicIP, TestLogin, TestPassword, TurnAddress, TurnPort, WebPrefix string
+var Storage, S3AccessKey, S3Bucket, S3Endpoint, S3Prefix, S3Region, S3SecretKey string
 var AllowedWSOrigins, STUNServerURLS []string
 
 func getenvOr(key, fallback string) string {
@@ -79,6 +80,9 @@ func init() {
 	if strings.ToLower(os.Getenv("DUCKSOUP_NVCUDA")) == "true" {
 		NVCuda = true
 	}
+	if strings.ToLower(os.Getenv("DUCKSOUP_STORAGE_DELETE_LOCAL")) == "true" {
+		StorageDeleteLocal = true
+	}
 
 	// uints
 	var err error
@@ -107,6 +111,20 @@ func init() {
 	// basic Auth
 	TestLogin = getenvOr("DUCKSOUP_TEST_LOGIN", "ducksoup")
 	TestPassword = getenvOr("DUCKSOUP_TEST_PASSWORD", "ducksoup")
+	// no defaults: the data API is disabled if not set
+	DataLogin = os.Getenv("DUCKSOUP_DATA_LOGIN")
+	DataPassword = os.Getenv("DUCKSOUP_DATA_PASSWORD")
+	// pseudonyms are disabled if no secret is set
+	PseudonymSecret = os.Getenv("DUCKSOUP_PSEUDONYM_SECRET")
+	PseudonymDir = getenvOr("DUCKSOUP_PSEUDONYM_DIR", "pseudonyms")
+	// remote storage
+	Storage = strings.ToLower(os.Getenv("DUCKSOUP_STORAGE"))
+	S3Endpoint = os.Getenv("DUCKSOUP_S3_ENDPOINT")
+	S3Bucket = os.Getenv("DUCKSOUP_S3_BUCKET")
+	S3Region = os.Getenv("DUCKSOUP_S3_REGION")
+	S3AccessKey = os.Getenv("DUCKSOUP_S3_ACCESS_KEY")
+	S3SecretKey = os.Getenv("DUCKSOUP_S3_SECRET_KEY")
+	S3Prefix = strings.Trim(os.Getenv("DUCKSOUP_S3_PREFIX"), "/")
 	// origins
 	originsUnsplit := os.Getenv("DUCKSOUP_ALLOWED_WS_ORIGINS")
 	if len(originsUnsplit) > 0 {
@@ -19,6 +19,12 @@ const DEFAULT_CONSTRAINTS = {
 const MAX_VIDEO_BITRATE = 1500000;
 const MAX_AUDIO_BITRATE = 64000;
 const BITRATE_RAMP_DURATION = 3000;
+// if simulcast is enabled, the server offers these rids (from low to high quality)
+const SIMULCAST_LAYERS = {
+  l: { scaleResolutionDownBy: 4, bitrateShare: 0.15 },
+  m: { scaleResolutionDownBy: 2, bitrateShare: 0.35 },
+  h: { scaleResolutionDownBy: 1, bitrateShare: 1 },
+};
 
 // Chrome 122 fix
 
@@ -86,9 +92,25 @@ const parseJoinPayload = (peerOptions) => {
     recordingMode,
     gpu,
     overlay,
+    noRecording,
+    opusTemplate,
+    opus,
+    simulcast,
+    bandwidthController,
+    fixedBitrate,
+    bitrateAggregator,
+    bitratePercentile,
+    bitrateWeights,
+    impairment,
+    delay,
+    avOffset,
+    recordAVOffset,
+    videoInterventions,
+    latencyEqualization,
+    reactions,
   } = peerOptions;
   // null fields will be deleted by clean()
-  if (!["VP8", "H264"].includes(videoFormat)) videoFormat = null;
+  if (!["VP8", "VP9", "AV1", "H264"].includes(videoFormat)) videoFormat = null;
   audioOnly = !!audioOnly ? true : null;
   if (isNaN(size)) size = null;
   if (isNaN(width)) width = null;
@@ -96,6 +118,19 @@ const parseJoinPayload = (peerOptions) => {
   if (isNaN(framerate)) framerate = null;
   if (!gpu) gpu = null;
   if (!overlay) overlay = null;
+  noRecording = !!noRecording ? true : null;
+  if (typeof opus !== "object") opus = null;
+  simulcast = !!simulcast ? true : null;
+  if (isNaN(fixedBitrate)) fixedBitrate = null;
+  if (isNaN(bitratePercentile)) bitratePercentile = null;
+  if (typeof bitrateWeights !== "object") bitrateWeights = null;
+  if (typeof impairment !== "object") impairment = null;
+  if (isNaN(delay)) delay = null;
+  if (isNaN(avOffset)) avOffset = null;
+  recordAVOffset = !!recordAVOffset ? true : null;
+  if (!Array.isArray(videoInterventions)) videoInterventions = null;
+  latencyEqualization = !!latencyEqualization ? true : null;
+  if (!Array.isArray(reactions)) reactions = null;
 
   return clean({
     interactionName,
@@ -113,20 +148,46 @@ const parseJoinPayload = (peerOptions) => {
     recordingMode,
     gpu,
     overlay,
+    noRecording,
+    opusTemplate,
+    opus,
+    simulcast,
+    bandwidthController,
+    fixedBitrate,
+    bitrateAggregator,
+    bitratePercentile,
+    bitrateWeights,
+    impairment,
+    delay,
+    avOffset,
+    recordAVOffset,
+    videoInterventions,
+    latencyEqualization,
+    reactions,
   });
 };
 
-const preferMono = (sdp) => {
+// stereo is chosen server-side (Opus settings of the interaction)
+const offersStereo = (sdp) => {
+  return sdp
+    .split("\r\n")
+    .some((line) => line.startsWith("a=fmtp:111") && /[ ;]stereo=1/.test(line));
+};
+
+const setStereo = (sdp, stereo) => {
   // https://datatracker.ietf.org/doc/html/rfc7587#section-6.1
+  const value = stereo ? 1 : 0;
   return sdp
     .split("\r\n")
     .map((line) => {
       if (line.startsWith("a=fmtp:111")) {
-        if (line.includes("stereo=")) {
-          return line.replace("stereo=1", "stereo=0");
-        } else {
-          return `${line};stereo=0`;
+        let output = line.includes("stereo=")
+          ? line.replace(/([ ;])stereo=[01]/, `$1stereo=${value}`)
+          : `${line};stereo=${value}`;
+        if (stereo && !output.includes("sprop-stereo=")) {
+          output = `${output};sprop-stereo=1`;
         }
+        return output;
       } else {
         return line;
       }
@@ -171,8 +232,8 @@ const processOffer = (sdp) => {
   return output;
 };
 
-const processAnswer = (sdp) => {
-  let output = preferMono(sdp);
+const processAnswer = (sdp, stereo) => {
+  let output = setStereo(sdp, stereo);
   // output = addTWCC(output);
   return output;
 };
@@ -194,6 +255,11 @@ const looseJSONParse = (str) => {
 
 const state = {};
 
+const bitrateShare = (encoding) => {
+  const layer = SIMULCAST_LAYERS[encoding.rid];
+  return layer ? layer.bitrateShare : 1;
+};
+
 const rampBitrate = (pc) => {
   const STEPS = 8;
   let step = 0;
@@ -205,7 +271,9 @@ const rampBitrate = (pc) => {
       if (!params.encodings) params.encodings = [{}]; // needed for FF
       for (const encoding of params.encodings) {
         if (sender.track.kind === "video") {
-          encoding.maxBitrate = (MAX_VIDEO_BITRATE * step) / STEPS;
+          const layer = SIMULCAST_LAYERS[encoding.rid];
+          if (layer) encoding.scaleResolutionDownBy = layer.scaleResolutionDownBy;
+          encoding.maxBitrate = (MAX_VIDEO_BITRATE * step * bitrateShare(encoding)) / STEPS;
         } else if (step === 1) {
           // do once for audio
           encoding.maxBitrate = MAX_AUDIO_BITRATE;
@@ -240,6 +308,7 @@ class DuckSoup {
   #signalingUrl;
   #callback;
   #pendingCandidates;
+  #latencyEqualization;
 
   // API
 
@@ -341,6 +410,49 @@ class DuckSoup {
     this.#serverSend("client_polycontrol", { name, property, kind, value: strValue });
   }
 
+  // impairment is an object with delay, jitter, loss, burst and bandwidth properties, replacing
+  // the current one (an empty object removes impairments)
+  impair(impairment, userId) {
+    if (typeof impairment !== "object") return;
+    this.#serverSend("client_impairment", {
+      ...impairment,
+      ...(userId && { userId }),
+    });
+  }
+
+  // offset in ms of audio relative to video (positive when audio is late), reached linearly in
+  // ramp ms (optional)
+  avOffset(offset, ramp, userId) {
+    if (isNaN(offset)) return;
+    this.#serverSend("client_av_offset", {
+      offset,
+      ...(ramp && { ramp }),
+      ...(userId && { userId }),
+    });
+  }
+
+  // kind is none, freeze, black or fps (with fps), for duration ms (optional, until the next
+  // intervention by default)
+  videoIntervention(kind, { duration, fps } = {}, userId) {
+    if (typeof kind !== "string") return;
+    this.#serverSend("client_video_intervention", {
+      kind,
+      ...(duration && { duration }),
+      ...(fps && { fps }),
+      ...(userId && { userId }),
+    });
+  }
+
+  // delay in ms, reached linearly in ramp ms (optional)
+  delay(delay, ramp, userId) {
+    if (isNaN(delay)) return;
+    this.#serverSend("client_delay", {
+      delay,
+      ...(ramp && { ramp }),
+      ...(userId && { userId }),
+    });
+  }
+
   // add prefix to differentiate from ducksoup.js logs
   serverLog(kind, payload) {
     this.#serverSend(`ext_${kind}`, payload);
@@ -354,7 +466,7 @@ class DuckSoup {
       if (!params.encodings) params.encodings = [{}]; // needed for FF
       for (const encoding of params.encodings) {
         if (sender.track.kind === "video") {
-          encoding.maxBitrate = maxKbps * 1000;
+          encoding.maxBitrate = maxKbps * 1000 * bitrateShare(encoding);
         }
       }
       await sender.setParameters(params);
@@ -433,8 +545,9 @@ class DuckSoup {
       const { kind, payload } = message;
 
       if (kind === "joined") {
-        const { iceServers } = payload;
+        const { iceServers, latencyEqualization } = payload;
         this.#rtcConfig = { iceServers };
+        this.#latencyEqualization = !!latencyEqualization;
         // and forward
         this.#forward(message);
       } else if (kind === "offer") {
@@ -450,7 +563,7 @@ class DuckSoup {
         }
         // create and share answer
         const answer = await this.#pc.createAnswer();
-        answer.sdp = processAnswer(answer.sdp);
+        answer.sdp = processAnswer(answer.sdp, offersStereo(offer.sdp));
         await this.#pc.setLocalDescription(answer);
         this.#serverSend("client_answer", answer);
         console.debug(`[DS] server offer sdp (length ${payload.length})\n`, offer.sdp);
@@ -469,10 +582,15 @@ class DuckSoup {
         //     track.enabled = true;
         // });
         this.#forward(message, true); // force with true since player is not already running
-        // Getting peerconnection stats is needed either for stats or debug option
-        if (this.#stats || this.#logLevel >= 1) {
+        // Getting peerconnection stats is needed either for stats, debug option or latency equalization
+        if (this.#stats || this.#logLevel >= 1 || this.#latencyEqualization) {
           this.#statsIntervalId = setInterval(() => this.#updateStats(), 1000);
         }
+      } else if (kind === "audio_level") {
+        // forwarded before start too, to check the microphone while waiting for others
+        this.#forward(message, true);
+      } else if (kind === "latency_compensation") {
+        this.#setJitterBufferTarget(payload.jitterBufferTarget);
       } else if (kind.startsWith("error")) {
         this.#forward(message);
         this.stop(4000);
@@ -641,10 +759,51 @@ class DuckSoup {
     }
   }
 
+  // ms, set on every receiver to equalize latencies across participants
+  #setJitterBufferTarget(target) {
+    if (!this.#pc || isNaN(target)) return;
+    for (const receiver of this.#pc.getReceivers()) {
+      if ("jitterBufferTarget" in receiver) {
+        receiver.jitterBufferTarget = target;
+      } else {
+        receiver.playoutDelayHint = target / 1000;
+      }
+    }
+  }
+
+  // ms, the jitter buffer delay the browser would choose without a target (the highest of all
+  // inbound tracks), since the previous report. Not reported if the browser does not expose
+  // jitterBufferMinimumDelay: jitterBufferDelay includes the target we set, and would feed back
+  // into the next target
+  #reportJitterBuffer(pcStats) {
+    const previous = this.#info.jitterBuffers || {};
+    const current = {};
+    let jitterBuffer;
+    pcStats.forEach((report) => {
+      if (report.type !== "inbound-rtp" || !report.jitterBufferEmittedCount) return;
+      if (report.jitterBufferMinimumDelay === undefined) return;
+      const delay = report.jitterBufferMinimumDelay;
+      current[report.id] = { delay, count: report.jitterBufferEmittedCount };
+      const last = previous[report.id];
+      if (last && report.jitterBufferEmittedCount > last.count) {
+        const value = ((delay - last.delay) / (report.jitterBufferEmittedCount - last.count)) * 1000;
+        jitterBuffer = Math.max(jitterBuffer ?? 0, value);
+      }
+    });
+    this.#info.jitterBuffers = current;
+    if (jitterBuffer !== undefined) {
+      this.#serverSend("client_jitter_buffer_updated", `${Math.round(jitterBuffer)}`);
+    }
+  }
+
   async #updateStats() {
     const pc = this.#pc;
     const pcStats = await pc.getStats();
 
+    if (this.#latencyEqualization) {
+      this.#reportJitterBuffer(pcStats);
+    }
+
     if (this.#logLevel >= 1) {
       pcStats.forEach((report) => {
         if (report.type === "outbound-rtp" && report.kind === "video") {
@@ -1,3 +1,5 @@
+const MAX_EVENTS = 20;
+
 // Init
 document.addEventListener("DOMContentLoaded", async () => {
   start();
@@ -27,10 +29,20 @@ const start = () => {
     console.error("connection closed");
   };
 
+  // latest server events (alerts), most recent first
+  const events = [];
+  let interactions;
+
   ws.onmessage = async (event) => {
-    let interactions = looseJSONParse(event.data).payload;
+    const { kind, payload } = looseJSONParse(event.data);
+    if (kind === "event") {
+      events.unshift(payload);
+      events.splice(MAX_EVENTS);
+    } else {
+      interactions = payload;
+    }
     document.getElementById("root").innerHTML = JSON.stringify(
-      { interactions },
+      { events, interactions },
       null,
       2
     );
@@ -385,7 +385,7 @@ function calculateMedian(arr) {
 }
 
 // Sends audio test data to backend for storage
-const sendAudioData = async (namespace, interaction, data) => {
+const sendAudioData = async (namespace, interaction, userId, data) => {
   // Add timestamp to data
   const enrichedData = {
     ...data,
@@ -399,6 +399,7 @@ const sendAudioData = async (namespace, interaction, data) => {
       body: JSON.stringify({
         namespace,
         interaction,
+        userId,
         data: enrichedData
       })
     });
@@ -532,7 +533,7 @@ const ducksoupListener = (options) => (message) => {
     signal_text.classList.add("d-none");
 
     const passed = medianNoise < 4.5 && medianVolume > 4.5;
-    sendAudioData(state.namespace, state.interactionName, {
+    sendAudioData(state.namespace, state.interactionName, state.userId, {
       noiseLevels: medianNoise,
       volumeLevels: medianVolume,
       passed: passed
@@ -9,6 +9,8 @@ import (
 	"errors"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/ducksouplab/ducksoup/env"
@@ -75,6 +77,22 @@ func goWriteVideo(cId *C.char, buffer unsafe.Pointer, bufferLen C.int) {
 	writeTo("video", cId, buffer, bufferLen)
 }
 
+//export goWriteVideoTier
+func goWriteVideoTier(cId *C.char, cTier C.int, buffer unsafe.Pointer, bufferLen C.int) {
+	id := C.GoString(cId)
+	p, ok := pipelineStoreSingleton.find(id)
+	if !ok {
+		return
+	}
+
+	if output, ok := p.videoOutput.(types.TierWriter); ok {
+		buf := C.GoBytes(buffer, bufferLen)
+		if err := output.WriteTier(int(cTier), buf); err != nil {
+			p.logger.Error().Err(err).Int("tier", int(cTier)).Msg("track_write_failed")
+		}
+	}
+}
+
 //export goRequestKeyFrame
 func goRequestKeyFrame(cId *C.char) {
 	id := C.GoString(cId)
@@ -86,6 +104,23 @@ func goRequestKeyFrame(cId *C.char) {
 	}
 }
 
+//export goAudioLevel
+func goAudioLevel(cId, cName *C.char, rms, peak C.double, age C.guint64) {
+	id := C.GoString(cId)
+	p, ok := pipelineStoreSingleton.find(id)
+	if !ok {
+		return
+	}
+
+	if output, ok := p.audioOutput.(types.AudioLevelWriter); ok {
+		source := strings.TrimPrefix(C.GoString(cName), audioLevelPrefix)
+		// silence may be reported as -inf
+		// dated with the end of the measured audio rather than the bus message handling
+		at := time.Now().Add(-time.Duration(age))
+		output.WriteAudioLevel(source, max(float64(rms), minAudioLevel), max(float64(peak), minAudioLevel), at)
+	}
+}
+
 //export goBusLog
 func goBusLog(cId, cMsg, cEl *C.char) {
 	id := C.GoString(cId)
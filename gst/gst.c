@@ -1,8 +1,10 @@
 #include <stdio.h>
+#include <string.h>
 #include <time.h>
 #include <gst/app/gstappsrc.h>
 #include <gst/app/gstappsink.h>
 #include <gst/video/video-event.h>
+#include <gst/video/video.h>
 
 #include "gst.h"
 
@@ -32,6 +34,45 @@ void log_callback(
     goDebugLog(level, (char*)file, (char*)function, line, (char*)gst_debug_message_get(message));
 }
 
+// highest value (in dB) across channels of a level message field
+static gdouble level_max(const GstStructure *s, const char *field)
+{
+    gdouble max = -G_MAXDOUBLE;
+    const GValue *list = gst_structure_get_value(s, field);
+
+    if (list) {
+        G_GNUC_BEGIN_IGNORE_DEPRECATIONS
+        GValueArray *array = (GValueArray *) g_value_get_boxed(list);
+        for (guint i = 0; i < array->n_values; i++) {
+            gdouble value = g_value_get_double(g_value_array_get_nth(array, i));
+            if (value > max) max = value;
+        }
+        G_GNUC_END_IGNORE_DEPRECATIONS
+    }
+    return max;
+}
+
+// time (ns) elapsed since the end of the audio measured by a level message, 0 if unknown
+static guint64 level_age(GstElement *pipeline, const GstStructure *s)
+{
+    GstClockTime running_time, duration, end, now;
+    GstClock *clock;
+    guint64 age = 0;
+
+    if (!gst_structure_get_clock_time(s, "running-time", &running_time) ||
+        !gst_structure_get_clock_time(s, "duration", &duration)) {
+        return 0;
+    }
+    clock = gst_element_get_clock(pipeline);
+    if (clock) {
+        end = gst_element_get_base_time(pipeline) + running_time + duration;
+        now = gst_clock_get_time(clock);
+        if (now > end) age = now - end;
+        gst_object_unref(clock);
+    }
+    return age;
+}
+
 static gboolean bus_callback(GstBus *bus, GstMessage *msg, gpointer data)
 {
     GstElement* pipeline = (GstElement*) data;
@@ -64,6 +105,14 @@ static gboolean bus_callback(GstBus *bus, GstMessage *msg, gpointer data)
         g_error_free(error);
         break;
     }
+    case GST_MESSAGE_ELEMENT: {
+        // posted by level elements (see sfu/vad.go)
+        const GstStructure *s = gst_message_get_structure(msg);
+        if (s && gst_structure_has_name(s, "level")) {
+            goAudioLevel(id, GST_OBJECT_NAME(msg->src), level_max(s, "rms"), level_max(s, "peak"), level_age(pipeline, s));
+        }
+        break;
+    }
     default:
         // g_print(">>> got message %s\n", gst_message_type_get_name (GST_MESSAGE_TYPE (msg)));
         break;
@@ -144,6 +193,35 @@ GstFlowReturn video_rtp_sink_callback(GstElement *sink, gpointer data)
     return GST_FLOW_OK;
 }
 
+GstFlowReturn video_tier_rtp_sink_callback(GstElement *sink, gpointer data)
+{
+    GstSample *sample;
+    GstBuffer *buffer;
+    GstElement *pipeline = (GstElement*) data;
+    // set when connecting the signal
+    int tier = GPOINTER_TO_INT(g_object_get_data(G_OBJECT(sink), "tier"));
+
+    // use previously set name as id
+    char *id = gst_element_get_name(pipeline);
+
+    sample = gst_app_sink_pull_sample((GstAppSink*) sink);
+    if (sample)
+    {
+        buffer = gst_sample_get_buffer(sample);
+        if (buffer)
+        {
+            GstMapInfo map;
+            gst_buffer_map(buffer, &map, GST_MAP_READ);
+            goWriteVideoTier(id, tier, map.data, map.size);
+            gst_buffer_unmap(buffer, &map);
+        }
+        gst_sample_unref(sample);
+    }
+
+    g_free(id);
+    return GST_FLOW_OK;
+}
+
 // API: functions called from Go (camelCased)
 
 GMainLoop *gstreamer_main_loop = NULL;
@@ -174,7 +252,7 @@ GstElement *gstParsePipeline(char *pipelineStr, char *id)
     return pipeline;
 }
 
-void gstStartPipeline(GstElement *pipeline, gboolean audioOnly)
+void gstStartPipeline(GstElement *pipeline, gboolean audioOnly, int tiers)
 {
     GstBus *bus = gst_pipeline_get_bus(GST_PIPELINE(pipeline));
     gst_bus_add_watch(bus, bus_callback, pipeline);
@@ -192,6 +270,19 @@ void gstStartPipeline(GstElement *pipeline, gboolean audioOnly)
         g_object_set(video_rtp_sink, "emit-signals", TRUE, NULL);
         g_signal_connect(video_rtp_sink, "new-sample", G_CALLBACK(video_rtp_sink_callback), pipeline);
         gst_object_unref(video_rtp_sink);
+
+        // lower bitrate video tiers (see tiers.go)
+        for (int tier = 0; tier < tiers; tier++) {
+            char name[32];
+            g_snprintf(name, sizeof(name), "video_rtp_sink_tier_%d", tier);
+            GstElement *tier_rtp_sink = gst_bin_get_by_name(GST_BIN(pipeline), name);
+            if (tier_rtp_sink != NULL) {
+                g_object_set_data(G_OBJECT(tier_rtp_sink), "tier", GINT_TO_POINTER(tier));
+                g_object_set(tier_rtp_sink, "emit-signals", TRUE, NULL);
+                g_signal_connect(tier_rtp_sink, "new-sample", G_CALLBACK(video_tier_rtp_sink_callback), pipeline);
+                gst_object_unref(tier_rtp_sink);
+            }
+        }
     }
 
     gst_element_set_state(pipeline, GST_STATE_PLAYING);
@@ -242,12 +333,177 @@ void gstSendPLI(GstElement *pipeline)
     gst_element_send_event(pipeline, gst_video_event_new_upstream_force_key_unit(GST_CLOCK_TIME_NONE, TRUE, 0));
 }
 
+// requests a keyframe from a given encoder only
+void gstForceKeyUnit(GstElement *pipeline, char *name)
+{
+    GstElement* el;
+
+    el = gst_bin_get_by_name(GST_BIN(pipeline), name);
+
+    if(el) {
+        gst_element_send_event(el, gst_video_event_new_upstream_force_key_unit(GST_CLOCK_TIME_NONE, TRUE, 0));
+        gst_object_unref(el);
+    }
+}
+
+// video interventions (see interventions.go), applied by a probe on the src pad of a named element
+
+#define INTERVENTION_NONE 0
+#define INTERVENTION_FREEZE 1
+#define INTERVENTION_BLACK 2
+#define INTERVENTION_FPS 3
+
+typedef struct {
+    GMutex mutex;
+    int mode;
+    int fps;
+    GstBuffer *last; // last frame let through, repeated when frozen
+    GstClockTime lastKeptPts; // with reduced fps
+} Intervention;
+
+static void intervention_free(gpointer data)
+{
+    Intervention *intervention = data;
+    if (intervention->last) {
+        gst_buffer_unref(intervention->last);
+    }
+    g_mutex_clear(&intervention->mutex);
+    g_free(intervention);
+}
+
+static void fill_black(GstPad *pad, GstBuffer *buffer)
+{
+    GstCaps *caps = gst_pad_get_current_caps(pad);
+    GstVideoInfo info;
+    GstVideoFrame frame;
+
+    if (!caps) return;
+    if (gst_video_info_from_caps(&info, caps) && gst_video_frame_map(&frame, &info, buffer, GST_MAP_WRITE)) {
+        gboolean yuv = GST_VIDEO_INFO_IS_YUV(&info);
+        for (guint plane = 0; plane < GST_VIDEO_FRAME_N_PLANES(&frame); plane++) {
+            guint8 *data = GST_VIDEO_FRAME_PLANE_DATA(&frame, plane);
+            gint stride = GST_VIDEO_FRAME_PLANE_STRIDE(&frame, plane);
+            gint rows = GST_VIDEO_FRAME_COMP_HEIGHT(&frame, plane);
+            // luma at 16, chroma at 128
+            guint8 value = yuv ? (plane == 0 ? 16 : 128) : 0;
+            memset(data, value, stride * rows);
+        }
+        gst_video_frame_unmap(&frame);
+    }
+    gst_caps_unref(caps);
+}
+
+static GstPadProbeReturn intervention_probe(GstPad *pad, GstPadProbeInfo *info, gpointer data)
+{
+    Intervention *intervention = data;
+    GstBuffer *buffer = GST_PAD_PROBE_INFO_BUFFER(info);
+    GstPadProbeReturn ret = GST_PAD_PROBE_OK;
+
+    g_mutex_lock(&intervention->mutex);
+    switch (intervention->mode) {
+    case INTERVENTION_FREEZE:
+        if (intervention->last) {
+            GstBuffer *frozen = gst_buffer_copy(intervention->last);
+            GST_BUFFER_PTS(frozen) = GST_BUFFER_PTS(buffer);
+            GST_BUFFER_DTS(frozen) = GST_BUFFER_DTS(buffer);
+            GST_BUFFER_DURATION(frozen) = GST_BUFFER_DURATION(buffer);
+            gst_buffer_unref(buffer);
+            GST_PAD_PROBE_INFO_DATA(info) = frozen;
+        }
+        break;
+    case INTERVENTION_BLACK:
+        buffer = gst_buffer_make_writable(buffer);
+        fill_black(pad, buffer);
+        GST_PAD_PROBE_INFO_DATA(info) = buffer;
+        break;
+    case INTERVENTION_FPS:
+        if (GST_BUFFER_PTS_IS_VALID(buffer)) {
+            GstClockTime pts = GST_BUFFER_PTS(buffer);
+            if (GST_CLOCK_TIME_IS_VALID(intervention->lastKeptPts) && pts < intervention->lastKeptPts + GST_SECOND / intervention->fps) {
+                ret = GST_PAD_PROBE_DROP;
+            } else {
+                intervention->lastKeptPts = pts;
+            }
+        }
+        // fall through to keep the last frame
+    default:
+        if (ret == GST_PAD_PROBE_OK) {
+            gst_buffer_replace(&intervention->last, buffer);
+        }
+    }
+    g_mutex_unlock(&intervention->mutex);
+
+    return ret;
+}
+
+void gstSetVideoIntervention(GstElement *pipeline, char *name, int mode, int fps)
+{
+    GstElement* el;
+    Intervention *intervention;
+
+    el = gst_bin_get_by_name(GST_BIN(pipeline), name);
+    if(!el) return;
+
+    intervention = g_object_get_data(G_OBJECT(el), "intervention");
+    if (!intervention) {
+        GstPad *pad = gst_element_get_static_pad(el, "src");
+        intervention = g_new0(Intervention, 1);
+        g_mutex_init(&intervention->mutex);
+        intervention->lastKeptPts = GST_CLOCK_TIME_NONE;
+        g_object_set_data_full(G_OBJECT(el), "intervention", intervention, intervention_free);
+        gst_pad_add_probe(pad, GST_PAD_PROBE_TYPE_BUFFER, intervention_probe, intervention, NULL);
+        gst_object_unref(pad);
+    }
+
+    g_mutex_lock(&intervention->mutex);
+    intervention->mode = mode;
+    intervention->fps = fps > 0 ? fps : 1;
+    intervention->lastKeptPts = GST_CLOCK_TIME_NONE;
+    g_mutex_unlock(&intervention->mutex);
+
+    gst_object_unref(el);
+}
+
+// minimum latency of the pipeline in ms, -1 if the query fails
+gint gstQueryLatency(GstElement *pipeline)
+{
+    GstQuery* query;
+    gboolean live;
+    GstClockTime min, max;
+    gint latency = -1;
+
+    query = gst_query_new_latency();
+    if(gst_element_query(pipeline, query)) {
+        gst_query_parse_latency(query, &live, &min, &max);
+        if(GST_CLOCK_TIME_IS_VALID(min)) {
+            latency = (gint) GST_TIME_AS_MSECONDS(min);
+        }
+    }
+    gst_query_unref(query);
+
+    return latency;
+}
+
 
 // float get/set
 
+gboolean gstHasPropFloat(GstElement *pipeline, char *name, char *prop) {
+    GstElement* el;
+    GParamSpec* spec = NULL;
+
+    el = gst_bin_get_by_name(GST_BIN(pipeline), name);
+
+    if(el) {
+        spec = g_object_class_find_property(G_OBJECT_GET_CLASS(el), prop);
+        gst_object_unref(el);
+    }
+
+    return spec != NULL && spec->value_type == G_TYPE_FLOAT;
+}
+
 float gstGetPropFloat(GstElement *pipeline, char *name, char *prop) {
     GstElement* el;
-    gfloat value;
+    gfloat value = 0;
  
     el = gst_bin_get_by_name(GST_BIN(pipeline), name);
     
@@ -275,7 +531,7 @@ void gstSetPropFloat(GstElement *pipeline, char *name, char *prop, float value)
 
 double gstGetPropDouble(GstElement *pipeline, char *name, char *prop) {
     GstElement* el;
-    gdouble value;
+    gdouble value = 0;
  
     el = gst_bin_get_by_name(GST_BIN(pipeline), name);
     
@@ -303,7 +559,7 @@ void gstSetPropDouble(GstElement *pipeline, char *name, char *prop, double value
 
 gint gstGetPropInt(GstElement *pipeline, char *name, char *prop) {
     GstElement* el;
-    gint value;
+    gint value = 0;
  
     el = gst_bin_get_by_name(GST_BIN(pipeline), name);
     
@@ -331,7 +587,7 @@ void gstSetPropInt(GstElement *pipeline, char *name, char *prop, gint value)
 
 guint64 gstGetPropUint64(GstElement *pipeline, char *name, char *prop) {
     GstElement* el;
-    guint64 value;
+    guint64 value = 0;
  
     el = gst_bin_get_by_name(GST_BIN(pipeline), name);
     
@@ -367,4 +623,21 @@ void gstSetPropString(GstElement *pipeline, char *name, char *prop, char *value)
         g_object_set(el, prop, value, NULL);
         gst_object_unref(el);
     }
-}
\ No newline at end of file
+}
+
+// caps set/get
+
+void gstSetCaps(GstElement *pipeline, char *name, char *value)
+{
+    GstElement* el;
+    GstCaps* caps;
+
+    el = gst_bin_get_by_name(GST_BIN(pipeline), name);
+
+    if(el) {
+        caps = gst_caps_from_string(value);
+        g_object_set(el, "caps", caps, NULL);
+        gst_caps_unref(caps);
+        gst_object_unref(el);
+    }
+}
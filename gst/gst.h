@@ -8,19 +8,25 @@
 
 extern void goWriteAudio(char *id, void *buffer, int bufferLen);
 extern void goWriteVideo(char *id, void *buffer, int bufferLen);
+extern void goWriteVideoTier(char *id, int tier, void *buffer, int bufferLen);
 extern void goDeletePipeline(char *id);
 extern void goRequestKeyFrame(char *id);
+extern void goAudioLevel(char *id, char *name, double rms, double peak, guint64 age);
 extern void goBusLog(char *id, char *msg, char *el);
 extern void goDebugLog(int level, char *file, char *function,int line, char *msg);
 
 void gstStartMainLoop(gboolean interceptLogs);
 GstElement *gstParsePipeline(char *pipelineStr, char *id);
-void gstStartPipeline(GstElement *pipeline, gboolean audioOnly);
+void gstStartPipeline(GstElement *pipeline, gboolean audioOnly, int tiers);
 void gstStopPipeline(GstElement *pipeline);
 void gstSrcPush(GstElement *pipeline, char *src, void *buffer, int len);
 void gstSendPLI(GstElement *pipeline);
+void gstForceKeyUnit(GstElement *pipeline, char *name);
+gint gstQueryLatency(GstElement *pipeline);
+void gstSetVideoIntervention(GstElement *pipeline, char *name, int mode, int fps);
 
 // get/set props
+gboolean gstHasPropFloat(GstElement *pipeline, char *elName, char *elProp);
 float gstGetPropFloat(GstElement *pipeline, char *elName, char *elProp);
 void gstSetPropFloat(GstElement *pipeline, char *elName, char *elProp, float elValue);
 double gstGetPropDouble(GstElement *pipeline, char *name, char *prop);
@@ -30,5 +36,6 @@ void gstSetPropInt(GstElement *pipeline, char *elName, char *elProp, gint elValu
 guint64 gstGetPropUint64(GstElement *pipeline, char *name, char *prop);
 void gstSetPropUint64(GstElement *pipeline, char *name, char *prop, guint64 value);
 void gstSetPropString(GstElement *pipeline, char *name, char *prop, char *value);
+void gstSetCaps(GstElement *pipeline, char *name, char *value);
 
 #endif
\ No newline at end of file
@@ -31,6 +31,8 @@ type gstEnhancedConfig struct {
 	}
 	Opus  mediaOptions
 	VP8   mediaOptions `yaml:"vp8"`
+	VP9   mediaOptions `yaml:"vp9"`
+	AV1   mediaOptions `yaml:"av1"`
 	X264  mediaOptions
 	NV264 mediaOptions `yaml:"nv264"`
 }
@@ -58,6 +60,8 @@ func init() {
 	// complete codec with shared properties
 	gstConfig.Opus.addSharedAudioProperties()
 	gstConfig.VP8.addSharedVideoProperties()
+	gstConfig.VP9.addSharedVideoProperties()
+	gstConfig.AV1.addSharedVideoProperties()
 	gstConfig.X264.addSharedVideoProperties()
 	gstConfig.NV264.addSharedVideoProperties()
 
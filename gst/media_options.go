@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/types"
 )
 
 // capitalized props are accessible to template
@@ -16,6 +17,9 @@ type mediaOptions struct {
 	nvCodec bool
 	nvCuda  bool
 	Overlay bool
+	// live properties depending on the interaction Opus settings
+	Channels int
+	opus     types.OpusConfig
 	// properties depending on yml definitions
 	DefaultBitrate  int
 	DefaultKBitrate int
@@ -39,6 +43,17 @@ func (mo *mediaOptions) addSharedAudioProperties() {
 	mo.DefaultKBitrate = config.SFU.Audio.DefaultBitrate / 1000
 }
 
+func (mo *mediaOptions) addOpusProperties(opus types.OpusConfig) {
+	mo.opus = opus
+	mo.Channels = opus.Channels()
+	mo.DefaultBitrate = opus.DefaultBitrate
+	mo.DefaultKBitrate = opus.DefaultBitrate / 1000
+	if opus.Stereo {
+		// read by rtpopusdepay to output 2 channels
+		mo.Rtp.Caps += ",sprop-stereo=(string)1"
+	}
+}
+
 func (mo *mediaOptions) addSharedVideoProperties() {
 	// used in template or by template helpers
 	mo.DefaultBitrate = config.SFU.Video.DefaultBitrate
@@ -52,6 +67,11 @@ func (mo mediaOptions) EncodeWith(name string) (output string) {
 	output = strings.Replace(mo.Encoder, "{{.Name}}", name, -1)
 	output = strings.Replace(output, "{{.DefaultBitrate}}", strconv.Itoa(mo.DefaultBitrate), -1)
 	output = strings.Replace(output, "{{.DefaultKBitrate}}", strconv.Itoa(mo.DefaultKBitrate), -1)
+	// Opus only
+	output = strings.Replace(output, "{{.FEC}}", strconv.FormatBool(mo.opus.FEC), -1)
+	output = strings.Replace(output, "{{.DTX}}", strconv.FormatBool(mo.opus.DTX), -1)
+	output = strings.Replace(output, "{{.FrameSize}}", strconv.Itoa(mo.opus.PTime), -1)
+	output = strings.Replace(output, "{{.Complexity}}", strconv.Itoa(mo.opus.Complexity), -1)
 	return
 }
 
@@ -96,4 +116,12 @@ func (mo mediaOptions) ConstraintFormatFramerateResolution(framerate, width, hei
 		output = strings.Replace(output, "{{.Convert}}", "videoconvert ! videoscale", -1)
 	}
 	return
-}
\ No newline at end of file
+}
+
+// named to be updated under congestion (see Pipeline.SetVideoConstraint), framerate is not constrained initially
+func (mo mediaOptions) ConstraintAdaptive(width, height int) (output string) {
+	caps := fmt.Sprintf("%v,width=%v,height=%v", gstConfig.Shared.Video.RawFormat, width, height)
+	output = strings.Replace(gstConfig.Shared.Video.Constraint.FormatFramerateResolution, "{{.VideoFormatFramerateResolution}}", caps, -1)
+	output = strings.Replace(output, "{{.Convert}}", "videoconvert ! videoscale", -1)
+	return output + " name=" + adaptiveCapsName
+}
@@ -8,21 +8,19 @@ package gst
 import "C"
 import (
 	"fmt"
-	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"unsafe"
 
+	"github.com/ducksouplab/ducksoup/config"
 	"github.com/ducksouplab/ducksoup/env"
 	"github.com/ducksouplab/ducksoup/types"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 )
 
-var muxedModes = []string{"forced", "free", "reenc"}
-
 // Pipeline is a wrapper for a GStreamer pipeline and output track
 type Pipeline struct {
 	mu          sync.Mutex
@@ -31,6 +29,7 @@ type Pipeline struct {
 	audioOutput types.TrackWriter
 	videoOutput types.TrackWriter
 	startedCh   chan struct{}
+	deletedCh   chan struct{}
 	// sfu info
 	jp              types.JoinPayload
 	plir            types.PLIRequester
@@ -39,26 +38,37 @@ type Pipeline struct {
 	// options
 	videoOptions mediaOptions
 	audioOptions mediaOptions
+	tierBitrates []int // nil if the template has no video tiers
+	adaptive     bool  // main video encoder input can be constrained
+	intervention bool  // raw video can be frozen, blacked out or reduced before encoders
 	// stoppedCount=2 if audio and video have been stopped
 	stoppedCount int
 	// data and log
 	dataFolder string
 	logger     zerolog.Logger
 	// API
-	RecordingFiles []string
+	RecordingFiles  []string
+	Template        string
+	DescriptionFile string
 }
 
 func fileName(namespace string, prefix string, suffix string) string {
 	return namespace + "/" + prefix + "-" + suffix + ".mkv"
 }
 
-func getOptions(jp types.JoinPayload, iRandomId string) (videoOptions, audioOptions mediaOptions) {
+// returns an error (and H264 options) if the video format is unknown
+func getOptions(jp types.JoinPayload, iRandomId string) (videoOptions, audioOptions mediaOptions, err error) {
 	audioOptions = gstConfig.Opus
 	// rely on the fact that assigning to a struct with only primitive values (string), is copying by value
 	// caution: don't extend codec type with non primitive values
 	if &audioOptions == &gstConfig.Opus {
 		panic("Unhandled audioCodec assign")
 	}
+	opus, _ := config.OpusTemplate("")
+	if jp.Opus != nil {
+		opus = *jp.Opus
+	}
+	audioOptions.addOpusProperties(opus)
 	// choose videoCodec
 	nvCodec := env.NVCodec && jp.GPU
 	nvCuda := env.NVCuda && jp.GPU
@@ -66,14 +76,22 @@ func getOptions(jp types.JoinPayload, iRandomId string) (videoOptions, audioOpti
 	case "VP8":
 		videoOptions = gstConfig.VP8
 		videoOptions.SkipFixedCaps = true
-	case "H264":
+	case "VP9":
+		videoOptions = gstConfig.VP9
+		videoOptions.SkipFixedCaps = true
+	case "AV1":
+		videoOptions = gstConfig.AV1
+		videoOptions.SkipFixedCaps = true
+	default:
+		if jp.VideoFormat != "H264" {
+			// should have been filtered when parsing the join payload
+			err = fmt.Errorf("unknown video format: %v", jp.VideoFormat)
+		}
 		if nvCodec {
 			videoOptions = gstConfig.NV264
 		} else {
 			videoOptions = gstConfig.X264
 		}
-	default:
-		panic("Unhandled format " + jp.VideoFormat)
 	}
 	// set env and jp dependent options
 	videoOptions.nvCodec = nvCodec
@@ -116,8 +134,9 @@ func StartMainLoop() {
 	C.gstStartMainLoop(C.int(envInterceptGSTLogs()))
 }
 
-// create a GStreamer pipeline
-func NewPipeline(jp types.JoinPayload, plir types.PLIRequester, dataFolder, iRandomId string, connectionCount int, logger zerolog.Logger) *Pipeline {
+// create a GStreamer pipeline, with video tiers if tierBitrates has several values and the template supports them,
+// and with a constrainable video encoder input if adaptive (see SetVideoConstraint)
+func NewPipeline(jp types.JoinPayload, plir types.PLIRequester, dataFolder, iRandomId string, connectionCount int, tierBitrates []int, adaptive bool, logger zerolog.Logger) *Pipeline {
 	id := uuid.New().String()
 	logger = logger.With().
 		Str("context", "pipeline").
@@ -125,7 +144,10 @@ func NewPipeline(jp types.JoinPayload, plir types.PLIRequester, dataFolder, iRan
 		Str("pipeline", id).
 		Logger()
 
-	videoOptions, audioOptions := getOptions(jp, iRandomId)
+	videoOptions, audioOptions, err := getOptions(jp, iRandomId)
+	if err != nil {
+		logger.Error().Err(err).Msg("video_format_unknown")
+	}
 	logger.Info().Str("audioOptions", fmt.Sprintf("%+v", audioOptions)).Msg("template_data")
 	logger.Info().Str("videoOptions", fmt.Sprintf("%+v", videoOptions)).Msg("template_data")
 
@@ -140,18 +162,26 @@ func NewPipeline(jp types.JoinPayload, plir types.PLIRequester, dataFolder, iRan
 		audioOptions:    audioOptions,
 		stoppedCount:    0,
 		startedCh:       make(chan struct{}),
+		deletedCh:       make(chan struct{}),
 		dataFolder:      dataFolder,
 		logger:          logger,
 	}
 
 	// C pipeline
-	pipelineStr := newPipelineDef(jp, p.dataFolder, p.filePrefix(), videoOptions, audioOptions)
+	pipelineStr, template, descriptionFile, tiers, adaptive := newPipelineDef(jp, p.dataFolder, p.filePrefix(), videoOptions, audioOptions, tierBitrates, adaptive)
+	if len(tiers) > 0 {
+		p.tierBitrates = tierBitrates
+	}
+	p.adaptive = adaptive
+	p.intervention = hasVideoEncoder(template, videoOptions)
+	p.Template = template
+	p.DescriptionFile = descriptionFile
 	cPipelineStr := C.CString(pipelineStr)
 	cId := C.CString(id)
 	defer C.free(unsafe.Pointer(cPipelineStr))
 	defer C.free(unsafe.Pointer(cId))
 	p.cPipeline = C.gstParsePipeline(cPipelineStr, cId)
-	p.logger.Info().Str("pipeline", pipelineStr).Msg("pipeline_initialized")
+	p.logger.Info().Str("template", template).Str("pipeline", pipelineStr).Msg("pipeline_initialized")
 
 	pipelineStoreSingleton.add(p)
 	return p
@@ -173,6 +203,23 @@ func (p *Pipeline) Started() chan struct{} {
 	return p.startedCh
 }
 
+func (p *Pipeline) ID() string {
+	return p.id
+}
+
+func (p *Pipeline) UserId() string {
+	return p.jp.UserId
+}
+
+func (p *Pipeline) ConnectionCount() int {
+	return p.connectionCount
+}
+
+// closed when GStreamer has released the pipeline (and its recordings are complete)
+func (p *Pipeline) Deleted() chan struct{} {
+	return p.deletedCh
+}
+
 func (p *Pipeline) PushRTP(kind string, buffer []byte) {
 	p.srcPush(kind+"_rtp_src", buffer)
 }
@@ -208,7 +255,8 @@ func (p *Pipeline) start() {
 	if p.jp.AudioOnly {
 		audioOnly = 1
 	}
-	C.gstStartPipeline(p.cPipeline, C.int(audioOnly))
+	tiers := max(len(p.tierBitrates)-1, 0) // main tier excluded
+	C.gstStartPipeline(p.cPipeline, C.int(audioOnly), C.int(tiers))
 	recordingPrefix := fmt.Sprintf("%s/%s/recordings/", p.jp.Namespace, p.jp.InteractionName)
 	p.logger.Info().Str("recording_prefix", recordingPrefix).Msg("pipeline_started")
 
@@ -237,45 +285,48 @@ func (p *Pipeline) Stop() {
 	}
 }
 
+// only templates with filesinks record (see config/pipelines)
 func (p *Pipeline) updateRecordingFiles() {
 	hasWetFiles := len(p.jp.AudioFx) > 0 || len(p.jp.VideoFx) > 0
 	recordingPrefix := p.dataFolder + "/recordings/" + p.filePrefix() + "-"
 
-	if p.jp.AudioOnly {
+	switch p.Template {
+	case "audio_only":
 		dryAudioFile := recordingPrefix + "audio-dry." + p.audioOptions.Extension
 		p.setPropString("dry_audio_filesink", "location", dryAudioFile)
 		p.RecordingFiles = append(p.RecordingFiles, dryAudioFile)
+		if len(p.jp.AudioFx) > 0 {
+			wetAudioFile := recordingPrefix + "audio-wet." + p.audioOptions.Extension
+			p.setPropString("wet_audio_filesink", "location", wetAudioFile)
+			p.RecordingFiles = append(p.RecordingFiles, wetAudioFile)
+		}
+	case "muxed_forced_framerate", "muxed_free_framerate", "muxed_reenc_dry":
+		dryFile := recordingPrefix + "dry." + p.videoOptions.Extension
+		p.setPropString("dry_filesink", "location", dryFile)
+		p.RecordingFiles = append(p.RecordingFiles, dryFile)
 		if hasWetFiles {
+			wetFile := recordingPrefix + "wet." + p.videoOptions.Extension
+			p.setPropString("wet_filesink", "location", wetFile)
+			p.RecordingFiles = append(p.RecordingFiles, wetFile)
+		}
+	case "split":
+		dryAudioFile := recordingPrefix + "audio-dry." + p.audioOptions.Extension
+		dryVideoFile := recordingPrefix + "video-dry." + p.videoOptions.Extension
+		p.setPropString("dry_audio_filesink", "location", dryAudioFile)
+		p.setPropString("dry_video_filesink", "location", dryVideoFile)
+		p.RecordingFiles = append(p.RecordingFiles, dryAudioFile, dryVideoFile)
+		if len(p.jp.AudioFx) > 0 {
 			wetAudioFile := recordingPrefix + "audio-wet." + p.audioOptions.Extension
 			p.setPropString("wet_audio_filesink", "location", wetAudioFile)
 			p.RecordingFiles = append(p.RecordingFiles, wetAudioFile)
 		}
-	} else {
-		if slices.Contains(muxedModes, p.jp.RecordingMode) {
-			dryFile := recordingPrefix + "dry." + p.videoOptions.Extension
-			p.setPropString("dry_filesink", "location", dryFile)
-			p.RecordingFiles = append(p.RecordingFiles, dryFile)
-			if hasWetFiles {
-				wetFile := recordingPrefix + "wet." + p.videoOptions.Extension
-				p.setPropString("wet_filesink", "location", wetFile)
-				p.RecordingFiles = append(p.RecordingFiles, wetFile)
-			}
-		} else if p.jp.RecordingMode == "split" {
-			dryAudioFile := recordingPrefix + "audio-dry." + p.audioOptions.Extension
-			dryVideoFile := recordingPrefix + "video-dry." + p.videoOptions.Extension
-			p.setPropString("dry_audio_filesink", "location", dryAudioFile)
-			p.setPropString("dry_video_filesink", "location", dryVideoFile)
-			p.RecordingFiles = append(p.RecordingFiles, dryAudioFile, dryVideoFile)
-			if hasWetFiles {
-				wetAudioFile := recordingPrefix + "audio-wet." + p.audioOptions.Extension
-				wetVideoFile := recordingPrefix + "video-wet." + p.videoOptions.Extension
-				p.setPropString("wet_audio_filesink", "location", wetAudioFile)
-				p.setPropString("wet_video_filesink", "location", wetVideoFile)
-				p.RecordingFiles = append(p.RecordingFiles, wetAudioFile, wetVideoFile)
-			}
+		if len(p.jp.VideoFx) > 0 {
+			wetVideoFile := recordingPrefix + "video-wet." + p.videoOptions.Extension
+			p.setPropString("wet_video_filesink", "location", wetVideoFile)
+			p.RecordingFiles = append(p.RecordingFiles, wetVideoFile)
 		}
-		// else there is no record
 	}
+	// else there is no record
 }
 
 func (p *Pipeline) getPropInt(name string, prop string) int {
@@ -338,35 +389,123 @@ func (p *Pipeline) GetCurrentLevelTime(name string) uint64 {
 }
 
 func (p *Pipeline) SetEncodingBitrate(kind string, value int) {
-	// see https://gstreamer.freedesktop.org/documentation/x264/index.html?gi-language=c#x264enc:bitrate
-	// see https://gstreamer.freedesktop.org/documentation/nvcodec/GstNvBaseEnc.html?gi-language=c#GstNvBaseEnc:bitrate
 	// see https://gstreamer.freedesktop.org/documentation/opus/opusenc.html?gi-language=c#opusenc:bitrate
 	if kind == "audio" {
 		p.setPropInt("audio_encoder_wet", "bitrate", value)
 	} else {
-		if p.jp.VideoFormat == "VP8" {
+		p.setVideoEncodersBitrate(value, "video_encoder_dry", "video_encoder_wet", recordingEncoderName)
+	}
+}
+
+func (p *Pipeline) setVideoEncodersBitrate(value int, names ...string) {
+	// see https://gstreamer.freedesktop.org/documentation/x264/index.html?gi-language=c#x264enc:bitrate
+	// see https://gstreamer.freedesktop.org/documentation/nvcodec/GstNvBaseEnc.html?gi-language=c#GstNvBaseEnc:bitrate
+	for _, name := range names {
+		if p.jp.VideoFormat == "VP8" || p.jp.VideoFormat == "VP9" {
 			// see https://gstreamer.freedesktop.org/documentation/vpx/GstVPXEnc.html?gi-language=c#GstVPXEnc:target-bitrate
-			p.setPropInt("video_encoder_dry", "target-bitrate", value)
-			p.setPropInt("video_encoder_wet", "target-bitrate", value)
+			p.setPropInt(name, "target-bitrate", value)
 		} else if p.jp.VideoFormat == "H264" {
 			// in kbit/s for x264enc and nvh264enc
-			value = value / 1000
-			p.setPropInt("video_encoder_dry", "bitrate", value)
-			p.setPropInt("video_encoder_wet", "bitrate", value)
+			p.setPropInt(name, "bitrate", value/1000)
 			if p.videoOptions.nvCodec {
 				// https://gstreamer.freedesktop.org/documentation/nvcodec/GstNvBaseEnc.html?gi-language=c#GstNvBaseEnc:max-bitrate
-				p.setPropInt("video_encoder_dry", "max-bitrate", value*280/256)
-				p.setPropInt("video_encoder_wet", "max-bitrate", value*280/256)
+				p.setPropInt(name, "max-bitrate", value/1000*280/256)
 			}
+		} else if p.jp.VideoFormat == "AV1" {
+			// see https://gstreamer.freedesktop.org/documentation/svtav1/index.html?gi-language=c#svtav1enc:target-bitrate
+			// in kbit/s
+			p.setPropInt(name, "target-bitrate", value/1000)
 		}
 	}
 }
 
+// AdaptiveVideo tells if SetVideoConstraint applies
+func (p *Pipeline) AdaptiveVideo() bool {
+	return p.adaptive
+}
+
+// VideoInterventions tells if SetVideoIntervention applies
+func (p *Pipeline) VideoInterventions() bool {
+	return p.intervention
+}
+
+// SetVideoIntervention freezes (repeats the last frame), blacks out or reduces to fps (for "fps")
+// the video before encoders, "none" restoring it
+func (p *Pipeline) SetVideoIntervention(kind string, fps int) {
+	modes := map[string]int{"none": 0, "freeze": 1, "black": 2, "fps": 3}
+	mode, ok := modes[kind]
+	if !ok || !p.intervention {
+		return
+	}
+	cName := C.CString(interventionName)
+	defer C.free(unsafe.Pointer(cName))
+
+	C.gstSetVideoIntervention(p.cPipeline, cName, C.int(mode), C.int(fps))
+}
+
+// SetVideoConstraint updates the resolution and framerate (not constrained if 0) of the main video encoder input
+func (p *Pipeline) SetVideoConstraint(width, height, framerate int) {
+	caps := fmt.Sprintf("%v,width=%v,height=%v", gstConfig.Shared.Video.RawFormat, width, height)
+	if framerate > 0 {
+		caps += fmt.Sprintf(",framerate=%v/1", framerate)
+	}
+	cName := C.CString(adaptiveCapsName)
+	cCaps := C.CString(caps)
+	defer C.free(unsafe.Pointer(cName))
+	defer C.free(unsafe.Pointer(cCaps))
+
+	C.gstSetCaps(p.cPipeline, cName, cCaps)
+}
+
+// Latency returns the minimum latency (in ms) reported by the pipeline elements, -1 if unknown
+func (p *Pipeline) Latency() int {
+	select {
+	case <-p.startedCh:
+	default:
+		return -1
+	}
+	return int(C.gstQueryLatency(p.cPipeline))
+}
+
+// VideoTiers returns the bitrates from which each tier is selected (the last one being the
+// main encoder), nil if the pipeline has no video tiers
+func (p *Pipeline) VideoTiers() []int {
+	return p.tierBitrates
+}
+
+// SetTierEncodingBitrate updates a lower video tier, the main one being updated with SetEncodingBitrate
+func (p *Pipeline) SetTierEncodingBitrate(tier int, value int) {
+	p.setVideoEncodersBitrate(value, tierEncoderName(tier))
+}
+
+// RequestTierKeyFrame asks the encoder of the given tier (possibly the main one) for a keyframe
+func (p *Pipeline) RequestTierKeyFrame(tier int) {
+	name := "video_encoder_wet"
+	if tier < len(p.tierBitrates)-1 {
+		name = tierEncoderName(tier)
+	}
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	C.gstForceKeyUnit(p.cPipeline, cName)
+}
+
 func (p *Pipeline) SetFxPropFloat(name string, prop string, value float32) {
 	// fx prefix needed (added during pipeline initialization)
 	p.setPropFloat("client_"+name, prop, value)
 }
 
+// HasFxPropFloat is true if the fx exists in the pipeline and has the given float property
+func (p *Pipeline) HasFxPropFloat(name string, prop string) bool {
+	cName := C.CString("client_" + name)
+	cProp := C.CString(prop)
+
+	defer C.free(unsafe.Pointer(cName))
+	defer C.free(unsafe.Pointer(cProp))
+
+	return C.gstHasPropFloat(p.cPipeline, cName, cProp) != 0
+}
+
 func (p *Pipeline) GetFxPropFloat(name string, prop string) float32 {
 	// fx prefix needed (added during pipeline initialization)
 	cName := C.CString("client_" + name)
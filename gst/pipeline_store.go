@@ -44,6 +44,7 @@ func (ps *pipelineStore) delete(id string) {
 	p, ok := ps.index[id]
 	if ok {
 		p.logger.Info().Msg("pipeline_deleted")
+		close(p.deletedCh)
 	}
 
 	delete(ps.index, id)
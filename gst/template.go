@@ -9,7 +9,9 @@ import "C"
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -19,7 +21,83 @@ import (
 	"github.com/ducksouplab/ducksoup/types"
 )
 
-func newPipelineDef(jp types.JoinPayload, dataFolder, filePrefix string, videoOptions, audioOptions mediaOptions) string {
+// templates with a video encoder branch (when there is a video fx)
+var videoEncoderTemplateNames = []string{"muxed_forced_framerate", "muxed_free_framerate", "muxed_reenc_dry", "no_recording", "split"}
+
+// templates where audio is decoded and its level measured
+var audioLevelTemplateNames = []string{"audio_only", "audio_only_no_recording", "muxed_forced_framerate", "muxed_free_framerate", "muxed_reenc_dry", "no_recording", "split"}
+
+// templates muxing wet audio and video in the same file, where the A/V offset may be recorded
+var avOffsetTemplateNames = []string{"muxed_forced_framerate", "muxed_free_framerate", "muxed_reenc_dry"}
+
+// capsfilter before the main video encoder, when video is adaptive
+const adaptiveCapsName = "video_adaptive_caps"
+
+// encoder of the wet recording when video is adaptive, the main video encoder being only forwarded
+const recordingEncoderName = "video_encoder_rec"
+
+// level elements are named with this prefix followed by their source (dry or wet)
+const (
+	audioLevelPrefix = "audio_level_"
+	// dBFS, lower levels are reported at this value
+	minAudioLevel = -100
+)
+
+// element before video encoders where interventions are applied (see Pipeline.SetVideoIntervention)
+const interventionName = "video_intervention"
+
+// raw video is available (and then encoded) only with a video fx
+func hasVideoEncoder(templateName string, videoOptions mediaOptions) bool {
+	return len(videoOptions.Fx) > 0 && slices.Contains(videoEncoderTemplateNames, templateName)
+}
+
+// VideoInterventionsAvailable tells if the pipeline of a participant joining with jp will apply
+// video interventions to forwarded video (see Pipeline.SetVideoIntervention)
+func VideoInterventionsAvailable(jp types.JoinPayload) bool {
+	if jp.AudioOnly || jp.RecordingMode == "bypass" || jp.RecordingMode == "direct" {
+		// forwarded video is not encoded by the pipeline
+		return false
+	}
+	return len(jp.VideoFx) > 0 && slices.Contains(videoEncoderTemplateNames, selectTemplateName(jp))
+}
+
+func selectTemplateName(jp types.JoinPayload) (templateName string) {
+	// global switch or per participant consent
+	noRecording := env.NoRecording || jp.NoRecording
+	if jp.AudioOnly {
+		if noRecording {
+			templateName = "audio_only_no_recording"
+		} else {
+			// audio only default
+			templateName = "audio_only"
+		}
+	} else {
+		if noRecording {
+			templateName = "no_recording"
+		} else if jp.RecordingMode == "split" {
+			templateName = "split"
+		} else if jp.RecordingMode == "rtpbin_only" {
+			templateName = "rtpbin_only"
+		} else if jp.RecordingMode == "none" {
+			templateName = "no_recording"
+		} else if jp.RecordingMode == "reenc" {
+			templateName = "muxed_reenc_dry"
+		} else if jp.RecordingMode == "free" {
+			templateName = "muxed_free_framerate"
+		} else { // default
+			// audio+video default, ideally would be muxedTemplater
+			templateName = "muxed_forced_framerate"
+			if jp.VideoFormat != "H264" { // if we switch default to muxedTemplater, keep reenc for VPx and AV1
+				templateName = "muxed_reenc_dry"
+			}
+		}
+	}
+	return
+}
+
+// returns the pipeline definition, the name of the template used, the path of the definition dump (if any),
+// the video tiers added to the template (if any) and if video is adaptive (if requested and video is encoded)
+func newPipelineDef(jp types.JoinPayload, dataFolder, filePrefix string, videoOptions, audioOptions mediaOptions, tierBitrates []int, adaptive bool) (string, string, string, []videoTier, bool) {
 
 	// shape template data
 	data := struct {
@@ -34,6 +112,17 @@ func newPipelineDef(jp types.JoinPayload, dataFolder, filePrefix string, videoOp
 		Framerate  int
 		RTPBin     string
 		FinalQueue string
+		Tiers      []videoTier
+		Adaptive   bool
+		// element name, empty if video has no encoder branch
+		Intervention string
+		// level element (without name), empty if audio levels are not measured
+		AudioLevel string
+		// ns, shifts applied before the wet muxer
+		WetAudioOffset int64
+		WetVideoOffset int64
+		// if shifted, the wet muxer waits for the late branch, the other one being queued longer
+		WetOffset bool
 	}{
 		gstConfig.Shared.Queue,
 		videoOptions,
@@ -46,37 +135,34 @@ func newPipelineDef(jp types.JoinPayload, dataFolder, filePrefix string, videoOp
 		"rtpbin name=rtpbin latency=" + strconv.Itoa(env.JitterBuffer),
 		// important: max-size-time greater than the jitter buffer latency to prevent audio glitches
 		"queue max-size-buffers=0 max-size-bytes=0 max-size-time=" + strconv.Itoa(env.JitterBuffer+100) + "000000",
+		nil,
+		false,
+		"",
+		"",
+		0,
+		0,
+		false,
 	}
 
 	// render pipeline from template
 	var buf bytes.Buffer
-	var templateName string
-	if jp.AudioOnly {
-		if env.NoRecording {
-			templateName = "audio_only_no_recording"
+	templateName := selectTemplateName(jp)
+	// tiers, adaptation and interventions are only relevant if video is encoded
+	if hasVideoEncoder(templateName, videoOptions) {
+		data.Tiers = newVideoTiers(tierBitrates)
+		data.Adaptive = adaptive
+		data.Intervention = interventionName
+	}
+	if interval := config.SFU.Audio.Level.Interval; interval > 0 && slices.Contains(audioLevelTemplateNames, templateName) {
+		data.AudioLevel = fmt.Sprintf("level interval=%v post-messages=true", interval*int(time.Millisecond))
+	}
+	// the dry recording is never shifted
+	if jp.RecordAVOffset && jp.AVOffset != 0 && slices.Contains(avOffsetTemplateNames, templateName) {
+		data.WetOffset = true
+		if jp.AVOffset > 0 {
+			data.WetAudioOffset = int64(jp.AVOffset) * int64(time.Millisecond)
 		} else {
-			// audio only default
-			templateName = "audio_only"
-		}
-	} else {
-		if env.NoRecording {
-			templateName = "no_recording"
-		} else if jp.RecordingMode == "split" {
-			templateName = "split"
-		} else if jp.RecordingMode == "rtpbin_only" {
-			templateName = "rtpbin_only"
-		} else if jp.RecordingMode == "none" {
-			templateName = "no_recording"
-		} else if jp.RecordingMode == "reenc" {
-			templateName = "muxed_reenc_dry"
-		} else if jp.RecordingMode == "free" {
-			templateName = "muxed_free_framerate"
-		} else { // default
-			// audio+video default, ideally would be muxedTemplater
-			templateName = "muxed_forced_framerate"
-			if jp.VideoFormat == "VP8" { // if we switch default to muxedTemplater, keep reenc for VP8
-				templateName = "muxed_reenc_dry"
-			}
+			data.WetVideoOffset = -int64(jp.AVOffset) * int64(time.Millisecond)
 		}
 	}
 	template := templateIndex[templateName]
@@ -85,10 +171,12 @@ func newPipelineDef(jp types.JoinPayload, dataFolder, filePrefix string, videoOp
 	}
 
 	// log pipeline
+	var descriptionFile string
 	if jp.RecordingMode != "bypass" {
 		contents := []byte("// DuckSoup#" + config.BackendVersion + " Pipeline#" + templateName + "\n\n")
 		contents = append(contents, buf.Bytes()...)
-		os.WriteFile(dataFolder+"/pipeline-u-"+jp.UserId+"-"+time.Now().Format("20060102-150405.000")+".txt", contents, 0666)
+		descriptionFile = dataFolder + "/pipeline-u-" + jp.UserId + "-" + time.Now().Format("20060102-150405.000") + ".txt"
+		os.WriteFile(descriptionFile, contents, 0666)
 	}
 
 	// process lines (trim and remove blank lines)
@@ -101,5 +189,5 @@ func newPipelineDef(jp types.JoinPayload, dataFolder, filePrefix string, videoOp
 		}
 	}
 
-	return formattedBuf.String()
+	return formattedBuf.String(), templateName, descriptionFile, data.Tiers, data.Adaptive
 }
@@ -0,0 +1,189 @@
+package gst
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+// pipeline description parsed as a graph of elements (name references being resolved)
+type pipelineGraph struct {
+	props map[int]map[string]string // per element
+	kinds map[int]string            // element factory (or caps)
+	names map[string]int
+	refs  map[int]string // elements referenced by name (tee_x. or muxer.sink_0)
+	next  map[int][]int
+}
+
+var tokenRegex = regexp.MustCompile(`(?:[^\s"]+|"[^"]*")+`)
+var propRegex = regexp.MustCompile(`^([a-z][a-z0-9_-]*)=(.*)$`)
+
+func parsePipelineGraph(def string) *pipelineGraph {
+	g := &pipelineGraph{
+		props: map[int]map[string]string{},
+		kinds: map[int]string{},
+		names: map[string]int{},
+		refs:  map[int]string{},
+		next:  map[int][]int{},
+	}
+	current, count, linking := -1, 0, false
+	for _, token := range tokenRegex.FindAllString(def, -1) {
+		if token == "!" {
+			linking = true
+			continue
+		}
+		if m := propRegex.FindStringSubmatch(token); m != nil && current >= 0 && !linking {
+			g.props[current][m[1]] = m[2]
+			if m[1] == "name" {
+				g.names[m[2]] = current
+			}
+			continue
+		}
+		id := count
+		count++
+		g.props[id] = map[string]string{}
+		g.kinds[id] = token
+		if !strings.Contains(token, "/") && strings.Contains(token, ".") {
+			g.refs[id] = strings.SplitN(token, ".", 2)[0]
+		}
+		if linking {
+			g.next[current] = append(g.next[current], id)
+		}
+		current, linking = id, false
+	}
+	// references are merged with the element they name
+	resolve := func(id int) int {
+		if name, ok := g.refs[id]; ok {
+			if target, ok := g.names[name]; ok {
+				return target
+			}
+		}
+		return id
+	}
+	next := map[int][]int{}
+	for from, tos := range g.next {
+		for _, to := range tos {
+			next[resolve(from)] = append(next[resolve(from)], resolve(to))
+		}
+	}
+	g.next = next
+	return g
+}
+
+func (g *pipelineGraph) reachable(from int) map[int]bool {
+	seen := map[int]bool{from: true}
+	stack := []int{from}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, to := range g.next[id] {
+			if !seen[to] {
+				seen[to] = true
+				stack = append(stack, to)
+			}
+		}
+	}
+	return seen
+}
+
+// elements directly linked to id
+func (g *pipelineGraph) previous(id int) (previous []int) {
+	for from, tos := range g.next {
+		for _, to := range tos {
+			if to == id {
+				previous = append(previous, from)
+			}
+		}
+	}
+	return
+}
+
+// renders templates with video adaptation requested
+func renderTemplate(t *testing.T, jp types.JoinPayload) (string, string) {
+	videoOptions, audioOptions, err := getOptions(jp, "random")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, templateName, _, _, _ := newPipelineDef(jp, t.TempDir(), "prefix", videoOptions, audioOptions, nil, true)
+	return def, templateName
+}
+
+var wetMuxerNames = []string{"wet_muxer", "wet_video_muxer"}
+
+func TestAdaptiveCapsNotRecorded(t *testing.T) {
+	for _, mode := range []string{"forced", "free", "reenc", "split"} {
+		t.Run(mode, func(t *testing.T) {
+			jp := types.JoinPayload{UserId: "u1", VideoFormat: "VP8", RecordingMode: mode, VideoFx: "identity", Framerate: 30, Width: 800, Height: 600}
+			def, templateName := renderTemplate(t, jp)
+			g := parsePipelineGraph(def)
+			caps, ok := g.names[adaptiveCapsName]
+			if !ok {
+				t.Fatalf("no adaptive caps in %v", templateName)
+			}
+			// resolution steps renegotiate caps downstream of the adaptive capsfilter
+			downstream := g.reachable(caps)
+			for _, name := range wetMuxerNames {
+				if id, ok := g.names[name]; ok && downstream[id] {
+					t.Errorf("%v reaches %v in %v", adaptiveCapsName, name, templateName)
+				}
+			}
+			if sink, ok := g.names["video_rtp_sink"]; !ok || !downstream[sink] {
+				t.Errorf("%v does not reach video_rtp_sink in %v", adaptiveCapsName, templateName)
+			}
+			if _, ok := g.names[recordingEncoderName]; !ok {
+				t.Errorf("no %v in %v", recordingEncoderName, templateName)
+			}
+		})
+	}
+}
+
+func maxSizeTime(g *pipelineGraph, id int) int64 {
+	value, _ := strconv.ParseInt(g.props[id]["max-size-time"], 10, 64)
+	return value
+}
+
+func TestRecordedAVOffsetQueues(t *testing.T) {
+	// offsets are bounded to 2000 ms (see sfu/av_offset.go)
+	const maxOffset = 2000 * int64(time.Millisecond)
+	for _, mode := range []string{"forced", "free", "reenc"} {
+		for _, offset := range []int{-2000, 2000} {
+			for _, fx := range []string{"", "identity"} {
+				jp := types.JoinPayload{UserId: "u1", VideoFormat: "VP8", RecordingMode: mode, AudioFx: "identity", VideoFx: fx, Framerate: 30, Width: 800, Height: 600, AVOffset: offset, RecordAVOffset: true}
+				def, templateName := renderTemplate(t, jp)
+				g := parsePipelineGraph(def)
+				muxer, ok := g.names["wet_muxer"]
+				if !ok {
+					t.Fatalf("no wet_muxer in %v", templateName)
+				}
+				// each branch waits for the other one before being muxed
+				queues := 0
+				for from, tos := range g.next {
+					for _, to := range tos {
+						if to != muxer {
+							continue
+						}
+						id := from
+						for g.kinds[id] != "queue" {
+							previous := g.previous(id)
+							if len(previous) != 1 {
+								t.Fatalf("no queue before wet_muxer in %v (offset %v)", templateName, offset)
+							}
+							id = previous[0]
+						}
+						queues++
+						if maxSizeTime(g, id) <= maxOffset || len(g.props[id]["leaky"]) > 0 {
+							t.Errorf("queue before wet_muxer too short or leaky in %v (offset %v, video fx %q): %v", templateName, offset, fx, g.props[id])
+						}
+					}
+				}
+				if queues != 2 {
+					t.Errorf("unexpected branch count to wet_muxer in %v: %v", templateName, queues)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package gst
+
+import "strconv"
+
+// Video tiers are additional encodings of the processed video at lower bitrates, each one
+// having its own appsink. The main encoder (video_encoder_wet) is the highest tier
+
+type videoTier struct {
+	Encoder string
+	Sink    string
+	Bitrate int
+}
+
+func tierEncoderName(tier int) string {
+	return "video_encoder_tier_" + strconv.Itoa(tier)
+}
+
+// all tiers but the main (last) one, nil if there is only one tier
+func newVideoTiers(bitrates []int) (tiers []videoTier) {
+	for i := 0; i < len(bitrates)-1; i++ {
+		tiers = append(tiers, videoTier{
+			Encoder: tierEncoderName(i),
+			Sink:    "video_rtp_sink_tier_" + strconv.Itoa(i),
+			Bitrate: bitrates[i],
+		})
+	}
+	return
+}
+
+// template helper
+func (mo mediaOptions) EncodeTier(tier videoTier, folder, filePrefix string) string {
+	mo.DefaultBitrate = tier.Bitrate
+	mo.DefaultKBitrate = tier.Bitrate / 1000
+	return mo.EncodeWithCache(tier.Encoder, folder, filePrefix)
+}
@@ -0,0 +1,22 @@
+package helpers
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// DirSize returns the cumulated size in bytes of the files under path
+func DirSize(path string) (size int64, err error) {
+	err = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package helpers
+
+import "errors"
+
+func FreeDiskSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
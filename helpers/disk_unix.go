@@ -0,0 +1,14 @@
+//go:build unix
+
+package helpers
+
+import "syscall"
+
+// FreeDiskSpace returns the space in bytes available to unprivileged users on the volume holding path
+func FreeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
@@ -2,13 +2,16 @@ package main
 
 import (
 	"fmt"
+	"os"
 
+	"github.com/ducksouplab/ducksoup/datastore"
 	"github.com/ducksouplab/ducksoup/env"
 	"github.com/ducksouplab/ducksoup/frontbuild"
 	"github.com/ducksouplab/ducksoup/gst"
 	"github.com/ducksouplab/ducksoup/helpers"
 	"github.com/ducksouplab/ducksoup/iceservers"
 	"github.com/ducksouplab/ducksoup/server"
+	"github.com/ducksouplab/ducksoup/sfu"
 	"github.com/rs/zerolog/log"
 )
 
@@ -45,9 +48,20 @@ func logState() {
 	log.Info().Str("context", "init").Bool("value", env.ForceOverlay).Msg("DUCKSOUP_FORCE_OVERLAY")
 	log.Info().Str("context", "init").Bool("value", env.NoRecording).Msg("DUCKSOUP_NO_RECORDING")
 	log.Info().Str("context", "init").Str("value", fmt.Sprintf("%v", env.STUNServerURLS)).Msg("DUCKSOUP_STUN_SERVER_URLS")
+	log.Info().Str("context", "init").Bool("value", len(env.PseudonymSecret) > 0).Msg("DUCKSOUP_PSEUDONYM_SECRET")
+	log.Info().Str("context", "init").Str("value", env.PseudonymDir).Msg("DUCKSOUP_PSEUDONYM_DIR")
+	log.Info().Str("context", "init").Str("value", env.Storage).Msg("DUCKSOUP_STORAGE")
+	log.Info().Str("context", "init").Str("value", env.S3Endpoint).Msg("DUCKSOUP_S3_ENDPOINT")
+	log.Info().Str("context", "init").Str("value", env.S3Bucket).Msg("DUCKSOUP_S3_BUCKET")
+	log.Info().Str("context", "init").Str("value", env.S3Prefix).Msg("DUCKSOUP_S3_PREFIX")
+	log.Info().Str("context", "init").Bool("value", env.StorageDeleteLocal).Msg("DUCKSOUP_STORAGE_DELETE_LOCAL")
 }
 
 func main() {
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
 	// always build front (in watch mode or not, depending on env.Mode value, see front/build.go)
 	frontbuild.Build()
 
@@ -66,6 +80,9 @@ func main() {
 		// launch http (with websockets) server
 		go server.Start()
 
+		// delete expired data, if retention policies are configured
+		datastore.StartRetentionJob(sfu.IsRunning)
+
 		// launch TURN server
 		go iceservers.StartTURN()
 		defer iceservers.StopTURN()
@@ -37,10 +37,18 @@ type SlicePlot struct {
 	// server-side
 	outputLine plotter.XYs
 	targetLine plotter.XYs
+	// constraint on the encoder input (adaptation)
+	outputWidthLine       plotter.XYs
+	outputFramerateLine   plotter.XYs
+	outputFramerateLabels []string
 	// rtpDiffIn              plotter.XYs
 	senderCCOptimalLines   map[string]plotter.XYs
 	senderLossOptimalLines map[string]plotter.XYs
+	senderREMBOptimalLines map[string]plotter.XYs
 	currentLevelTimeLines  map[string]plotter.XYs
+	// video tiers
+	tierTargetLines   map[int]plotter.XYs
+	receiverTierLines map[string]plotter.XYs
 	// state
 	started   bool
 	startedAt time.Time
@@ -89,6 +97,31 @@ func (s *SlicePlot) save() {
 			smallGlyph,
 		)
 		s.createFramerateLabels()
+		if s.outputWidthLine.Len() > 0 {
+			createLinePoints(s.bitratePlot,
+				"output width (pixels)",
+				s.outputWidthLine,
+				1,
+				3,
+				color.RGBA{R: 120, G: 60, B: 166, A: 255},
+				draw.CircleGlyph{},
+				smallGlyph,
+			)
+			createLinePoints(s.bitratePlot,
+				"output framerate",
+				s.outputFramerateLine,
+				0,
+				0,
+				color.RGBA{R: 120, G: 60, B: 166, A: 255},
+				draw.CircleGlyph{},
+				smallGlyph,
+			)
+			labels, _ := plotter.NewLabels(plotter.XYLabels{
+				s.outputFramerateLine,
+				s.outputFramerateLabels,
+			})
+			s.bitratePlot.Add(labels)
+		}
 	}
 	createLinePoints(s.bitratePlot,
 		"input",
@@ -121,6 +154,39 @@ func (s *SlicePlot) save() {
 			smallGlyph,
 		)
 	}
+	for toUserId, line := range s.senderREMBOptimalLines {
+		createLinePoints(s.bitratePlot,
+			"remb-optimal-output-"+toUserId,
+			line,
+			1,
+			3,
+			color.RGBA{R: 210, G: 180, B: 140, A: 255},
+			draw.CircleGlyph{},
+			smallGlyph,
+		)
+	}
+	for tier, line := range s.tierTargetLines {
+		createLinePoints(s.bitratePlot,
+			"tier-"+strconv.Itoa(tier)+"-target",
+			line,
+			1,
+			5,
+			getColorFromInt(tier),
+			draw.CircleGlyph{},
+			smallGlyph,
+		)
+	}
+	for toUserId, line := range s.receiverTierLines {
+		createLinePoints(s.bitratePlot,
+			"tier-of-"+toUserId,
+			line,
+			1,
+			1,
+			color.RGBA{R: 120, G: 120, B: 220, A: 255},
+			draw.SquareGlyph{},
+			smallGlyph,
+		)
+	}
 	createLinePoints(s.bitratePlot,
 		"target",
 		s.targetLine,
@@ -196,7 +262,10 @@ func NewSlicePlot(controller types.Terminable, kind string, plotBuffers bool, id
 		bufferPlot:             newPlot(id+"'s "+kind+" buffers", "seconds", "ms"),
 		senderCCOptimalLines:   make(map[string]plotter.XYs),
 		senderLossOptimalLines: make(map[string]plotter.XYs),
+		senderREMBOptimalLines: make(map[string]plotter.XYs),
 		currentLevelTimeLines:  make(map[string]plotter.XYs),
+		tierTargetLines:        make(map[int]plotter.XYs),
+		receiverTierLines:      make(map[string]plotter.XYs),
 	}
 }
 
@@ -224,6 +293,13 @@ func (s *SlicePlot) repeatLastWidth() {
 	}
 }
 
+func (s *SlicePlot) repeatLastOutputWidth() {
+	if n := s.outputWidthLine.Len(); n > 0 {
+		_, last := s.outputWidthLine.XY(n - 1)
+		s.outputWidthLine = append(s.outputWidthLine, plotter.XY{s.elapsed(), last})
+	}
+}
+
 func (s *SlicePlot) Loop() {
 	s.started = true
 	s.startedAt = time.Now()
@@ -240,6 +316,7 @@ func (s *SlicePlot) Loop() {
 	// final data
 	s.repeatLastTarget()
 	s.repeatLastWidth()
+	s.repeatLastOutputWidth()
 	s.save()
 }
 
@@ -263,6 +340,15 @@ func (s *SlicePlot) AddFramerate(framerate string) {
 	s.framerateLabels = append(s.framerateLabels, framerate)
 }
 
+// server-side counterpart of AddResolution and AddFramerate
+func (s *SlicePlot) AddOutputConstraint(width, framerate int) {
+	// add two points to display as constant width
+	s.repeatLastOutputWidth()
+	s.outputWidthLine = append(s.outputWidthLine, plotter.XY{s.elapsed(), float64(width)})
+	s.outputFramerateLine = append(s.outputFramerateLine, plotter.XY{s.elapsed(), float64(framerate) * 30})
+	s.outputFramerateLabels = append(s.outputFramerateLabels, strconv.Itoa(framerate))
+}
+
 func (s *SlicePlot) AddKeyFrame() {
 	s.keyframeLine = append(s.keyframeLine, plotter.XY{s.elapsed(), 1500})
 }
@@ -295,6 +381,26 @@ func (s *SlicePlot) AddSenderLossOptimal(toUserId string, bps int) {
 	s.senderLossOptimalLines[toUserId] = append(line, plotter.XY{s.elapsed(), float64(bps) / 1000})
 }
 
+func (s *SlicePlot) AddSenderREMBOptimal(toUserId string, bps int) {
+	line := s.senderREMBOptimalLines[toUserId]
+	s.senderREMBOptimalLines[toUserId] = append(line, plotter.XY{s.elapsed(), float64(bps) / 1000})
+}
+
+func (s *SlicePlot) AddTierTarget(tier int, bps int) {
+	line := s.tierTargetLines[tier]
+	s.tierTargetLines[tier] = append(line, plotter.XY{s.elapsed(), float64(bps) / 1000})
+}
+
+// tiers are plotted with their threshold bitrate, constant between switches
+func (s *SlicePlot) AddReceiverTier(toUserId string, bps int) {
+	line := s.receiverTierLines[toUserId]
+	if n := line.Len(); n > 0 {
+		_, last := line.XY(n - 1)
+		line = append(line, plotter.XY{s.elapsed(), last})
+	}
+	s.receiverTierLines[toUserId] = append(line, plotter.XY{s.elapsed(), float64(bps) / 1000})
+}
+
 func (s *SlicePlot) AddCurrentLevelTime(element string, level uint64) {
 	l := float64(level)
 	if l > 2000 { // don't pollute plot scale
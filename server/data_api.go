@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/datastore"
+	"github.com/ducksouplab/ducksoup/sfu"
+	"github.com/ducksouplab/ducksoup/storage"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+)
+
+const dateFormat = "2006-01-02"
+
+// login and remote address are added to all audit log entries
+func auditEntry(e *zerolog.Event, r *http.Request) *zerolog.Event {
+	login, _, _ := r.BasicAuth()
+	return e.Str("context", "data").Str("login", login).Str("remote", r.RemoteAddr).Str("URL", r.URL.String())
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func dataError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, datastore.ErrInvalidName) {
+		status = http.StatusBadRequest
+	} else if errors.Is(err, fs.ErrNotExist) {
+		status = http.StatusNotFound
+	}
+	auditEntry(datastore.Audit().Error(), r).Err(err).Int("status", status).Msg("data_request_failed")
+	http.Error(w, http.StatusText(status), status)
+}
+
+// accepts 2006-01-02 (a "to" date including the whole day) or RFC 3339 timestamps
+func parseDateParam(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(dateFormat, value, time.Local); err == nil {
+		if name == "to" {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// downloads may be long, they are not constrained by the server WriteTimeout
+func startDownload(w http.ResponseWriter, filename string) {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+}
+
+func listNamespaces(w http.ResponseWriter, r *http.Request) {
+	namespaces, err := datastore.Namespaces()
+	if err != nil {
+		dataError(w, r, err)
+		return
+	}
+	auditEntry(datastore.Audit().Info(), r).Msg("data_listed")
+	writeJSON(w, namespaces)
+}
+
+func listInteractions(w http.ResponseWriter, r *http.Request) {
+	namespace := mux.Vars(r)["namespace"]
+	interactions, err := datastore.Interactions(namespace)
+	if err != nil {
+		dataError(w, r, err)
+		return
+	}
+	auditEntry(datastore.Audit().Info(), r).Str("namespace", namespace).Msg("data_listed")
+	writeJSON(w, interactions)
+}
+
+func downloadInteraction(w http.ResponseWriter, r *http.Request) {
+	namespace, interaction := mux.Vars(r)["namespace"], mux.Vars(r)["interaction"]
+	if !datastore.ValidName(namespace) || !datastore.ValidName(interaction) {
+		dataError(w, r, datastore.ErrInvalidName)
+		return
+	}
+	if sfu.IsRunning(namespace, interaction) {
+		auditEntry(datastore.Audit().Info(), r).Str("namespace", namespace).Str("interaction", interaction).Msg("data_download_refused_running")
+		http.Error(w, "Interaction is running", http.StatusConflict)
+		return
+	}
+
+	startedAt := time.Now()
+	startDownload(w, namespace+"-"+interaction+".zip")
+	stats, err := datastore.WriteInteractionArchive(w, namespace, interaction)
+	entry := auditEntry(datastore.Audit().Info(), r).
+		Str("namespace", namespace).
+		Str("interaction", interaction).
+		Int("files", stats.Files).
+		Int64("bytes", stats.Bytes).
+		Dur("duration", time.Since(startedAt))
+	if err != nil {
+		if stats.Files == 0 {
+			// nothing has been written yet
+			w.Header().Del("Content-Disposition")
+			dataError(w, r, err)
+			return
+		}
+		entry.Err(err).Msg("data_download_failed")
+		return
+	}
+	entry.Msg("data_downloaded")
+}
+
+func downloadNamespace(w http.ResponseWriter, r *http.Request) {
+	namespace := mux.Vars(r)["namespace"]
+	from, fromErr := parseDateParam(r, "from")
+	to, toErr := parseDateParam(r, "to")
+	if fromErr != nil || toErr != nil {
+		http.Error(w, "Invalid date (expected YYYY-MM-DD or RFC 3339)", http.StatusBadRequest)
+		return
+	}
+	if !datastore.ValidName(namespace) {
+		dataError(w, r, datastore.ErrInvalidName)
+		return
+	}
+
+	startedAt := time.Now()
+	startDownload(w, namespace+".zip")
+	// running interactions are left out since their files are incomplete
+	stats, skipped, err := datastore.WriteNamespaceArchive(w, namespace, from, to, sfu.IsRunning)
+	entry := auditEntry(datastore.Audit().Info(), r).
+		Str("namespace", namespace).
+		Time("from", from).
+		Time("to", to).
+		Int("interactions", stats.Interactions).
+		Strs("skipped", skipped).
+		Int("files", stats.Files).
+		Int64("bytes", stats.Bytes).
+		Dur("duration", time.Since(startedAt))
+	if err != nil {
+		if stats.Files == 0 {
+			w.Header().Del("Content-Disposition")
+			dataError(w, r, err)
+			return
+		}
+		entry.Err(err).Msg("data_download_failed")
+		return
+	}
+	entry.Msg("data_downloaded")
+}
+
+func deleteParticipant(w http.ResponseWriter, r *http.Request) {
+	namespace, userId := mux.Vars(r)["namespace"], mux.Vars(r)["userId"]
+	if datastore.PseudonymsEnabled() {
+		userId = datastore.Pseudonym(namespace, userId)
+	}
+	backend, err := storage.FromEnv()
+	if err != nil {
+		dataError(w, r, err)
+		return
+	}
+	report, err := datastore.DeleteParticipant(namespace, userId, sfu.IsRunning, backend)
+	if err != nil {
+		dataError(w, r, err)
+		return
+	}
+	auditEntry(datastore.Audit().Info(), r).
+		Str("namespace", namespace).
+		Str("user", userId).
+		Int("files", report.DeletedFiles).
+		Int("redactedLines", report.RedactedLines).
+		Strs("skipped", report.Skipped).
+		Msg("participant_data_deleted")
+	writeJSON(w, report)
+}
+
+func reidentify(w http.ResponseWriter, r *http.Request) {
+	namespace, pseudonym := mux.Vars(r)["namespace"], mux.Vars(r)["pseudonym"]
+	userId, err := datastore.Reidentify(namespace, pseudonym)
+	if errors.Is(err, datastore.ErrUnknownPseudonym) {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		dataError(w, r, err)
+		return
+	}
+	auditEntry(datastore.Audit().Info(), r).Str("namespace", namespace).Str("user", pseudonym).Msg("pseudonym_reidentified")
+	writeJSON(w, map[string]string{"pseudonym": pseudonym, "userId": userId})
+}
+
+func addDataRoutes(router *mux.Router) {
+	router.HandleFunc("/namespaces", listNamespaces).Methods(http.MethodGet)
+	router.HandleFunc("/namespaces/{namespace}/interactions", listInteractions).Methods(http.MethodGet)
+	router.HandleFunc("/namespaces/{namespace}/interactions/{interaction}/download", downloadInteraction).Methods(http.MethodGet)
+	router.HandleFunc("/namespaces/{namespace}/download", downloadNamespace).Methods(http.MethodGet)
+	router.HandleFunc("/namespaces/{namespace}/participants/{userId}", deleteParticipant).Methods(http.MethodDelete)
+	router.HandleFunc("/namespaces/{namespace}/pseudonyms/{pseudonym}", reidentify).Methods(http.MethodGet)
+}
@@ -12,6 +12,7 @@ import (
 	"slices"
 	"time"
 
+	"github.com/ducksouplab/ducksoup/datastore"
 	"github.com/ducksouplab/ducksoup/env"
 	"github.com/ducksouplab/ducksoup/sfu"
 	"github.com/ducksouplab/ducksoup/stats"
@@ -90,11 +91,13 @@ func notFound(w http.ResponseWriter, r *http.Request) {
 type AudioDataPayload struct {
 	Namespace   string    `json:"namespace"`
 	Interaction string    `json:"interaction"`
+	UserId      string    `json:"userId"`
 	Data        AudioData `json:"data"`
 }
 
 // Write struct
 type AudioData struct {
+	UserId       string  `json:"userId,omitempty"` // needed to delete data of participants who withdraw
 	NoiseLevels  float64 `json:"noiseLevels"`
 	VolumeLevels float64 `json:"volumeLevels"`
 	Passed       bool    `json:"passed"`
@@ -115,6 +118,15 @@ func SaveAudioTestResult(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// same sanitizing as when joining, so that the data folder and pseudonym match the interaction ones
+	payload.Namespace = sfu.ParseString(payload.Namespace)
+	payload.Interaction = sfu.ParseString(payload.Interaction)
+	if !datastore.ValidName(payload.Namespace) || !datastore.ValidName(payload.Interaction) {
+		http.Error(w, "Invalid namespace or interaction", http.StatusBadRequest)
+		return
+	}
+	payload.Data.UserId = datastore.PseudonymizeUser(payload.Namespace, sfu.ParseString(payload.UserId))
+
 	// Format data as JSON for easy parsing later
 	formattedData, err := json.MarshalIndent(payload.Data, "", "  ")
 	if err != nil {
@@ -180,6 +192,13 @@ func Start() {
 		statsRouter.PathPrefix("/").Handler(http.StripPrefix(webPrefix+"/stats/", http.FileServer(http.Dir("./front/static/pages/stats/"))))
 	}
 
+	// data export API with its own basic auth, disabled if credentials are not set
+	if len(env.DataLogin) > 0 && len(env.DataPassword) > 0 {
+		dataRouter := router.PathPrefix(webPrefix + "/data").Subrouter()
+		dataRouter.Use(basicAuthWith(env.DataLogin, env.DataPassword))
+		addDataRoutes(dataRouter)
+	}
+
 	server := &http.Server{
 		Handler:      router,
 		Addr:         ":" + env.Port,
@@ -0,0 +1,67 @@
+package sfu
+
+import (
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/env"
+)
+
+// Adaptation: when the target bitrate of the main video encoder sinks, its input resolution
+// then framerate are stepped down (video.adaptation in sfu.yml) instead of starving the encoder,
+// and stepped back up once the bitrate recovers (with hysteresis, see recoverRatio)
+
+// if the main video encoder input of pipelines may be constrained (the pipeline also needs to
+// encode video). Disabled with the fixed controller, whose video must not change
+func (i *interaction) videoAdaptive() bool {
+	return len(config.SFU.Video.Adaptation.Steps) > 0 && i.jp.BandwidthController != "fixed"
+}
+
+// level 0 is the interaction resolution and framerate, level n applies steps[n-1]
+func adaptationLevel(a config.SFUAdaptation, current, targetBitrate int) int {
+	level := current
+	for level < len(a.Steps) && targetBitrate < a.Steps[level].Below {
+		level++
+	}
+	for level > 0 && float64(targetBitrate) > float64(a.Steps[level-1].Below)*a.RecoverRatio {
+		level--
+	}
+	return level
+}
+
+// framerate is 0 (not constrained) at level 0 or if the step does not lower it
+func adaptedConstraint(a config.SFUAdaptation, level, width, height, framerate int) (int, int, int) {
+	if level == 0 {
+		return width, height, 0
+	}
+	step := a.Steps[level-1]
+	// even dimensions for I420
+	width = int(float64(width)*step.Scale) &^ 1
+	height = int(float64(height)*step.Scale) &^ 1
+	if step.Framerate < 1 {
+		framerate = max(int(float64(framerate)*step.Framerate), 1)
+	} else {
+		framerate = 0
+	}
+	return width, height, framerate
+}
+
+func (ms *mixerSlice) adapt(targetBitrate int) {
+	adaptation := config.SFU.Video.Adaptation
+	from := ms.adaptationLevel
+	to := adaptationLevel(adaptation, from, targetBitrate)
+	if to == from {
+		return
+	}
+	ms.adaptationLevel = to
+
+	jp := ms.fromPs.jp
+	width, height, framerate := adaptedConstraint(adaptation, to, jp.Width, jp.Height, jp.Framerate)
+	ms.pipeline.SetVideoConstraint(width, height, framerate)
+	ms.logInfo().Int("from", from).Int("to", to).Int("width", width).Int("height", height).Int("framerate", framerate).Int("target", targetBitrate/1000).Str("unit", "kbit/s").Msg("video_adaptation_stepped")
+	// plot
+	if env.GeneratePlots {
+		if framerate == 0 {
+			framerate = jp.Framerate
+		}
+		ms.plot.AddOutputConstraint(width, framerate)
+	}
+}
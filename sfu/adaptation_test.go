@@ -0,0 +1,63 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func TestAdaptationLevel(t *testing.T) {
+	a := config.SFUAdaptation{
+		RecoverRatio: 1.3,
+		Steps: []config.SFUAdaptationStep{
+			{Below: 450000, Scale: 0.75, Framerate: 1},
+			{Below: 300000, Scale: 0.5, Framerate: 1},
+			{Below: 200000, Scale: 0.5, Framerate: 0.5},
+		},
+	}
+
+	cases := []struct {
+		current  int
+		target   int
+		expected int
+	}{
+		{0, 800000, 0},
+		{0, 400000, 1},
+		{0, 150000, 3}, // several steps at once
+		{1, 500000, 1}, // not recovered enough
+		{1, 600000, 0},
+		{3, 250000, 3}, // hysteresis
+		{3, 270000, 2},
+		{3, 1000000, 0},
+	}
+	for _, c := range cases {
+		if got := adaptationLevel(a, c.current, c.target); got != c.expected {
+			t.Errorf("from level %v with target %v: got %v, expected %v", c.current, c.target, got, c.expected)
+		}
+	}
+
+	if w, h, f := adaptedConstraint(a, 1, 854, 480, 30); w != 640 || h != 360 || f != 0 {
+		t.Errorf("unexpected level 1 constraint: %vx%v@%v", w, h, f)
+	}
+	if w, h, f := adaptedConstraint(a, 3, 854, 480, 30); w != 426 || h != 240 || f != 15 {
+		t.Errorf("unexpected level 3 constraint: %vx%v@%v", w, h, f)
+	}
+}
+
+func TestFixedControllerDisablesTiersAndAdaptation(t *testing.T) {
+	defer func(video config.SFUStream) { config.SFU.Video = video }(config.SFU.Video)
+	config.SFU.Video.Tiers = []int{300000, 700000, 1200000}
+	config.SFU.Video.Adaptation.Steps = []config.SFUAdaptationStep{{Below: 300000, Scale: 0.5, Framerate: 1}}
+
+	for _, controller := range []string{"loss", "fixed"} {
+		i := &interaction{size: 3, jp: types.JoinPayload{BandwidthController: controller}}
+		enabled := controller != "fixed"
+		if got := i.videoTierBitrates(types.JoinPayload{}) != nil; got != enabled {
+			t.Errorf("%v: tiers enabled %v, want %v", controller, got, enabled)
+		}
+		if got := i.videoAdaptive(); got != enabled {
+			t.Errorf("%v: adaptation enabled %v, want %v", controller, got, enabled)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package sfu
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/config"
+)
+
+// Audio levels: RMS and peak levels (dBFS) of the decoded audio of each participant, before (dry)
+// and after (wet, only with an audio fx) processing, are measured by the pipeline every
+// audio.level.interval ms (see config/sfu.yml). Besides voice activity detection (see vad.go), the
+// latest ones are shown on the stats page and sent to the participant every audio.meter.period ms
+// (audio_level messages), for instance to display a microphone meter
+
+type levelMeasure struct {
+	RMS  float64 `json:"rms"`
+	Peak float64 `json:"peak"`
+}
+
+type audioLevels struct {
+	Dry *levelMeasure `json:"dry,omitempty"`
+	Wet *levelMeasure `json:"wet,omitempty"`
+}
+
+type audioMeter struct {
+	sync.Mutex
+	levels   audioLevels
+	lastSent time.Time
+	sendCh   chan audioLevels // holds the latest levels not sent yet
+}
+
+func newAudioMeter() *audioMeter {
+	return &audioMeter{sendCh: make(chan audioLevels, 1)}
+}
+
+func roundLevel(level float64) float64 {
+	return math.Round(level*10) / 10
+}
+
+func (m *audioMeter) update(source string, rms, peak float64) {
+	m.Lock()
+	defer m.Unlock()
+
+	measure := &levelMeasure{roundLevel(rms), roundLevel(peak)}
+	if source == "dry" {
+		m.levels.Dry = measure
+	} else if source == "wet" {
+		m.levels.Wet = measure
+	}
+}
+
+// nil if nothing has been measured yet
+func (m *audioMeter) current() *audioLevels {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.levels.Dry == nil && m.levels.Wet == nil {
+		return nil
+	}
+	levels := m.levels
+	return &levels
+}
+
+// latest levels, if they are to be sent at now
+func (m *audioMeter) due(now time.Time, period time.Duration) (levels audioLevels, ok bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	if period <= 0 || now.Sub(m.lastSent) < period {
+		return
+	}
+	m.lastSent = now
+	return m.levels, true
+}
+
+// does not block (called from the pipeline bus): levels not sent yet are replaced
+func (m *audioMeter) queue(levels audioLevels) {
+	for {
+		select {
+		case m.sendCh <- levels:
+			return
+		default:
+		}
+		select {
+		case <-m.sendCh:
+		default:
+		}
+	}
+}
+
+// a single sender per participant keeps messages in order
+func (ms *mixerSlice) loopSendAudioLevels() {
+	for {
+		select {
+		case <-ms.Done():
+			return
+		case levels := <-ms.meter.sendCh:
+			ms.fromPs.ws.sendWithPayload("audio_level", levels)
+		}
+	}
+}
+
+// nil for video
+func (ms *mixerSlice) audioLevels() *audioLevels {
+	if ms.meter == nil {
+		return nil
+	}
+	return ms.meter.current()
+}
+
+// implements types.AudioLevelWriter (levels in dBFS)
+func (ms *mixerSlice) WriteAudioLevel(source string, rms, peak float64, at time.Time) {
+	if source == "dry" {
+		if ms.fromPs.vad != nil {
+			ms.fromPs.detectSpeech(at, rms)
+		}
+		if len(ms.i.reactions) > 0 {
+			ms.i.react(ms.fromPs.userId, at, rms, peak)
+		}
+	}
+	if ms.meter == nil {
+		return
+	}
+	ms.meter.update(source, rms, peak)
+	period := time.Duration(config.SFU.Audio.Meter.Period) * time.Millisecond
+	if levels, ok := ms.meter.due(at, period); ok {
+		ms.meter.queue(levels)
+	}
+}
@@ -0,0 +1,55 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAudioMeter(t *testing.T) {
+	m := &audioMeter{}
+	if m.current() != nil {
+		t.Error("levels before any measure")
+	}
+	m.update("dry", -20.04, -3.06)
+	levels := m.current()
+	if levels == nil || levels.Dry == nil || levels.Wet != nil || levels.Dry.RMS != -20 || levels.Dry.Peak != -3.1 {
+		t.Fatalf("unexpected levels: %+v", levels)
+	}
+
+	t0 := time.Now()
+	if _, ok := m.due(t0, 0); ok {
+		t.Error("levels due while disabled")
+	}
+	if _, ok := m.due(t0, 200*time.Millisecond); !ok {
+		t.Error("first levels not due")
+	}
+	if _, ok := m.due(t0.Add(100*time.Millisecond), 200*time.Millisecond); ok {
+		t.Error("levels due before period")
+	}
+	m.update("wet", -30, -10)
+	levels2, ok := m.due(t0.Add(200*time.Millisecond), 200*time.Millisecond)
+	if !ok || levels2.Wet == nil || levels2.Wet.RMS != -30 {
+		t.Errorf("unexpected due levels: %v %+v", ok, levels2)
+	}
+}
+
+func TestAudioMeterQueue(t *testing.T) {
+	m := newAudioMeter()
+	for _, rms := range []float64{-30, -20, -10} {
+		m.queue(audioLevels{Dry: &levelMeasure{RMS: rms}})
+	}
+	// only the latest levels are kept until sent
+	select {
+	case levels := <-m.sendCh:
+		if levels.Dry.RMS != -10 {
+			t.Errorf("unexpected queued levels: %+v", levels.Dry)
+		}
+	default:
+		t.Fatal("no queued levels")
+	}
+	select {
+	case levels := <-m.sendCh:
+		t.Errorf("levels queued twice: %+v", levels.Dry)
+	default:
+	}
+}
@@ -0,0 +1,58 @@
+package sfu
+
+import "github.com/ducksouplab/ducksoup/types"
+
+// A/V offset: audio forwarded from a participant is shifted relative to their video (positive
+// offsets delay audio, negative ones delay video), set at join (avOffset in the join payload) and
+// changed live with client_av_offset messages, possibly with a ramp (see delay.go). Each kind has
+// its own delay line (audio and video packets don't wait for each other), applied before the
+// shared delay line and impairments. Recordings are not affected, except the wet recording of
+// muxed templates if recordAVOffset is set at join (see gst/template.go)
+
+const (
+	// ms
+	avOffsetMax = 2000
+)
+
+type avOffsetPayload struct {
+	UserId string `json:"userId"`
+	Offset int    `json:"offset"` // ms
+	Ramp   int    `json:"ramp"`   // ms
+}
+
+func sanitizeAVOffset(offset int) int {
+	return min(max(offset, -avOffsetMax), avOffsetMax)
+}
+
+// delays (ms) of audio and video so that audio is shifted by offset
+func avOffsetDelays(offset int) (audio, video int) {
+	return max(offset, 0), max(-offset, 0)
+}
+
+// ms
+func (ps *peerServer) currentAVOffset() int {
+	return ps.audioOffset.current() - ps.videoOffset.current()
+}
+
+func (ps *peerServer) logAVOffset(offset, ramp int, fromUserId string, previous int) {
+	ps.logChange(fromUserId).
+		Int("offset", offset).
+		Int("ramp", ramp).
+		Int("previousOffset", previous).
+		Msg("av_offset_applied")
+	recordChange(ps.i, &ps.i.avOffsets, types.ManifestAVOffset{
+		ManifestChange: newChange(ps.userId, fromUserId),
+		Offset:         offset,
+		Ramp:           ramp,
+	})
+}
+
+func (ps *peerServer) controlAVOffset(payload avOffsetPayload, fromUserId string) {
+	offset := sanitizeAVOffset(payload.Offset)
+	ramp := max(payload.Ramp, 0)
+	previous := ps.currentAVOffset()
+	audio, video := avOffsetDelays(offset)
+	ps.audioOffset.set(audio, ramp)
+	ps.videoOffset.set(video, ramp)
+	ps.logAVOffset(offset, ramp, fromUserId, previous)
+}
@@ -0,0 +1,21 @@
+package sfu
+
+import "testing"
+
+func TestAVOffsetDelays(t *testing.T) {
+	cases := []struct {
+		offset int
+		audio  int
+		video  int
+	}{
+		{0, 0, 0},
+		{300, 300, 0},
+		{-150, 0, 150},
+		{5000, avOffsetMax, 0}, // sanitized
+	}
+	for _, c := range cases {
+		if audio, video := avOffsetDelays(sanitizeAVOffset(c.offset)); audio != c.audio || video != c.video {
+			t.Errorf("offset %v: got audio %v and video %v", c.offset, audio, video)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package sfu
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/pion/rtcp"
+)
+
+// Bandwidth control: each receiver of an outgoing track has a BandwidthController estimating
+// the bitrate it can be sent, and estimates are aggregated across receivers (BitrateAggregator)
+// to set the encoding bitrate. Both strategies are chosen for the whole interaction by the
+// first participant joining it (bandwidthController and bitrateAggregator in the join payload).
+// With video tiers (see tiers.go), estimates select the tier forwarded to each receiver instead
+// of being aggregated, and each tier bounds the estimates of its receivers. Tiers and adaptation
+// are disabled with the fixed controller, so that its bitrate and video are not altered
+
+const defaultBitratePercentile = 50
+
+type BandwidthController interface {
+	// called once the interaction has started
+	Start()
+	// called with RTCP packets received on the sender
+	HandleRTCP(packet rtcp.Packet)
+	// bit/s
+	Estimate() int
+	// true if PLIs sent by the receiver also request a keyframe from the encoder of the pipeline
+	// (and not only from the sending browser)
+	EncoderPLI() bool
+}
+
+func newBandwidthController(sc *senderController) BandwidthController {
+	switch sc.ms.i.jp.BandwidthController {
+	case "gcc":
+		if sc.ccEstimator != nil {
+			return &gccController{sc: sc}
+		}
+	case "remb":
+		return &rembController{sc: sc, bitrate: sc.ms.streamConfig.DefaultBitrate}
+	case "fixed":
+		bitrate := sc.ms.i.jp.FixedBitrate
+		if bitrate <= 0 {
+			bitrate = sc.ms.streamConfig.DefaultBitrate
+		}
+		return fixedController(bitrate)
+	}
+	return &lossController{sc: sc, bitrate: sc.ms.streamConfig.DefaultBitrate}
+}
+
+// see https://datatracker.ietf.org/doc/html/draft-ietf-rmcat-gcc-02
+// credits to https://github.com/jech/galene
+type lossController struct {
+	sync.Mutex
+	sc      *senderController
+	bitrate int
+}
+
+func (c *lossController) Start() {}
+
+func (c *lossController) HandleRTCP(packet rtcp.Packet) {
+	if rr, ok := packet.(*rtcp.ReceiverReport); ok {
+		for _, r := range rr.Reports {
+			if r.SSRC == uint32(c.sc.ssrc) {
+				c.updateFromLoss(int(r.FractionLost))
+			}
+		}
+	}
+}
+
+func (c *lossController) updateFromLoss(loss int) {
+	c.Lock()
+	defer c.Unlock()
+
+	var newBitrate int
+	if loss < 5 {
+		// loss < 0.02, multiply by 1.05
+		newBitrate = c.bitrate * 269 / 256
+	} else if loss > 25 {
+		// loss > 0.1, multiply by (1 - loss/2)
+		newBitrate = c.bitrate * (512 - loss) / 512
+		c.sc.logInfo().Int("value", loss).Msg("loss_threshold_exceeded")
+	} else {
+		newBitrate = c.bitrate
+	}
+
+	c.bitrate = c.sc.capRate(newBitrate)
+	c.sc.logInfo().Str("kind", c.sc.kind).Int("value", c.bitrate).Msg("loss_optimal_bitrate_updated")
+	// plot
+	if env.GeneratePlots {
+		c.sc.ms.plot.AddSenderLossOptimal(c.sc.toUserId, c.bitrate)
+	}
+}
+
+func (c *lossController) Estimate() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.bitrate
+}
+
+func (c *lossController) EncoderPLI() bool {
+	return false
+}
+
+// estimates come from the congestion controller interceptor (TWCC feedback), see engine/options.go
+type gccController struct {
+	sync.Mutex
+	sc      *senderController
+	bitrate int // set by the estimator
+}
+
+func (c *gccController) Start() {
+	estimator := c.sc.ccEstimator
+	estimator.OnTargetBitrateChange(func(bitrate int) {
+		c.Lock()
+		// we could leave room for audio and subtracting - config.Audio.MaxBitrate
+		c.bitrate = c.sc.capRate(bitrate)
+		c.sc.logInfo().Str("kind", c.sc.kind).Int("value", c.bitrate).Msg("cc_optimal_bitrate_updated")
+		// plot
+		if env.GeneratePlots {
+			c.sc.ms.plot.AddSenderCCOptimal(c.sc.toUserId, c.bitrate)
+		}
+		c.Unlock()
+		c.sc.logDebug().Str("target", fmt.Sprintf("%v", estimator.GetTargetBitrate())).Str("stats", fmt.Sprintf("%v", estimator.GetStats())).Msg("gcc")
+	})
+}
+
+func (c *gccController) HandleRTCP(packet rtcp.Packet) {}
+
+func (c *gccController) Estimate() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.bitrate
+}
+
+func (c *gccController) EncoderPLI() bool {
+	return true
+}
+
+// estimates are sent by the receiver (goog-remb feedback, see engine/engine.go)
+type rembController struct {
+	sync.Mutex
+	sc      *senderController
+	bitrate int
+}
+
+func (c *rembController) Start() {}
+
+func (c *rembController) HandleRTCP(packet rtcp.Packet) {
+	remb, ok := packet.(*rtcp.ReceiverEstimatedMaximumBitrate)
+	if !ok || !slices.Contains(remb.SSRCs, uint32(c.sc.ssrc)) {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.bitrate = c.sc.capRate(int(remb.Bitrate))
+	c.sc.logInfo().Str("kind", c.sc.kind).Int("value", c.bitrate).Msg("remb_optimal_bitrate_updated")
+	// plot
+	if env.GeneratePlots {
+		c.sc.ms.plot.AddSenderREMBOptimal(c.sc.toUserId, c.bitrate)
+	}
+}
+
+func (c *rembController) Estimate() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.bitrate
+}
+
+func (c *rembController) EncoderPLI() bool {
+	return false
+}
+
+// pins the bitrate (not bounded by sfu.yml settings, nor by tiers) to control stimuli
+type fixedController int
+
+func (c fixedController) Start() {}
+
+func (c fixedController) HandleRTCP(packet rtcp.Packet) {}
+
+func (c fixedController) Estimate() int {
+	return int(c)
+}
+
+func (c fixedController) EncoderPLI() bool {
+	return false
+}
+
+type BitrateAggregator interface {
+	// estimates are indexed by receiving user id, returns 0 if there is none
+	Aggregate(estimates map[string]int) int
+}
+
+func newBitrateAggregator(jp types.JoinPayload) BitrateAggregator {
+	switch jp.BitrateAggregator {
+	case "percentile":
+		return percentileAggregator(jp.BitratePercentile)
+	case "weighted":
+		return weightedAggregator(jp.BitrateWeights)
+	}
+	return minAggregator{}
+}
+
+// every receiver is sent a bitrate it can handle
+type minAggregator struct{}
+
+func (minAggregator) Aggregate(estimates map[string]int) int {
+	values := make([]int, 0, len(estimates))
+	for _, e := range estimates {
+		values = append(values, e)
+	}
+	return minInt(values)
+}
+
+// nearest-rank percentile, the given share of receivers having an estimate lower or equal
+type percentileAggregator int
+
+func (p percentileAggregator) Aggregate(estimates map[string]int) int {
+	if len(estimates) == 0 {
+		return 0
+	}
+	values := make([]int, 0, len(estimates))
+	for _, e := range estimates {
+		values = append(values, e)
+	}
+	slices.Sort(values)
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(values))))
+	return values[max(rank-1, 0)]
+}
+
+// weighted mean, weights are indexed by user id (1 if missing, 0 to ignore a receiver)
+type weightedAggregator map[string]float64
+
+func (w weightedAggregator) Aggregate(estimates map[string]int) int {
+	var sum, weights float64
+	for userId, e := range estimates {
+		weight, ok := w[userId]
+		if !ok {
+			weight = 1
+		}
+		if weight <= 0 {
+			continue
+		}
+		sum += weight * float64(e)
+		weights += weight
+	}
+	if weights == 0 {
+		return minAggregator{}.Aggregate(estimates)
+	}
+	return int(sum / weights)
+}
@@ -0,0 +1,29 @@
+package sfu
+
+import "testing"
+
+func TestBitrateAggregators(t *testing.T) {
+	estimates := map[string]int{"a": 400000, "b": 1000000, "c": 200000, "d": 800000}
+
+	cases := []struct {
+		name       string
+		aggregator BitrateAggregator
+		expected   int
+	}{
+		{"min", minAggregator{}, 200000},
+		{"percentile 50", percentileAggregator(50), 400000},
+		{"percentile 75", percentileAggregator(75), 800000},
+		{"percentile 100", percentileAggregator(100), 1000000},
+		{"weighted (default weights)", weightedAggregator(nil), 600000},
+		{"weighted", weightedAggregator{"a": 2, "b": 0, "c": 0}, 533333},
+		{"weighted (all ignored)", weightedAggregator{"a": 0, "b": 0, "c": 0, "d": 0}, 200000},
+	}
+	for _, c := range cases {
+		if got := c.aggregator.Aggregate(estimates); got != c.expected {
+			t.Errorf("%v: got %v, expected %v", c.name, got, c.expected)
+		}
+	}
+	if got := percentileAggregator(50).Aggregate(nil); got != 0 {
+		t.Errorf("got %v without estimates", got)
+	}
+}
@@ -0,0 +1,163 @@
+package sfu
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/helpers"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	dataRoot = "data"
+	mb       = 1024 * 1024
+)
+
+var (
+	errDiskFull      = errors.New("disk-full")
+	errQuotaExceeded = errors.New("quota-exceeded")
+	dataMonitorOnce  sync.Once
+	namespaceSizes   = &namespaceSizeCache{index: make(map[string]cachedSize)}
+)
+
+// walking a namespace folder may be long, so sizes are reused during a check period
+// (and computed without holding the cache lock)
+type namespaceSizeCache struct {
+	sync.Mutex
+	index map[string]cachedSize
+}
+
+type cachedSize struct {
+	bytes      int64
+	computedAt time.Time
+}
+
+func (c *namespaceSizeCache) get(namespace string) (int64, error) {
+	c.Lock()
+	cached, ok := c.index[namespace]
+	c.Unlock()
+
+	if ok && time.Since(cached.computedAt) < checkPeriod() {
+		return cached.bytes, nil
+	}
+	size, err := helpers.DirSize(dataRoot + "/" + namespace)
+	if err != nil {
+		return 0, err
+	}
+	c.Lock()
+	c.index[namespace] = cachedSize{size, time.Now()}
+	c.Unlock()
+	return size, nil
+}
+
+func checkPeriod() time.Duration {
+	return time.Duration(config.Data.CheckPeriod) * time.Second
+}
+
+func isBelowFreeSpace(thresholdMB int) (below bool, freeMB uint64) {
+	if thresholdMB <= 0 {
+		return
+	}
+	free, err := helpers.FreeDiskSpace(dataRoot)
+	if err != nil {
+		log.Error().Str("context", "interaction").Err(err).Msg("free_space_check_failed")
+		return
+	}
+	freeMB = free / mb
+	return freeMB < uint64(thresholdMB), freeMB
+}
+
+func isOverQuota(namespace string) (over bool, sizeMB int64) {
+	quota := config.Data.Quotas[namespace]
+	if quota <= 0 {
+		return
+	}
+	size, err := namespaceSizes.get(namespace)
+	if err != nil {
+		// namespace folder may not exist yet
+		return
+	}
+	sizeMB = size / mb
+	return sizeMB >= int64(quota), sizeMB
+}
+
+// called before creating a new interaction, joining a running one is always possible
+func checkAdmission(namespace, interactionName string) error {
+	if below, freeMB := isBelowFreeSpace(config.Data.MinFreeSpace); below {
+		log.Error().Str("context", "interaction").Str("namespace", namespace).Str("interaction", interactionName).
+			Uint64("value", freeMB).Str("unit", "MB").Msg("interaction_refused_disk_full")
+		return errDiskFull
+	}
+	if over, sizeMB := isOverQuota(namespace); over {
+		log.Error().Str("context", "interaction").Str("namespace", namespace).Str("interaction", interactionName).
+			Int64("value", sizeMB).Str("unit", "MB").Msg("interaction_refused_quota_exceeded")
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+func startDataMonitor() {
+	dataMonitorOnce.Do(func() {
+		go loopDataMonitor()
+	})
+}
+
+// warns running interactions (once, until the situation recovers) when free
+// space or namespace quotas are getting critical during recordings
+func loopDataMonitor() {
+	ticker := time.NewTicker(checkPeriod())
+	defer ticker.Stop()
+
+	alerts := newDataAlerts()
+	for range ticker.C {
+		alerts.check(interactionStoreSingleton.all())
+	}
+}
+
+// alerts already sent, so that they are sent again only once the situation has recovered
+type dataAlerts struct {
+	disk   map[*interaction]bool
+	quota  map[*interaction]bool
+	wasLow bool
+}
+
+func newDataAlerts() *dataAlerts {
+	return &dataAlerts{disk: make(map[*interaction]bool), quota: make(map[*interaction]bool)}
+}
+
+func (a *dataAlerts) check(running []*interaction) {
+	isLow, freeMB := isBelowFreeSpace(config.Data.WarnFreeSpace)
+	if isLow != a.wasLow {
+		kind := "disk_space_recovered"
+		if isLow {
+			kind = "disk_space_low"
+		}
+		eventHubSingleton.publish(Event{Kind: kind, Value: freeMB})
+		a.wasLow = isLow
+	}
+
+	nextDisk := make(map[*interaction]bool)
+	nextQuota := make(map[*interaction]bool)
+	for _, i := range running {
+		if isLow {
+			if !a.disk[i] {
+				i.logger.Error().Str("context", "interaction").Uint64("value", freeMB).Str("unit", "MB").Msg("disk_space_low")
+			}
+			nextDisk[i] = true
+		} else if a.disk[i] {
+			i.logger.Info().Str("context", "interaction").Uint64("value", freeMB).Str("unit", "MB").Msg("disk_space_recovered")
+		}
+
+		if over, sizeMB := isOverQuota(i.namespace); over {
+			if !a.quota[i] {
+				i.logger.Error().Str("context", "interaction").Int64("value", sizeMB).Str("unit", "MB").Msg("namespace_quota_exceeded")
+				eventHubSingleton.publish(Event{Kind: "namespace_quota_exceeded", Namespace: i.namespace, Interaction: i.name, Value: sizeMB})
+			}
+			nextQuota[i] = true
+		}
+	}
+	a.disk = nextDisk
+	a.quota = nextQuota
+}
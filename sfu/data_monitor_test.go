@@ -0,0 +1,100 @@
+package sfu
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/rs/zerolog"
+)
+
+// runs the test in a temporary folder, with data settings restored afterwards
+func withDataFolder(t *testing.T) {
+	wd, _ := os.Getwd()
+	os.Chdir(t.TempDir())
+	data := config.Data
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		config.Data = data
+	})
+	if err := os.MkdirAll(dataRoot, 0775); err != nil {
+		t.Fatal(err)
+	}
+	config.Data.MinFreeSpace = 0
+	config.Data.WarnFreeSpace = 0
+	config.Data.Quotas = map[string]int{}
+}
+
+func writeSizedFile(t *testing.T, path string, size int) {
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckAdmission(t *testing.T) {
+	withDataFolder(t)
+	writeSizedFile(t, dataRoot+"/ns-full/interaction/recording.mkv", 2*mb)
+	writeSizedFile(t, dataRoot+"/ns-ok/interaction/recording.mkv", mb/2)
+	config.Data.Quotas = map[string]int{"ns-full": 1, "ns-ok": 1}
+
+	if err := checkAdmission("ns-full", "interaction"); err != errQuotaExceeded {
+		t.Errorf("namespace over quota: got %v, want %v", err, errQuotaExceeded)
+	}
+	if err := checkAdmission("ns-ok", "interaction"); err != nil {
+		t.Errorf("namespace under quota: got %v", err)
+	}
+	if err := checkAdmission("ns-new", "interaction"); err != nil {
+		t.Errorf("namespace without folder nor quota: got %v", err)
+	}
+	// far more than any disk
+	config.Data.MinFreeSpace = 1 << 40
+	if err := checkAdmission("ns-ok", "interaction"); err != errDiskFull {
+		t.Errorf("disk full: got %v, want %v", err, errDiskFull)
+	}
+}
+
+func TestDataAlertsOnce(t *testing.T) {
+	withDataFolder(t)
+	writeSizedFile(t, dataRoot+"/ns-alerts/interaction/recording.mkv", 2*mb)
+
+	var logs bytes.Buffer
+	i := &interaction{namespace: "ns-alerts", name: "interaction", logger: zerolog.New(&logs)}
+	running := []*interaction{i}
+	alerts := newDataAlerts()
+
+	config.Data.WarnFreeSpace = 1 << 40
+	config.Data.Quotas = map[string]int{"ns-alerts": 1}
+	alerts.check(running)
+	alerts.check(running)
+	if n := strings.Count(logs.String(), "disk_space_low"); n != 1 {
+		t.Errorf("disk_space_low logged %v times, want 1", n)
+	}
+	if n := strings.Count(logs.String(), "namespace_quota_exceeded"); n != 1 {
+		t.Errorf("namespace_quota_exceeded logged %v times, want 1", n)
+	}
+
+	config.Data.WarnFreeSpace = 0
+	config.Data.Quotas = map[string]int{}
+	alerts.check(running)
+	alerts.check(running)
+	if n := strings.Count(logs.String(), "disk_space_recovered"); n != 1 {
+		t.Errorf("disk_space_recovered logged %v times, want 1", n)
+	}
+
+	// alerted again once recovered
+	config.Data.WarnFreeSpace = 1 << 40
+	config.Data.Quotas = map[string]int{"ns-alerts": 1}
+	alerts.check(running)
+	if n := strings.Count(logs.String(), "disk_space_low"); n != 2 {
+		t.Errorf("disk_space_low logged %v times, want 2", n)
+	}
+	if n := strings.Count(logs.String(), "namespace_quota_exceeded"); n != 2 {
+		t.Errorf("namespace_quota_exceeded logged %v times, want 2", n)
+	}
+}
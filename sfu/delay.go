@@ -0,0 +1,158 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+// Delay: a precise delay added to media forwarded from a participant, on top of (and before)
+// impairments. Audio and video share the same delay line so that they stay in sync. It is set at
+// join (delay in the join payload) and changed live with client_delay messages, possibly with a
+// ramp: the delay then changes linearly, so that receivers' jitter buffers adapt smoothly (when
+// lowered faster than real time, held packets are released in a burst)
+
+const (
+	// ms
+	delayMax = 5000
+)
+
+type delayPayload struct {
+	UserId string `json:"userId"`
+	Delay  int    `json:"delay"` // ms
+	Ramp   int    `json:"ramp"`  // ms
+}
+
+// linear change from -> to
+type delayRamp struct {
+	from  time.Duration
+	to    time.Duration
+	start time.Time
+	ramp  time.Duration
+}
+
+// the effective delay is the sum of the requested one and of the one set by latency
+// equalization (see latency.go)
+type delayLine struct {
+	sync.Mutex
+	requested    delayRamp
+	compensation delayRamp
+	scheduler    *packetScheduler
+	dropped      int // packets dropped because the queue was full
+	reported     int // dropped packets already logged
+}
+
+func sanitizeDelay(delay int) int {
+	return min(max(delay, 0), delayMax)
+}
+
+func newDelayLine(delay int) *delayLine {
+	d := time.Duration(delay) * time.Millisecond
+	return &delayLine{
+		requested: delayRamp{from: d, to: d},
+		scheduler: newPacketScheduler(),
+	}
+}
+
+func (r *delayRamp) at(now time.Time) time.Duration {
+	elapsed := now.Sub(r.start)
+	if r.ramp <= 0 || elapsed >= r.ramp {
+		return r.to
+	}
+	return r.from + time.Duration(float64(r.to-r.from)*float64(elapsed)/float64(r.ramp))
+}
+
+// returns the delay (in ms) in effect before the change
+func (r *delayRamp) set(now time.Time, delay, ramp int) (previous int) {
+	current := r.at(now)
+	r.from = current
+	r.to = time.Duration(delay) * time.Millisecond
+	r.start = now
+	r.ramp = time.Duration(max(ramp, 0)) * time.Millisecond
+	return int(current / time.Millisecond)
+}
+
+func (dl *delayLine) at(now time.Time) time.Duration {
+	return dl.requested.at(now) + dl.compensation.at(now)
+}
+
+// returns the requested delay (in ms) in effect before the change
+func (dl *delayLine) set(delay, ramp int) (previous int) {
+	dl.Lock()
+	defer dl.Unlock()
+
+	return dl.requested.set(time.Now(), delay, ramp)
+}
+
+// returns the compensation (in ms) in effect before the change
+func (dl *delayLine) compensate(delay, ramp int) (previous int) {
+	dl.Lock()
+	defer dl.Unlock()
+
+	return dl.compensation.set(time.Now(), delay, ramp)
+}
+
+// effective delay in ms
+func (dl *delayLine) current() int {
+	dl.Lock()
+	defer dl.Unlock()
+
+	return int(dl.at(time.Now()) / time.Millisecond)
+}
+
+// delivers buf right away if there is no delay (and then returns the delivery error),
+// otherwise queues a copy of buf (dropped if the queue is full)
+func (dl *delayLine) push(buf []byte, deliver func([]byte) error) error {
+	dl.Lock()
+	now := time.Now()
+	delay := dl.at(now)
+	if delay <= 0 && dl.scheduler.idle() {
+		dl.Unlock()
+		return deliver(buf)
+	}
+	defer dl.Unlock()
+
+	if !dl.scheduler.schedule(buf, now.Add(delay), deliver) {
+		dl.dropped++
+	}
+	return nil
+}
+
+// count of dropped packets
+func (dl *delayLine) droppedCount() int {
+	dl.Lock()
+	defer dl.Unlock()
+
+	return dl.dropped
+}
+
+// count of dropped packets not returned by a previous call
+func (dl *delayLine) unreportedDrops() int {
+	dl.Lock()
+	defer dl.Unlock()
+
+	unreported := dl.dropped - dl.reported
+	dl.reported = dl.dropped
+	return unreported
+}
+
+func (ps *peerServer) offsetLine(kind string) *delayLine {
+	if kind == "video" {
+		return ps.videoOffset
+	}
+	return ps.audioOffset
+}
+
+// packets of kind forwarded from ps dropped by its A/V offset and (shared) delay lines
+func (ps *peerServer) delayDrops(kind string) int {
+	return ps.offsetLine(kind).droppedCount() + ps.delayLine.droppedCount()
+}
+
+// called periodically (see mixerSlice.loopStats) so that drops are logged at most once a period
+func (ps *peerServer) logDelayDrops(kind string) {
+	if dropped := ps.offsetLine(kind).unreportedDrops(); dropped > 0 {
+		ps.logError().Str("context", "track").Str("kind", kind).Str("line", "av_offset").Int("dropped", dropped).Msg("delayed_packets_dropped")
+	}
+	if dropped := ps.delayLine.unreportedDrops(); dropped > 0 {
+		ps.logError().Str("context", "track").Str("line", "delay").Int("dropped", dropped).Msg("delayed_packets_dropped")
+	}
+}
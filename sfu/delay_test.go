@@ -0,0 +1,92 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelayLineRamp(t *testing.T) {
+	dl := newDelayLine(0)
+	start := time.Now()
+	dl.set(1000, 2000)
+	cases := []struct {
+		elapsed  time.Duration
+		expected time.Duration
+	}{
+		{0, 0},
+		{time.Second, 500 * time.Millisecond},
+		{2 * time.Second, time.Second},
+		{3 * time.Second, time.Second},
+	}
+	for _, c := range cases {
+		if got := dl.at(start.Add(c.elapsed)); got < c.expected-time.Millisecond || got > c.expected+time.Millisecond {
+			t.Errorf("after %v: got %v, expected %v", c.elapsed, got, c.expected)
+		}
+	}
+
+	// immediate change starting from the current delay
+	if previous := dl.set(200, 0); previous > 10 {
+		t.Errorf("unexpected previous delay %v", previous)
+	}
+	if got := dl.current(); got != 200 {
+		t.Errorf("got delay %v", got)
+	}
+}
+
+func TestDelayLinePush(t *testing.T) {
+	dl := newDelayLine(50)
+	done := make(chan struct{})
+	defer close(done)
+	go dl.scheduler.loop(done)
+
+	delivered := make(chan time.Time, 1)
+	start := time.Now()
+	dl.push([]byte{0}, func([]byte) error {
+		delivered <- time.Now()
+		return nil
+	})
+	select {
+	case at := <-delivered:
+		if elapsed := at.Sub(start); elapsed < 50*time.Millisecond {
+			t.Errorf("packet not delayed: %v", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("packet not delivered")
+	}
+}
+
+func TestDelayLineCompensation(t *testing.T) {
+	dl := newDelayLine(100)
+	dl.compensate(50, 0)
+	if got := dl.current(); got != 150 {
+		t.Errorf("got delay %v", got)
+	}
+	// requested and compensating delays are independent
+	if previous := dl.set(0, 0); previous != 100 {
+		t.Errorf("unexpected previous delay %v", previous)
+	}
+	if got := dl.current(); got != 50 {
+		t.Errorf("got delay %v", got)
+	}
+}
+
+func TestDelayLineDrops(t *testing.T) {
+	// the scheduler loop is not running: queued packets are never delivered
+	dl := newDelayLine(1000)
+	for n := 0; n < packetSchedulerSize+10; n++ {
+		dl.push([]byte{0}, func([]byte) error { return nil })
+	}
+	if dropped := dl.droppedCount(); dropped != 10 {
+		t.Errorf("unexpected dropped count: %v", dropped)
+	}
+	if unreported := dl.unreportedDrops(); unreported != 10 {
+		t.Errorf("unexpected unreported drops: %v", unreported)
+	}
+	dl.push([]byte{0}, func([]byte) error { return nil })
+	if unreported := dl.unreportedDrops(); unreported != 1 {
+		t.Errorf("unexpected unreported drops after report: %v", unreported)
+	}
+	if dropped := dl.droppedCount(); dropped != 11 {
+		t.Errorf("unexpected total dropped count: %v", dropped)
+	}
+}
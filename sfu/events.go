@@ -0,0 +1,59 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// sfu package exposed singleton
+	eventHubSingleton *eventHub
+)
+
+// Event is a notable server-side event (for instance an alert) pushed to stats listeners
+type Event struct {
+	Kind        string    `json:"kind"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Interaction string    `json:"interaction,omitempty"`
+	Value       any       `json:"value,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+type eventHub struct {
+	sync.Mutex
+	subscribers map[chan Event]bool
+}
+
+func init() {
+	eventHubSingleton = &eventHub{sync.Mutex{}, make(map[chan Event]bool)}
+}
+
+func (h *eventHub) publish(e Event) {
+	h.Lock()
+	defer h.Unlock()
+
+	e.At = time.Now()
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// slow subscriber, drop event
+		}
+	}
+}
+
+// API
+
+// SubscribeEvents returns a channel of events and a function to call to unsubscribe
+func SubscribeEvents() (<-chan Event, func()) {
+	ch := make(chan Event, 16)
+	eventHubSingleton.Lock()
+	eventHubSingleton.subscribers[ch] = true
+	eventHubSingleton.Unlock()
+
+	return ch, func() {
+		eventHubSingleton.Lock()
+		defer eventHubSingleton.Unlock()
+		delete(eventHubSingleton.subscribers, ch)
+	}
+}
@@ -0,0 +1,141 @@
+package sfu
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+// Impairments: network conditions (delay, jitter, loss, bandwidth cap) are simulated on media
+// forwarded from a participant, after processing so that recordings are not affected. They are
+// set at join (impairment in the join payload) and changed live with client_impairment messages
+
+const (
+	// ms
+	impairmentMaxDelay = 10000
+	// packets that would wait longer than this because of the bandwidth cap are dropped
+	impairmentMaxQueueDelay = time.Second
+)
+
+type impairmentPayload struct {
+	UserId string `json:"userId"`
+	types.Impairment
+}
+
+// shared by the audio and video slices of a peerServer, like a network link
+type impairer struct {
+	sync.Mutex
+	settings   types.Impairment
+	random     *rand.Rand
+	inBurst    bool      // Gilbert model state
+	linkFreeAt time.Time // bandwidth cap
+	dropped    int
+	scheduler  *packetScheduler
+}
+
+func sanitizeImpairment(s types.Impairment) types.Impairment {
+	s.Delay = min(max(s.Delay, 0), impairmentMaxDelay)
+	s.Jitter = min(max(s.Jitter, 0), impairmentMaxDelay)
+	s.Loss = min(max(s.Loss, 0), 1)
+	s.Burst = max(s.Burst, 1)
+	s.Bandwidth = max(s.Bandwidth, 0)
+	return s
+}
+
+func impaired(s types.Impairment) bool {
+	return s.Delay > 0 || s.Jitter > 0 || s.Loss > 0 || s.Bandwidth > 0
+}
+
+func newImpairer(settings *types.Impairment) *impairer {
+	im := &impairer{
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		scheduler: newPacketScheduler(),
+	}
+	if settings != nil {
+		im.settings = *settings
+	}
+	return im
+}
+
+// returns the count of packets dropped with the previous settings
+func (im *impairer) set(settings types.Impairment) (dropped int) {
+	im.Lock()
+	defer im.Unlock()
+
+	dropped = im.dropped
+	im.settings = settings
+	im.inBurst = false
+	im.dropped = 0
+	return
+}
+
+// nil if there is no impairment
+func (im *impairer) current() *types.Impairment {
+	im.Lock()
+	defer im.Unlock()
+
+	if !impaired(im.settings) {
+		return nil
+	}
+	settings := im.settings
+	return &settings
+}
+
+// Gilbert model: losses happen in bursts of Burst packets on average, with an overall Loss share
+func (im *impairer) lost() bool {
+	s := im.settings
+	if s.Loss <= 0 {
+		return false
+	}
+	if s.Burst <= 1 {
+		return im.random.Float64() < s.Loss
+	}
+	if im.inBurst {
+		im.inBurst = im.random.Float64() >= 1/s.Burst
+	} else {
+		im.inBurst = im.random.Float64() < s.Loss/(s.Burst*(1-s.Loss))
+	}
+	return im.inBurst
+}
+
+// delivers buf right away if there is no impairment (and then returns the delivery error),
+// otherwise queues a copy of buf or drops it
+func (im *impairer) push(buf []byte, deliver func([]byte) error) error {
+	im.Lock()
+	s := im.settings
+	if !impaired(s) && im.scheduler.idle() {
+		im.Unlock()
+		return deliver(buf)
+	}
+	defer im.Unlock()
+
+	if im.lost() {
+		im.dropped++
+		return nil
+	}
+	now := time.Now()
+	sendAt := now
+	if s.Bandwidth > 0 {
+		start := im.linkFreeAt
+		if start.Before(now) {
+			start = now
+		}
+		linkFreeAt := start.Add(time.Duration(len(buf)*8) * time.Second / time.Duration(s.Bandwidth))
+		if linkFreeAt.Sub(now) > impairmentMaxQueueDelay {
+			im.dropped++
+			return nil
+		}
+		im.linkFreeAt = linkFreeAt
+		sendAt = linkFreeAt
+	}
+	sendAt = sendAt.Add(time.Duration(s.Delay) * time.Millisecond)
+	if s.Jitter > 0 {
+		sendAt = sendAt.Add(time.Duration(im.random.Intn(s.Jitter+1)) * time.Millisecond)
+	}
+	if !im.scheduler.schedule(buf, sendAt, deliver) {
+		im.dropped++
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package sfu
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func TestImpairerDelay(t *testing.T) {
+	im := newImpairer(&types.Impairment{Delay: 50, Jitter: 20})
+	done := make(chan struct{})
+	defer close(done)
+	go im.scheduler.loop(done)
+
+	var mu sync.Mutex
+	var received []byte
+	var lastAt time.Time
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		im.push([]byte{byte(i)}, func(buf []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, buf[0])
+			lastAt = time.Now()
+			return nil
+		})
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 10 {
+		t.Fatalf("got %v packets", len(received))
+	}
+	for i, b := range received {
+		if int(b) != i {
+			t.Fatalf("packets reordered: %v", received)
+		}
+	}
+	if elapsed := lastAt.Sub(start); elapsed < 50*time.Millisecond {
+		t.Errorf("packets not delayed: %v", elapsed)
+	}
+}
+
+func TestImpairerPassThrough(t *testing.T) {
+	im := newImpairer(nil)
+	delivered := false
+	im.push([]byte{0}, func([]byte) error {
+		delivered = true
+		return nil
+	})
+	if !delivered {
+		t.Error("packet not delivered right away without impairment")
+	}
+}
+
+func TestImpairerBurstLoss(t *testing.T) {
+	im := newImpairer(&types.Impairment{Loss: 0.2, Burst: 4})
+	count, lost, bursts := 100000, 0, 0
+	previous := false
+	for i := 0; i < count; i++ {
+		l := im.lost()
+		if l {
+			lost++
+			if !previous {
+				bursts++
+			}
+		}
+		previous = l
+	}
+	if share := float64(lost) / float64(count); math.Abs(share-0.2) > 0.02 {
+		t.Errorf("got loss share %v", share)
+	}
+	if mean := float64(lost) / float64(bursts); math.Abs(mean-4) > 0.5 {
+		t.Errorf("got mean burst length %v", mean)
+	}
+}
+
+func TestImpairerBandwidth(t *testing.T) {
+	// 1000 bytes take 100ms at 80kbit/s, packets beyond 1s of queue are dropped
+	im := newImpairer(&types.Impairment{Bandwidth: 80000})
+	for i := 0; i < 15; i++ {
+		im.push(make([]byte, 1000), func([]byte) error { return nil })
+	}
+	if im.scheduler.pending != 10 || im.dropped != 5 {
+		t.Errorf("got %v queued and %v dropped packets", im.scheduler.pending, im.dropped)
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/datastore"
 	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/gst"
 	"github.com/ducksouplab/ducksoup/helpers"
 	extLogger "github.com/ducksouplab/ducksoup/logger"
 	"github.com/ducksouplab/ducksoup/store"
@@ -43,6 +46,19 @@ type interaction struct {
 	pipelineStartCount  int
 	inTracksReadyCount  int
 	outTracksReadyCount int
+	pipelines           []*gst.Pipeline
+	// manifest data
+	participants  []*types.ManifestParticipant
+	fxChanges     []types.ManifestFxChange
+	impairments   []types.ManifestImpairment
+	delays        []types.ManifestDelay
+	avOffsets     []types.ManifestAVOffset
+	interventions []types.ManifestVideoIntervention
+	speech        []types.ManifestSpeech
+	endCause      string
+	endedAt       time.Time
+	encryption    *types.ManifestEncryption
+	encrypted     map[string]string // recording file -> its encrypted version
 	// channels (safe)
 	readyCh   chan struct{}
 	startedCh chan struct{}
@@ -58,9 +74,12 @@ type interaction struct {
 	neededTracks int
 	ssrcs        []uint32
 	jp           types.JoinPayload
+	reactions    []*reaction // see reactions.go
 	dataFolder   string
 	// log
-	logger zerolog.Logger
+	logger  zerolog.Logger
+	logPath string
+	logFile *logFile // nil if the log file could not be created
 	// internals
 	abortTimer    *time.Timer
 	gracefulTimer *time.Timer
@@ -84,7 +103,9 @@ func (i *interaction) setLogger() {
 	fileWriter, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
 
 	if err == nil {
-		writers := zerolog.MultiLevelWriter(fileWriter, zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: env.TimeFormat})
+		i.logPath = path
+		i.logFile = &logFile{f: fileWriter}
+		writers := zerolog.MultiLevelWriter(i.logFile, zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: env.TimeFormat})
 		logger = zerolog.New(writers).With().Timestamp().Logger()
 	} else {
 		// use default logger
@@ -107,6 +128,48 @@ func (i *interaction) setLogger() {
 	extLogger.SetLogger(i.randomId, &logger)
 }
 
+// logFile lets the interaction log file be closed (before being uploaded or deleted)
+// while the logger is still in use: later writes are dropped from the file
+type logFile struct {
+	sync.Mutex
+	f *os.File
+}
+
+func (lf *logFile) Write(p []byte) (int, error) {
+	lf.Lock()
+	defer lf.Unlock()
+	if lf.f == nil {
+		return len(p), nil
+	}
+	return lf.f.Write(p)
+}
+
+func (lf *logFile) close() error {
+	lf.Lock()
+	defer lf.Unlock()
+	if lf.f == nil {
+		return nil
+	}
+	err := lf.f.Sync()
+	if closeErr := lf.f.Close(); err == nil {
+		err = closeErr
+	}
+	lf.f = nil
+	return err
+}
+
+// closeLogFile flushes and closes the interaction log file, subsequent logs only
+// go to stdout
+func (i *interaction) closeLogFile() {
+	if i.logFile == nil {
+		return
+	}
+	i.logger.Info().Str("context", "interaction").Msg("log_file_closed")
+	if err := i.logFile.close(); err != nil {
+		i.logger.Error().Str("context", "interaction").Err(err).Msg("log_file_close_failed")
+	}
+}
+
 func newInteraction(id string, jp types.JoinPayload) *interaction {
 	// process duration
 	durationInSeconds := jp.Duration
@@ -157,6 +220,7 @@ func newInteraction(id string, jp types.JoinPayload) *interaction {
 		neededTracks:        neededTracks,
 		ssrcs:               []uint32{},
 		jp:                  jp,
+		reactions:           newReactions(jp.Reactions),
 		dataFolder:          fmt.Sprintf("data/%v/%v", jp.Namespace, jp.InteractionName),
 		abortTimer:          time.NewTimer(time.Duration(AbortLimitInSeconds) * time.Second),
 	}
@@ -171,6 +235,7 @@ func newInteraction(id string, jp types.JoinPayload) *interaction {
 	}
 	i.mixer = newMixer(i)
 	i.setLogger()
+	i.unguardedRecordJoin(jp, "join")
 
 	i.logger.Info().Str("context", "interaction").Str("user", jp.UserId).Str("origin", jp.Origin).Msg("interaction_created")
 	i.logger.Info().Str("context", "interaction").Str("user", jp.UserId).Interface("payload", jp).Msg("peer_joined")
@@ -179,6 +244,15 @@ func newInteraction(id string, jp types.JoinPayload) *interaction {
 	return i
 }
 
+// Opus settings are chosen by the first participant for the whole interaction
+func (i *interaction) opusConfig() types.OpusConfig {
+	if i.jp.Opus != nil {
+		return *i.jp.Opus
+	}
+	opus, _ := config.OpusTemplate("")
+	return opus
+}
+
 func (i *interaction) DataFolder() string {
 	return i.dataFolder
 }
@@ -214,6 +288,8 @@ func (i *interaction) join(jp types.JoinPayload) (msg string, err error) {
 	defer i.Unlock()
 
 	userId := jp.UserId
+	opus := i.opusConfig()
+	jp.Opus = &opus
 	connected, ok := i.connectedIndex[userId]
 	if ok {
 		// ok -> same user has previously connected
@@ -224,6 +300,7 @@ func (i *interaction) join(jp types.JoinPayload) (msg string, err error) {
 			// reconnects (for instance: page reload)
 			i.connectedIndex[userId] = true
 			i.joinedCountIndex[userId]++
+			i.unguardedRecordJoin(jp, "reconnect")
 			return "reconnection", nil
 		}
 	} else if len(i.connectedIndex) == i.size { // length of users that have connected (even if aren't still)
@@ -233,6 +310,7 @@ func (i *interaction) join(jp types.JoinPayload) (msg string, err error) {
 		// new user joined existing interaction: normal path
 		i.connectedIndex[userId] = true
 		i.joinedCountIndex[userId] = 1
+		i.unguardedRecordJoin(jp, "join")
 		i.logger.Info().Str("context", "interaction").Str("user", userId).Interface("payload", jp).Msg("peer_joined")
 		return "existing-interaction", nil
 	}
@@ -281,11 +359,23 @@ func (i *interaction) start() {
 			go ps.ws.sendWithPayload("start", i.remainingSeconds())
 		}
 		go i.gracefulCountdown()
+		if i.jp.LatencyEqualization && i.size > 1 {
+			go i.loopLatencyEqualization()
+		}
 		close(i.startedCh)
 	}
 }
 
 func (i *interaction) stop(graceful bool) {
+	i.Lock()
+	i.endedAt = time.Now()
+	if graceful {
+		i.endCause = "interaction_ended"
+	} else {
+		i.endCause = "interaction_aborted"
+	}
+	i.Unlock()
+
 	// listened by peerServers, mixer, mixerTracks
 	if graceful {
 		close(i.doneCh)
@@ -420,9 +510,11 @@ func (i *interaction) unguardedDelete() {
 	for _, ssrc := range i.ssrcs {
 		store.RemoveFromSSRCIndex(ssrc)
 	}
+	// pipelines may still be writing recordings
+	go i.finalize()
 }
 
-func (i *interaction) disconnectUser(ps *peerServer) {
+func (i *interaction) disconnectUser(ps *peerServer, cause string) {
 	i.Lock()
 	defer i.Unlock()
 
@@ -439,6 +531,7 @@ func (i *interaction) disconnectUser(ps *peerServer) {
 		}
 		// mark disconnected, but keep track of her
 		i.connectedIndex[ps.userId] = false
+		i.unguardedRecordLeave(ps.userId, cause)
 
 		// prevent useless signaling when aborting/ending room
 		if i.deleted {
@@ -449,11 +542,20 @@ func (i *interaction) disconnectUser(ps *peerServer) {
 		// delete only if is empty and not running
 		if i.unguardedConnectedUserCount() == 0 && !i.ready && !i.deleted {
 			i.abortTimer.Stop()
+			i.endedAt = time.Now()
+			i.endCause = "users_left_before_start"
 			i.unguardedDelete()
 		}
 	}
 }
 
+func (i *interaction) addPipeline(p *gst.Pipeline) {
+	i.Lock()
+	defer i.Unlock()
+
+	i.pipelines = append(i.pipelines, p)
+}
+
 func (i *interaction) addFiles(userId string, files []string) {
 	i.Lock()
 	defer i.Unlock()
@@ -470,6 +572,17 @@ func (i *interaction) joinedCountForUser(userId string) int {
 	return i.joinedCountIndex[userId]
 }
 
+// control messages may target a participant with their pseudonym or their original userId
+func (i *interaction) resolveUserId(userId string) string {
+	i.RLock()
+	defer i.RUnlock()
+
+	if _, ok := i.peerServerIndex[userId]; ok || !datastore.PseudonymsEnabled() {
+		return userId
+	}
+	return datastore.Pseudonym(i.namespace, userId)
+}
+
 func (i *interaction) files() map[string][]string {
 	i.RLock()
 	defer i.RUnlock()
@@ -513,6 +626,12 @@ func (i *interaction) runMixerSliceFromRemote(
 	remoteTrack *webrtc.TrackRemote,
 	receiver *webrtc.RTPReceiver,
 ) {
+	// simulcast layers are all forwarded by the slice prepared for the first one
+	if ps.simulcast != nil && remoteTrack.RID() != "" {
+		if first := ps.simulcast.addLayer(remoteTrack); !first {
+			return
+		}
+	}
 	// signal new peer and tracks
 	i.incInTracksReadyCount(ps, remoteTrack)
 
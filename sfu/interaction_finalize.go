@@ -0,0 +1,194 @@
+package sfu
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/encryption"
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/storage"
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+const pipelinesDeletedTimeout = 15 * time.Second
+
+// finalize runs once the interaction has been deleted: it waits for pipelines
+// to complete their recordings, writes the manifest and hands data over to the
+// storage backend if any. Until it returns, the interaction is still reported as
+// running (see IsRunning). The log file is closed before being uploaded (or at
+// the end when there is no backend)
+func (i *interaction) finalize() {
+	defer interactionStoreSingleton.finalized(i)
+	defer i.closeLogFile()
+	i.waitForPipelines()
+	i.encryptRecordings()
+	manifest := i.manifest()
+
+	backend, err := storage.FromEnv()
+	if err != nil {
+		i.logger.Error().Str("context", "storage").Err(err).Msg("storage_misconfigured")
+	} else if backend != nil {
+		manifest.Storage = i.upload(backend)
+	}
+
+	if err := i.writeManifest(manifest); err == nil && backend != nil {
+		storage.Upload(backend, filepath.Join(i.dataFolder, manifestFile), i.objectKey(manifestFile), i.logger)
+	}
+}
+
+func (i *interaction) waitForPipelines() {
+	i.RLock()
+	pipelines := i.pipelines
+	i.RUnlock()
+
+	deadline := time.After(pipelinesDeletedTimeout)
+	for _, p := range pipelines {
+		select {
+		case <-p.Started():
+		default:
+			// never started, nothing will be recorded
+			continue
+		}
+		select {
+		case <-p.Deleted():
+		case <-deadline:
+			i.logger.Error().Str("context", "storage").Msg("pipelines_deletion_timeout")
+			return
+		}
+	}
+}
+
+// encryptRecordings replaces recordings with their encrypted version if the namespace has
+// an encryption key. Recordings that fail to be encrypted, or whose pipeline has not been
+// deleted yet, are kept as is (and reported)
+func (i *interaction) encryptRecordings() {
+	encodedKey, ok := config.Data.EncryptionKeys[i.namespace]
+	if !ok || len(encodedKey) == 0 {
+		return
+	}
+	key, err := encryption.ParsePublicKey(encodedKey)
+	if err != nil {
+		i.logger.Error().Str("context", "encryption").Err(err).Msg("encryption_key_invalid")
+		return
+	}
+
+	result := &types.ManifestEncryption{
+		Scheme:         encryption.Scheme,
+		KeyFingerprint: encryption.Fingerprint(key),
+	}
+	encrypted := make(map[string]string)
+	i.RLock()
+	pipelines := i.pipelines
+	i.RUnlock()
+	for _, p := range pipelines {
+		select {
+		case <-p.Started():
+		default:
+			continue
+		}
+		select {
+		case <-p.Deleted():
+		default:
+			// the pipeline may still be writing (see waitForPipelines timeout): removing the
+			// plaintext would lose the end of the recording, which is kept as is
+			for _, file := range p.RecordingFiles {
+				result.Failed = append(result.Failed, i.relativePath(file))
+				i.logger.Error().Str("context", "encryption").Str("file", file).Msg("recording_encryption_skipped")
+			}
+			continue
+		}
+		for _, file := range p.RecordingFiles {
+			if err := encryption.EncryptFile(file+encryption.Extension, file, key); err != nil {
+				result.Failed = append(result.Failed, i.relativePath(file))
+				i.logger.Error().Str("context", "encryption").Err(err).Str("file", file).Msg("recording_encryption_failed")
+				continue
+			}
+			if err := os.Remove(file); err != nil {
+				i.logger.Error().Str("context", "encryption").Err(err).Str("file", file).Msg("recording_plaintext_delete_failed")
+			}
+			encrypted[file] = file + encryption.Extension
+		}
+	}
+	i.logger.Info().Str("context", "encryption").Str("fingerprint", result.KeyFingerprint).Int("count", len(encrypted)).Msg("recordings_encrypted")
+
+	i.Lock()
+	defer i.Unlock()
+	i.encryption = result
+	i.encrypted = encrypted
+}
+
+func (i *interaction) objectKey(relPath string) string {
+	return storage.ObjectKey(i.namespace, i.name, relPath)
+}
+
+// files written by this interaction (other interactions may have used the same data folder),
+// logs coming last since the interaction log file is closed before being uploaded
+func (i *interaction) dataFiles() (relPaths []string) {
+	filepath.WalkDir(i.dataFolder, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(i.dataFolder, p)
+		if d.IsDir() {
+			if rel == "cache" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel == manifestFile {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !info.ModTime().Before(i.createdAt) {
+			relPaths = append(relPaths, rel)
+		}
+		return nil
+	})
+	sort.SliceStable(relPaths, func(a, b int) bool {
+		return !strings.HasSuffix(relPaths[a], ".log") && strings.HasSuffix(relPaths[b], ".log")
+	})
+	return
+}
+
+func (i *interaction) upload(backend storage.Backend) *types.ManifestStorage {
+	relPaths := i.dataFiles()
+	i.logger.Info().Str("context", "storage").Str("backend", backend.Name()).Int("count", len(relPaths)).Msg("storage_upload_started")
+
+	result := &types.ManifestStorage{
+		Backend:  backend.Name(),
+		Location: backend.Location(),
+		Status:   types.UploadVerified,
+	}
+	for _, rel := range relPaths {
+		if strings.HasSuffix(rel, ".log") {
+			// logs come last: stop writing to the file before it is uploaded (and maybe deleted)
+			i.closeLogFile()
+		}
+		object := storage.Upload(backend, filepath.Join(i.dataFolder, rel), i.objectKey(rel), i.logger)
+		object.Path = filepath.ToSlash(rel)
+		if object.Status != types.UploadVerified {
+			result.Status = types.UploadFailed
+		}
+		result.Objects = append(result.Objects, object)
+	}
+	i.logger.Info().Str("context", "storage").Str("status", result.Status).Int("count", len(result.Objects)).Msg("storage_upload_ended")
+
+	if env.StorageDeleteLocal {
+		result.LocalDeleted = true
+		for _, object := range result.Objects {
+			if object.Status != types.UploadVerified {
+				result.LocalDeleted = false
+				continue
+			}
+			if err := os.Remove(filepath.Join(i.dataFolder, object.Path)); err != nil {
+				result.LocalDeleted = false
+				i.logger.Error().Str("context", "storage").Err(err).Str("file", object.Path).Msg("storage_local_delete_failed")
+			}
+		}
+	}
+	return result
+}
@@ -14,6 +14,8 @@ var (
 type interactionStore struct {
 	sync.Mutex
 	index map[string]*interaction
+	// deleted interactions still writing to their data folder (see interaction.finalize)
+	finalizing map[*interaction]bool
 }
 
 func init() {
@@ -21,25 +23,48 @@ func init() {
 }
 
 func newInteractionStore() *interactionStore {
-	return &interactionStore{sync.Mutex{}, make(map[string]*interaction)}
+	return &interactionStore{sync.Mutex{}, make(map[string]*interaction), make(map[*interaction]bool)}
 }
 
 // last return value provides additional context
 func (is *interactionStore) join(jp types.JoinPayload) (*interaction, string, error) {
+	interactionId := generateId(jp)
+
+	if i, msg, err := is.joinExisting(interactionId, jp); i != nil {
+		return i, msg, err
+	}
+	// checking admission may walk the namespace folder, it's done without holding
+	// the store lock so that other joins and deletions are not blocked
+	admissionErr := checkAdmission(jp.Namespace, jp.InteractionName)
+
 	is.Lock()
 	defer is.Unlock()
 
-	interactionId := generateId(jp)
+	// the interaction may have been created meanwhile
+	if i, ok := is.index[interactionId]; ok {
+		msg, err := i.join(jp)
+		return i, msg, err
+	}
+	if admissionErr != nil {
+		return nil, "error", admissionErr
+	}
+	startDataMonitor()
+	// new user creates interaction
+	i := newInteraction(interactionId, jp)
+	is.index[interactionId] = i
+	return i, "new_interaction", nil
+}
+
+// returns a nil interaction if it does not exist
+func (is *interactionStore) joinExisting(interactionId string, jp types.JoinPayload) (*interaction, string, error) {
+	is.Lock()
+	defer is.Unlock()
 
-	if i, ok := interactionStoreSingleton.index[interactionId]; ok {
+	if i, ok := is.index[interactionId]; ok {
 		msg, err := i.join(jp)
 		return i, msg, err
-	} else {
-		// new user creates interaction
-		i := newInteraction(interactionId, jp)
-		interactionStoreSingleton.index[interactionId] = i
-		return i, "new_interaction", nil
 	}
+	return nil, "", nil
 }
 
 func (is *interactionStore) delete(i *interaction) {
@@ -47,4 +72,42 @@ func (is *interactionStore) delete(i *interaction) {
 	defer is.Unlock()
 
 	delete(is.index, i.id)
+	is.finalizing[i] = true
+}
+
+// to be called once the deleted interaction has been finalized
+func (is *interactionStore) finalized(i *interaction) {
+	is.Lock()
+	defer is.Unlock()
+
+	delete(is.finalizing, i)
+}
+
+// tells if an interaction, active or finalizing, is using the given data folder
+func (is *interactionStore) running(namespace, interactionName string) bool {
+	is.Lock()
+	defer is.Unlock()
+
+	for _, i := range is.index {
+		if i.namespace == namespace && i.name == interactionName {
+			return true
+		}
+	}
+	for i := range is.finalizing {
+		if i.namespace == namespace && i.name == interactionName {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot of current interactions
+func (is *interactionStore) all() (interactions []*interaction) {
+	is.Lock()
+	defer is.Unlock()
+
+	for _, i := range is.index {
+		interactions = append(interactions, i)
+	}
+	return
 }
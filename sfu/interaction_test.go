@@ -1,6 +1,8 @@
 package sfu
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -98,4 +100,64 @@ func TestJoinInteraction(t *testing.T) {
 		}
 	})
 
+	t.Run("Record participants in manifest", func(t *testing.T) {
+		joinPayload1 := newJoinPayload("https://origin", "interaction-manifest", "user-1", "interaction", 2)
+		joinPayload2 := newJoinPayload("https://origin", "interaction-manifest", "user-2", "interaction", 2)
+
+		i, _, _ := interactionStoreSingleton.join(joinPayload1)
+		interactionStoreSingleton.join(joinPayload2)
+
+		m := i.manifest()
+		if len(m.Participants) != 2 {
+			t.Fatal("manifest should contain 2 participants")
+		}
+		for _, p := range m.Participants {
+			if len(p.Joins) != 1 || len(p.Events) != 1 || p.Events[0].Kind != "join" {
+				t.Errorf("unexpected manifest participant: %+v", p)
+			}
+		}
+		if m.StartedAt != nil {
+			t.Error("interaction should not be marked as started")
+		}
+	})
+
+}
+
+func TestLogFileClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "interaction.log")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf := &logFile{f: f}
+	lf.Write([]byte("before\n"))
+	if err := lf.close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := lf.Write([]byte("after\n")); err != nil || n != 6 {
+		t.Errorf("write after close: got (%v, %v), want (6, nil)", n, err)
+	}
+	if err := lf.close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "before\n" {
+		t.Errorf("log file content: got %q", content)
+	}
+}
+
+func TestInteractionStoreRunning(t *testing.T) {
+	is := newInteractionStore()
+	i := &interaction{id: "origin#ns#name", namespace: "ns", name: "name"}
+	is.index[i.id] = i
+	if !is.running("ns", "name") || is.running("ns", "other") {
+		t.Fatal("active interaction: wrong running status")
+	}
+	is.delete(i)
+	if !is.running("ns", "name") {
+		t.Error("finalizing interaction should be running")
+	}
+	is.finalized(i)
+	if is.running("ns", "name") {
+		t.Error("finalized interaction should not be running")
+	}
 }
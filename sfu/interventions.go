@@ -0,0 +1,149 @@
+package sfu
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+// Video interventions: the video forwarded from a participant is frozen (last frame repeated),
+// blacked out or reduced to a given fps, for a duration or until the next intervention. They are
+// scheduled at join (videoInterventions in the join payload, relative to the interaction start) or
+// triggered with client_video_intervention messages. Applied before video encoders (see
+// gst.Pipeline.SetVideoIntervention), they are only available when video is encoded (with a video fx,
+// join payloads with interventions being rejected otherwise) and also end up in the wet recording
+
+var videoInterventionKinds = []string{"none", "freeze", "black", "fps"}
+
+type videoInterventionPayload struct {
+	UserId string `json:"userId"`
+	types.VideoIntervention
+}
+
+type interventionState struct {
+	sync.Mutex
+	current    string // kind, empty if none
+	generation int    // a restore only applies to the latest intervention
+}
+
+// invalid interventions are dropped
+func sanitizeVideoIntervention(iv types.VideoIntervention, framerate int) (types.VideoIntervention, bool) {
+	if !slices.Contains(videoInterventionKinds, iv.Kind) {
+		return iv, false
+	}
+	iv.At = max(iv.At, 0)
+	iv.Duration = max(iv.Duration, 0)
+	if iv.Kind == "fps" {
+		iv.Fps = min(max(iv.Fps, 1), framerate)
+	} else {
+		iv.Fps = 0
+	}
+	return iv, true
+}
+
+// returns the generation of the new intervention
+func (s *interventionState) set(kind string) int {
+	s.Lock()
+	defer s.Unlock()
+
+	s.generation++
+	if kind == "none" {
+		s.current = ""
+	} else {
+		s.current = kind
+	}
+	return s.generation
+}
+
+// ends the intervention of the given generation, false if it has been replaced since. restore
+// is called while locked so that a new intervention can't be overridden
+func (s *interventionState) end(generation int, restore func()) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if generation != s.generation {
+		return false
+	}
+	s.current = ""
+	restore()
+	return true
+}
+
+func (ps *peerServer) currentVideoIntervention() string {
+	ps.intervention.Lock()
+	defer ps.intervention.Unlock()
+
+	return ps.intervention.current
+}
+
+// empty for audio
+func (ms *mixerSlice) videoIntervention() string {
+	if ms.kind != "video" {
+		return ""
+	}
+	return ms.fromPs.currentVideoIntervention()
+}
+
+func (ps *peerServer) applyVideoIntervention(iv types.VideoIntervention, fromUserId string) {
+	if !ps.pipeline.VideoInterventions() {
+		ps.logError().Str("context", "track").Str("fromUser", fromUserId).Str("kind", iv.Kind).Msg("video_intervention_unavailable")
+		return
+	}
+	select {
+	case <-ps.isDone():
+		return
+	default:
+	}
+
+	generation := ps.intervention.set(iv.Kind)
+	ps.pipeline.SetVideoIntervention(iv.Kind, iv.Fps)
+	ps.logChange(fromUserId).
+		Str("kind", iv.Kind).
+		Int("duration", iv.Duration).
+		Int("fps", iv.Fps).
+		Msg("video_intervention_applied")
+	recordChange(ps.i, &ps.i.interventions, types.ManifestVideoIntervention{
+		ManifestChange: newChange(ps.userId, fromUserId),
+		Kind:           iv.Kind,
+		Duration:       iv.Duration,
+		Fps:            iv.Fps,
+	})
+
+	if iv.Kind == "none" || iv.Duration == 0 {
+		return
+	}
+	go func() {
+		select {
+		case <-ps.isDone():
+			return
+		case <-time.After(time.Duration(iv.Duration) * time.Millisecond):
+		}
+		ended := ps.intervention.end(generation, func() {
+			ps.pipeline.SetVideoIntervention("none", 0)
+		})
+		if !ended {
+			return
+		}
+		ps.logInfo().Str("context", "track").Str("kind", iv.Kind).Msg("video_intervention_ended")
+	}()
+}
+
+// join interventions are relative to the interaction start
+func (ps *peerServer) scheduleVideoInterventions() {
+	select {
+	case <-ps.isDone():
+		return
+	case <-ps.i.isStarted():
+	}
+	for _, iv := range ps.jp.VideoInterventions {
+		go func(iv types.VideoIntervention) {
+			select {
+			case <-ps.isDone():
+			case <-time.After(time.Duration(iv.At) * time.Millisecond):
+				ps.applyVideoIntervention(iv, ps.userId)
+			}
+		}(iv)
+	}
+}
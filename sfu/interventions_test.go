@@ -0,0 +1,76 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func TestSanitizeVideoIntervention(t *testing.T) {
+	if _, ok := sanitizeVideoIntervention(types.VideoIntervention{Kind: "blur"}, 30); ok {
+		t.Error("unknown kind accepted")
+	}
+	iv, ok := sanitizeVideoIntervention(types.VideoIntervention{Kind: "fps", At: -10, Fps: 60}, 30)
+	if !ok || iv.At != 0 || iv.Fps != 30 {
+		t.Errorf("unexpected fps intervention: %+v", iv)
+	}
+	iv, ok = sanitizeVideoIntervention(types.VideoIntervention{Kind: "freeze", Duration: 2000, Fps: 5}, 30)
+	if !ok || iv.Duration != 2000 || iv.Fps != 0 {
+		t.Errorf("unexpected freeze intervention: %+v", iv)
+	}
+}
+
+func TestInterventionStateRestore(t *testing.T) {
+	s := &interventionState{}
+	restores := 0
+	restore := func() { restores++ }
+
+	freeze := s.set("freeze")
+	if s.current != "freeze" {
+		t.Errorf("unexpected current intervention: %v", s.current)
+	}
+	// a new intervention replaces the previous one before it ends
+	black := s.set("black")
+	if s.end(freeze, restore) || s.current != "black" || restores != 0 {
+		t.Errorf("replaced intervention restored video: %v %v", s.current, restores)
+	}
+	if !s.end(black, restore) || s.current != "" || restores != 1 {
+		t.Errorf("latest intervention not restored: %v %v", s.current, restores)
+	}
+	// none restores video without a generation to end
+	fps := s.set("fps")
+	s.set("none")
+	if s.current != "" || s.end(fps, restore) || restores != 1 {
+		t.Errorf("unexpected state after none: %v %v", s.current, restores)
+	}
+}
+
+func TestParseVideoInterventions(t *testing.T) {
+	jp := types.JoinPayload{
+		RecordingMode:      "forced",
+		VideoFormat:        "VP8",
+		VideoFx:            "identity",
+		Framerate:          30,
+		VideoInterventions: []types.VideoIntervention{{Kind: "freeze", At: 1000}, {Kind: "blur"}},
+	}
+	interventions, err := parseVideoInterventions(jp)
+	if err != nil || len(interventions) != 1 {
+		t.Errorf("unexpected interventions: %v %+v", err, interventions)
+	}
+	for _, mode := range []string{"bypass", "rtpbin_only"} {
+		rejected := jp
+		rejected.RecordingMode = mode
+		if _, err := parseVideoInterventions(rejected); err == nil {
+			t.Errorf("interventions accepted in %v mode", mode)
+		}
+	}
+	rejected := jp
+	rejected.VideoFx = ""
+	if _, err := parseVideoInterventions(rejected); err == nil {
+		t.Error("interventions accepted without video fx")
+	}
+	jp.VideoInterventions = nil
+	if _, err := parseVideoInterventions(jp); err != nil {
+		t.Errorf("unexpected error without interventions: %v", err)
+	}
+}
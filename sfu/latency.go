@@ -0,0 +1,234 @@
+package sfu
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// Latency equalization (latencyEqualization in the join payload of the first participant): the path
+// of each participant is measured (RTT from RTCP receiver reports on media sent to them, jitter
+// buffer delay reported by their browser, latency of their pipeline) and compensating delays are
+// applied so that every participant pair has the same effective delay.
+//
+// The delay from A to B is up(A) + pipeline(A) + down(B) + jitterBuffer(B), one-way delays being
+// approximated by RTT/2. Since media forwarded from A is shared by all receivers, the sender side
+// is compensated in the delay line of A (see delay.go) and the receiver side with a jitter buffer
+// target sent to the browser of B. Receivers whose browser does not report the jitter buffer delay
+// it would choose without a target are left out of receiver side equalization
+
+const (
+	latencyEqualizationPeriod = time.Second
+	// changes smaller than this (ms) are not applied
+	latencyEqualizationThreshold = 10
+	// compensating delays change 10 times slower than real time, see delay.go
+	latencyEqualizationRampFactor = 10
+	// ms, browsers cap jitter buffer targets
+	jitterBufferTargetMax = 4000
+	// smoothing of RTT measures
+	rttWeight = 0.2
+)
+
+type pathMeasure struct {
+	rtt                 int // ms
+	jitterBuffer        int // ms
+	pipeline            int // ms
+	unknownJitterBuffer bool
+}
+
+type latencyCompensation struct {
+	senderDelay        int // ms
+	jitterBufferTarget int // ms
+}
+
+// per peerServer
+type latencyState struct {
+	sync.Mutex
+	rtt          float64 // ms, smoothed
+	jitterBuffer int     // ms, as reported by the browser (without target)
+	reported     bool    // the browser has reported its jitter buffer
+	applied      latencyCompensation
+}
+
+// middle 32 bits of the NTP timestamp, as in LSR
+func ntpMiddle(t time.Time) uint32 {
+	// NTP epoch is 1900
+	seconds := uint64(t.Unix()) + 2208988800
+	fraction := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	return uint32(seconds<<16 | fraction>>16)
+}
+
+// RFC 3550 section 6.4.1
+func rttFromReport(now time.Time, r rtcp.ReceptionReport) (time.Duration, bool) {
+	if r.LastSenderReport == 0 {
+		return 0, false
+	}
+	rtt := ntpMiddle(now) - r.LastSenderReport - r.Delay
+	// negative (clock or report glitch)
+	if rtt > math.MaxInt32 {
+		return 0, false
+	}
+	return time.Duration(rtt) * time.Second / 65536, true
+}
+
+func (l *latencyState) updateRTT(rtt time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	ms := float64(rtt) / float64(time.Millisecond)
+	if l.rtt == 0 {
+		l.rtt = ms
+	} else {
+		l.rtt = (1-rttWeight)*l.rtt + rttWeight*ms
+	}
+}
+
+func (l *latencyState) updateJitterBuffer(value string) {
+	if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+		l.Lock()
+		defer l.Unlock()
+
+		l.jitterBuffer = ms
+		l.reported = true
+	}
+}
+
+// false if the RTT is not known yet
+func (l *latencyState) measure(pipeline int) (pathMeasure, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	return pathMeasure{
+		rtt:                 int(l.rtt),
+		jitterBuffer:        l.jitterBuffer,
+		pipeline:            max(pipeline, 0),
+		unknownJitterBuffer: !l.reported,
+	}, l.rtt > 0
+}
+
+// compensations (per user) so that every sender side (up + pipeline) and every receiver side
+// (down + jitter buffer) match the slowest ones. Receivers with an unknown jitter buffer get no
+// target (0)
+func equalizeLatencies(measures map[string]pathMeasure) map[string]latencyCompensation {
+	maxSender, maxReceiver := 0, 0
+	for _, m := range measures {
+		maxSender = max(maxSender, m.rtt/2+m.pipeline)
+		if !m.unknownJitterBuffer {
+			maxReceiver = max(maxReceiver, m.rtt/2+m.jitterBuffer)
+		}
+	}
+	compensations := make(map[string]latencyCompensation)
+	for userId, m := range measures {
+		c := latencyCompensation{senderDelay: min(maxSender-(m.rtt/2+m.pipeline), delayMax)}
+		if !m.unknownJitterBuffer {
+			// the jitter buffer target includes its natural delay
+			c.jitterBufferTarget = min(maxReceiver-m.rtt/2, jitterBufferTargetMax)
+		}
+		compensations[userId] = c
+	}
+	return compensations
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (ps *peerServer) applyLatencyCompensation(m pathMeasure, c latencyCompensation) {
+	ps.latency.Lock()
+	applied := ps.latency.applied
+	ps.latency.Unlock()
+
+	ps.logDebug().
+		Str("context", "peer").
+		Int("rtt", m.rtt).
+		Int("jitterBuffer", m.jitterBuffer).
+		Int("pipeline", m.pipeline).
+		Str("unit", "ms").
+		Msg("latency_measured")
+
+	senderChange := absInt(c.senderDelay-applied.senderDelay) >= latencyEqualizationThreshold
+	receiverChange := absInt(c.jitterBufferTarget-applied.jitterBufferTarget) >= latencyEqualizationThreshold
+	if !senderChange && !receiverChange {
+		return
+	}
+	if senderChange {
+		ramp := absInt(c.senderDelay-applied.senderDelay) * latencyEqualizationRampFactor
+		ps.delayLine.compensate(c.senderDelay, ramp)
+		applied.senderDelay = c.senderDelay
+	}
+	if receiverChange {
+		ps.ws.sendWithPayload("latency_compensation", struct {
+			JitterBufferTarget int `json:"jitterBufferTarget"`
+		}{c.jitterBufferTarget})
+		applied.jitterBufferTarget = c.jitterBufferTarget
+	}
+	ps.latency.Lock()
+	ps.latency.applied = applied
+	ps.latency.Unlock()
+
+	ps.logInfo().
+		Str("context", "peer").
+		Int("rtt", m.rtt).
+		Int("jitterBuffer", m.jitterBuffer).
+		Int("pipeline", m.pipeline).
+		Int("senderDelay", applied.senderDelay).
+		Int("jitterBufferTarget", applied.jitterBufferTarget).
+		Str("unit", "ms").
+		Msg("latency_equalized")
+}
+
+func (i *interaction) peerServer(userId string) (ps *peerServer, ok bool) {
+	i.RLock()
+	defer i.RUnlock()
+
+	ps, ok = i.peerServerIndex[userId]
+	return
+}
+
+func (i *interaction) loopLatencyEqualization() {
+	ticker := time.NewTicker(latencyEqualizationPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-i.doneCh:
+			return
+		case <-i.abortedCh:
+			return
+		case <-ticker.C:
+			i.RLock()
+			peerServers := make([]*peerServer, 0, len(i.peerServerIndex))
+			for _, ps := range i.peerServerIndex {
+				peerServers = append(peerServers, ps)
+			}
+			i.RUnlock()
+			if len(peerServers) < 2 {
+				continue
+			}
+
+			measures := make(map[string]pathMeasure)
+			complete := true
+			for _, ps := range peerServers {
+				m, ok := ps.latency.measure(ps.pipeline.Latency())
+				if !ok {
+					complete = false
+					break
+				}
+				measures[ps.userId] = m
+			}
+			if !complete {
+				continue
+			}
+			compensations := equalizeLatencies(measures)
+			for _, ps := range peerServers {
+				ps.applyLatencyCompensation(measures[ps.userId], compensations[ps.userId])
+			}
+		}
+	}
+}
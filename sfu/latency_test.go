@@ -0,0 +1,73 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+func TestRTTFromReport(t *testing.T) {
+	sentAt := time.Now()
+	// report sent back 30ms after the sender report, received 80ms after it
+	report := rtcp.ReceptionReport{
+		LastSenderReport: ntpMiddle(sentAt),
+		Delay:            uint32(30 * 65536 / 1000),
+	}
+	rtt, ok := rttFromReport(sentAt.Add(80*time.Millisecond), report)
+	if !ok || rtt < 49*time.Millisecond || rtt > 51*time.Millisecond {
+		t.Errorf("got RTT %v (%v)", rtt, ok)
+	}
+	if _, ok := rttFromReport(sentAt, rtcp.ReceptionReport{}); ok {
+		t.Error("RTT computed without sender report")
+	}
+}
+
+func TestEqualizeLatencies(t *testing.T) {
+	measures := map[string]pathMeasure{
+		"a": {rtt: 20, jitterBuffer: 40, pipeline: 30},
+		"b": {rtt: 200, jitterBuffer: 60, pipeline: 30},
+		"c": {rtt: 100, jitterBuffer: 150, pipeline: 10},
+	}
+	compensations := equalizeLatencies(measures)
+
+	// pair delay: sender side (rtt/2 + pipeline + delay) + receiver side (rtt/2 + jitter buffer target)
+	expected := -1
+	for from, mf := range measures {
+		for to, mt := range measures {
+			if from == to {
+				continue
+			}
+			jitterBuffer := max(mt.jitterBuffer, compensations[to].jitterBufferTarget)
+			delay := mf.rtt/2 + mf.pipeline + compensations[from].senderDelay + mt.rtt/2 + jitterBuffer
+			if expected == -1 {
+				expected = delay
+			} else if delay != expected {
+				t.Errorf("delay from %v to %v: got %v, expected %v", from, to, delay, expected)
+			}
+		}
+	}
+	if compensations["b"].senderDelay != 0 {
+		t.Errorf("slowest sender delayed by %v", compensations["b"].senderDelay)
+	}
+}
+
+func TestEqualizeLatenciesUnknownJitterBuffer(t *testing.T) {
+	measures := map[string]pathMeasure{
+		"a": {rtt: 20, jitterBuffer: 40, pipeline: 30},
+		"b": {rtt: 100, jitterBuffer: 60, pipeline: 30},
+		// would otherwise be the slowest receiver
+		"c": {rtt: 100, jitterBuffer: 500, pipeline: 30, unknownJitterBuffer: true},
+	}
+	compensations := equalizeLatencies(measures)
+	if target := compensations["c"].jitterBufferTarget; target != 0 {
+		t.Errorf("target set with an unknown jitter buffer: %v", target)
+	}
+	// slowest known receiver side: b with 50 + 60
+	if target := compensations["a"].jitterBufferTarget; target != 100 {
+		t.Errorf("unexpected target: %v", target)
+	}
+	if delay := compensations["a"].senderDelay; delay != 40 {
+		t.Errorf("unexpected sender delay: %v", delay)
+	}
+}
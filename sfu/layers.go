@@ -0,0 +1,243 @@
+package sfu
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Layers are alternative encodings of the same video (simulcast layers sent by the browser or
+// tiers encoded by the pipeline). Receivers are forwarded one layer each, switching on keyframes
+
+// a higher layer is selected if the estimate exceeds its bitrate by this factor
+const layerUpgradeHeadroom = 1.2
+
+var errLayerUnbound = errors.New("layered track not bound to ssrc")
+
+type layeredBinding struct {
+	id          string
+	ssrc        webrtc.SSRC
+	payloadType webrtc.PayloadType
+	writer      webrtc.TrackLocalWriter
+}
+
+// layeredTrack is a webrtc.TrackLocal that writes packets to a given binding, since
+// receivers may be forwarded different layers
+type layeredTrack struct {
+	mu       sync.RWMutex
+	id       string
+	streamID string
+	codec    webrtc.RTPCodecCapability
+	bindings []layeredBinding
+}
+
+func newLayeredTrack(codec webrtc.RTPCodecCapability, id, streamID string) *layeredTrack {
+	return &layeredTrack{id: id, streamID: streamID, codec: codec}
+}
+
+func (t *layeredTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	var selected *webrtc.RTPCodecParameters
+	for _, c := range ctx.CodecParameters() {
+		c := c
+		if !strings.EqualFold(c.MimeType, t.codec.MimeType) {
+			continue
+		}
+		// exact fmtp match prevails (H264 profiles)
+		if selected == nil || c.SDPFmtpLine == t.codec.SDPFmtpLine {
+			selected = &c
+		}
+	}
+	if selected == nil {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bindings = append(t.bindings, layeredBinding{
+		id:          ctx.ID(),
+		ssrc:        ctx.SSRC(),
+		payloadType: selected.PayloadType,
+		writer:      ctx.WriteStream(),
+	})
+	return *selected, nil
+}
+
+func (t *layeredTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.bindings {
+		if t.bindings[i].id == ctx.ID() {
+			t.bindings = append(t.bindings[:i], t.bindings[i+1:]...)
+			return nil
+		}
+	}
+	return webrtc.ErrUnbindFailed
+}
+
+func (t *layeredTrack) ID() string {
+	return t.id
+}
+
+func (t *layeredTrack) RID() string {
+	return ""
+}
+
+func (t *layeredTrack) StreamID() string {
+	return t.streamID
+}
+
+func (t *layeredTrack) Kind() webrtc.RTPCodecType {
+	return webrtc.RTPCodecTypeVideo
+}
+
+// writes to all bindings (outputTrack interface)
+func (t *layeredTrack) Write(buf []byte) (n int, err error) {
+	packet := &rtp.Packet{}
+	if err = packet.Unmarshal(buf); err != nil {
+		return 0, err
+	}
+
+	t.mu.RLock()
+	bindings := append([]layeredBinding(nil), t.bindings...)
+	t.mu.RUnlock()
+
+	var errs []error
+	for _, b := range bindings {
+		if _, err := t.writeTo(b.ssrc, packet.Header, packet.Payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(buf), errors.Join(errs...)
+}
+
+func (t *layeredTrack) writeTo(ssrc webrtc.SSRC, header rtp.Header, payload []byte) (int, error) {
+	t.mu.RLock()
+	var binding *layeredBinding
+	for i := range t.bindings {
+		if t.bindings[i].ssrc == ssrc {
+			binding = &t.bindings[i]
+			break
+		}
+	}
+	t.mu.RUnlock()
+	if binding == nil {
+		return 0, errLayerUnbound
+	}
+
+	header.SSRC = uint32(ssrc)
+	header.PayloadType = uint8(binding.payloadType)
+	return binding.writer.WriteRTP(&header, payload)
+}
+
+// state of the layer forwarded to one receiver, sequence numbers and timestamps being rewritten
+// so that the receiver sees a continuous stream
+type layerReceiver struct {
+	toUserId  string
+	current   string // id of the forwarded layer, empty until the first keyframe
+	target    string
+	forwarded bool
+	seqOffset uint16
+	tsOffset  uint32
+	lastSeq   uint16
+	lastTs    uint32
+	lastWrite time.Time
+}
+
+// returns the header to forward, false if the packet is dropped for this receiver
+func (r *layerReceiver) rewrite(id string, header rtp.Header, keyframe bool, now time.Time, clockRate uint32) (rtp.Header, bool) {
+	if id != r.current {
+		// switch on a keyframe of the target layer, or of any layer to start with
+		if !keyframe || (r.current != "" && id != r.target) {
+			return header, false
+		}
+		if r.forwarded {
+			r.seqOffset = r.lastSeq + 1 - header.SequenceNumber
+			elapsed := uint32(now.Sub(r.lastWrite).Seconds() * float64(clockRate))
+			if elapsed == 0 {
+				elapsed = 1
+			}
+			r.tsOffset = r.lastTs + elapsed - header.Timestamp
+		}
+		r.current = id
+		if r.target == "" {
+			r.target = id
+		}
+	}
+
+	header.SequenceNumber += r.seqOffset
+	header.Timestamp += r.tsOffset
+	// don't go back in time with reordered packets
+	if diff := header.SequenceNumber - r.lastSeq; !r.forwarded || (diff != 0 && diff < 0x8000) {
+		r.forwarded = true
+		r.lastSeq = header.SequenceNumber
+		r.lastTs = header.Timestamp
+		r.lastWrite = now
+	}
+	return header, true
+}
+
+type layerOption struct {
+	id      string
+	bitrate int
+}
+
+// options are ordered from low to high quality, the lowest one being selected if none fits
+func selectLayer(options []layerOption, current string, estimate int) string {
+	if len(options) == 0 {
+		return current
+	}
+	currentIndex := -1
+	for i, o := range options {
+		if o.id == current {
+			currentIndex = i
+		}
+	}
+	selected := options[0].id
+	for i, o := range options {
+		required := float64(o.bitrate)
+		if i > currentIndex {
+			required *= layerUpgradeHeadroom
+		}
+		if float64(estimate) >= required {
+			selected = o.id
+		}
+	}
+	return selected
+}
+
+type layerWrite struct {
+	ssrc   webrtc.SSRC
+	header rtp.Header
+}
+
+// should be called by a method that locked the receivers owner (mutex)
+func rewriteForReceivers(receivers map[webrtc.SSRC]*layerReceiver, id string, header rtp.Header, keyframe bool, now time.Time, clockRate uint32) (writes []layerWrite) {
+	for ssrc, r := range receivers {
+		if h, ok := r.rewrite(id, header, keyframe, now, clockRate); ok {
+			writes = append(writes, layerWrite{ssrc, h})
+		}
+	}
+	return
+}
+
+// receivers that are not bound anymore (reconnection) are skipped
+func (ms *mixerSlice) writeLayers(output *layeredTrack, writes []layerWrite, payload []byte) error {
+	var errs []error
+	for _, w := range writes {
+		if _, err := output.writeTo(w.ssrc, w.header, payload); err != nil {
+			if !errors.Is(err, errLayerUnbound) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		ms.Lock()
+		ms.outputBits += len(payload) * 8
+		ms.Unlock()
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,223 @@
+package sfu
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/storage"
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+const manifestFile = "manifest.json"
+
+// should be called by another method that locked the interaction (mutex)
+func (i *interaction) unguardedParticipant(userId string) *types.ManifestParticipant {
+	for _, p := range i.participants {
+		if p.UserId == userId {
+			return p
+		}
+	}
+	p := &types.ManifestParticipant{UserId: userId}
+	i.participants = append(i.participants, p)
+	return p
+}
+
+// should be called by another method that locked the interaction (mutex)
+func (i *interaction) unguardedRecordJoin(jp types.JoinPayload, kind string) {
+	p := i.unguardedParticipant(jp.UserId)
+	p.Joins = append(p.Joins, jp)
+	p.Events = append(p.Events, types.ManifestEvent{Kind: kind, At: time.Now()})
+}
+
+// should be called by another method that locked the interaction (mutex)
+func (i *interaction) unguardedRecordLeave(userId, cause string) {
+	p := i.unguardedParticipant(userId)
+	p.Events = append(p.Events, types.ManifestEvent{Kind: "leave", At: time.Now(), Cause: cause})
+}
+
+// change of the pipeline (or forwarded media) of userId requested by fromUserId, dated now
+func newChange(userId, fromUserId string) types.ManifestChange {
+	return types.ManifestChange{At: time.Now(), UserId: userId, FromUserId: fromUserId}
+}
+
+// appends a change to one of the interaction lists listed in the manifest (fxChanges, delays...)
+func recordChange[T any](i *interaction, list *[]T, change T) {
+	i.Lock()
+	defer i.Unlock()
+
+	*list = append(*list, change)
+}
+
+func (i *interaction) relativePath(path string) string {
+	if rel, err := filepath.Rel(i.dataFolder, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// recording file of the pipeline at index pipeline in the manifest, to be hashed
+type pendingRecording struct {
+	pipeline int
+	userId   string
+	file     string
+}
+
+// to be called once pipelines are done, since it computes checksums of recordings
+func (i *interaction) manifest() types.Manifest {
+	m, pending := i.manifestSnapshot()
+
+	// hashing recordings may take a while, it's done without holding the interaction lock
+	for _, r := range pending {
+		sum, err := storage.ComputeChecksum(r.file)
+		if err != nil {
+			i.logger.Error().Str("context", "interaction").Err(err).Str("file", r.file).Msg("recording_checksum_failed")
+			continue
+		}
+		path := i.relativePath(r.file)
+		m.Pipelines[r.pipeline].Recordings = append(m.Pipelines[r.pipeline].Recordings, path)
+		m.Recordings = append(m.Recordings, types.ManifestFile{
+			Path:   path,
+			UserId: r.userId,
+			Size:   sum.Size,
+			SHA256: hex.EncodeToString(sum.SHA256),
+		})
+	}
+
+	recorded := make(map[string]bool)
+	for _, file := range m.Recordings {
+		recorded[file.UserId] = true
+	}
+	started := make(map[string]bool)
+	for _, mp := range m.Pipelines {
+		if mp.Started {
+			started[mp.UserId] = true
+		}
+	}
+	for _, p := range m.Participants {
+		p.Recorded = recorded[p.UserId]
+		if !p.Recorded {
+			p.NotRecordedCause = notRecordedCause(p, started[p.UserId])
+			m.NotRecorded = append(m.NotRecorded, p.UserId)
+		}
+	}
+	return m
+}
+
+// tells why a participant with no recording has not been recorded, the participant
+// refusing consent on any of its connections taking precedence
+func notRecordedCause(p *types.ManifestParticipant, started bool) string {
+	modeNone := len(p.Joins) > 0
+	for _, jp := range p.Joins {
+		if jp.NoRecording {
+			return types.NotRecordedNoConsent
+		}
+		if jp.RecordingMode != "none" {
+			modeNone = false
+		}
+	}
+	if env.NoRecording {
+		return types.NotRecordedDisabled
+	}
+	if modeNone {
+		return types.NotRecordedModeNone
+	}
+	if !started {
+		return types.NotRecordedPipelineNotStarted
+	}
+	return types.NotRecordedNoFile
+}
+
+// copies the manifest data under lock, along with the recordings still to be hashed
+func (i *interaction) manifestSnapshot() (m types.Manifest, pending []pendingRecording) {
+	i.RLock()
+	defer i.RUnlock()
+
+	m = types.Manifest{
+		DuckSoupVersion: config.BackendVersion,
+		Namespace:       i.namespace,
+		Interaction:     i.name,
+		RandomId:        i.randomId,
+		Origin:          i.jp.Origin,
+		Size:            i.size,
+		Duration:        int(i.duration.Seconds()),
+		CreatedAt:       i.createdAt,
+		EndedAt:         i.endedAt,
+		EndCause:        i.endCause,
+		Participants:    []*types.ManifestParticipant{},
+		NotRecorded:     []string{},
+		Pipelines:       []types.ManifestPipeline{},
+		Recordings:      []types.ManifestFile{},
+		FxChanges:       append([]types.ManifestFxChange{}, i.fxChanges...),
+		Impairments:     append([]types.ManifestImpairment{}, i.impairments...),
+		Delays:          append([]types.ManifestDelay{}, i.delays...),
+		AVOffsets:       append([]types.ManifestAVOffset{}, i.avOffsets...),
+		Interventions:   append([]types.ManifestVideoIntervention{}, i.interventions...),
+		Speech:          []types.ManifestSpeech{},
+		Encryption:      i.encryption,
+	}
+	if i.started {
+		startedAt := i.startedAt
+		m.StartedAt = &startedAt
+	}
+	if m.EndedAt.IsZero() {
+		m.EndedAt = time.Now()
+	}
+	// segments still open when the interaction ended
+	for _, s := range i.speech {
+		if s.End.IsZero() {
+			s.End = m.EndedAt
+		}
+		m.Speech = append(m.Speech, s)
+	}
+	m.TurnTaking = types.TurnTaking(m.Speech)
+
+	for index, p := range i.pipelines {
+		mp := types.ManifestPipeline{
+			Id:              p.ID(),
+			UserId:          p.UserId(),
+			ConnectionCount: p.ConnectionCount(),
+			Template:        p.Template,
+			Recordings:      []string{},
+		}
+		if len(p.DescriptionFile) > 0 {
+			mp.Description = i.relativePath(p.DescriptionFile)
+		}
+		select {
+		case <-p.Started():
+			mp.Started = true
+		default:
+		}
+		if mp.Started {
+			for _, file := range p.RecordingFiles {
+				if encrypted, ok := i.encrypted[file]; ok {
+					file = encrypted
+				}
+				pending = append(pending, pendingRecording{pipeline: index, userId: p.UserId(), file: file})
+			}
+		}
+		m.Pipelines = append(m.Pipelines, mp)
+	}
+	for _, p := range i.participants {
+		participant := *p
+		m.Participants = append(m.Participants, &participant)
+	}
+	return
+}
+
+func (i *interaction) writeManifest(manifest types.Manifest) error {
+	contents, err := json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(i.dataFolder, manifestFile), contents, 0666)
+	}
+	if err != nil {
+		i.logger.Error().Str("context", "interaction").Err(err).Msg("manifest_write_failed")
+		return err
+	}
+	i.logger.Info().Str("context", "interaction").Msg("manifest_written")
+	return nil
+}
@@ -0,0 +1,36 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func TestNotRecordedCause(t *testing.T) {
+	participant := func(joins ...types.JoinPayload) *types.ManifestParticipant {
+		return &types.ManifestParticipant{UserId: "user-1", Joins: joins}
+	}
+	cases := []struct {
+		name     string
+		p        *types.ManifestParticipant
+		disabled bool
+		started  bool
+		expected string
+	}{
+		{"consent refused on a reconnection", participant(types.JoinPayload{}, types.JoinPayload{NoRecording: true}), false, true, types.NotRecordedNoConsent},
+		{"consent takes precedence", participant(types.JoinPayload{NoRecording: true}), true, false, types.NotRecordedNoConsent},
+		{"recording disabled", participant(types.JoinPayload{}), true, true, types.NotRecordedDisabled},
+		{"recording mode none", participant(types.JoinPayload{RecordingMode: "none"}), false, true, types.NotRecordedModeNone},
+		{"pipeline not started", participant(types.JoinPayload{}), false, false, types.NotRecordedPipelineNotStarted},
+		{"no join", participant(), false, false, types.NotRecordedPipelineNotStarted},
+		{"no file", participant(types.JoinPayload{RecordingMode: "none"}, types.JoinPayload{}), false, true, types.NotRecordedNoFile},
+	}
+	defer func(noRecording bool) { env.NoRecording = noRecording }(env.NoRecording)
+	for _, c := range cases {
+		env.NoRecording = c.disabled
+		if got := notRecordedCause(c.p, c.started); got != c.expected {
+			t.Errorf("%v: got %q, want %q", c.name, got, c.expected)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/engine"
 	"github.com/ducksouplab/ducksoup/env"
 	"github.com/ducksouplab/ducksoup/gst"
 	"github.com/ducksouplab/ducksoup/plot"
@@ -30,6 +31,12 @@ const (
 
 var plotBuffersRecordingModes = []string{"forced", "free", "reenc"}
 
+// webrtc.TrackLocalStaticRTP, redTrack or layeredTrack
+type outputTrack interface {
+	webrtc.TrackLocal
+	Write(buf []byte) (int, error)
+}
+
 type mixerSlice struct {
 	sync.Mutex
 	fromPs       *peerServer
@@ -38,14 +45,22 @@ type mixerSlice struct {
 	streamConfig config.SFUStream
 	// webrtc
 	input    *webrtc.TrackRemote
-	output   *webrtc.TrackLocalStaticRTP
+	output   outputTrack
 	receiver *webrtc.RTPReceiver
+	// nil if the remote track is not simulcast
+	simulcast *simulcastForwarder
+	// nil if the pipeline has no video tiers
+	tiers *tierForwarder
 	// processing
 	pipeline          *gst.Pipeline
 	interpolatorIndex map[string]*sequencing.LinearInterpolator
 	// controller
 	senderControllerIndex map[string]*senderController // per user id
+	aggregator            BitrateAggregator
 	targetBitrate         int
+	adaptationLevel       int // 0 if video is not adapted (see adaptation.go)
+	// nil for video
+	meter *audioMeter
 	// plots
 	plotBuffers bool
 	// stats
@@ -87,7 +102,13 @@ func newMixerSlice(ps *peerServer, remoteTrack *webrtc.TrackRemote, receiver *we
 	if kind == "video" {
 		streamConfig = config.SFU.Video
 	} else if kind == "audio" {
-		streamConfig = config.SFU.Audio
+		// bounds depend on the interaction Opus settings
+		opus := ps.i.opusConfig()
+		streamConfig = config.SFUStream{
+			DefaultBitrate: opus.DefaultBitrate,
+			MinBitrate:     opus.MinBitrate,
+			MaxBitrate:     opus.MaxBitrate,
+		}
 	} else {
 		err := errors.New("invalid kind")
 		ms.logError().Str("context", "track").Err(err).Msg("new_mixer_slice_failed")
@@ -95,11 +116,24 @@ func newMixerSlice(ps *peerServer, remoteTrack *webrtc.TrackRemote, receiver *we
 	}
 
 	newId := remoteTrack.ID()
-	localTrack, err := webrtc.NewTrackLocalStaticRTP(remoteTrack.Codec().RTPCodecCapability, newId, ps.streamId)
-
-	if err != nil {
-		ms.logError().Str("context", "track").Err(err).Msg("new_mixer_slice_failed")
-		return
+	var localTrack outputTrack
+	var simulcast *simulcastForwarder
+	var tiers *tierForwarder
+	if kind == "audio" && ps.i.opusConfig().RED {
+		localTrack = newRedTrack(newId, ps.streamId)
+	} else if kind == "video" && ps.simulcast != nil && remoteTrack.RID() != "" {
+		localTrack = newLayeredTrack(remoteTrack.Codec().RTPCodecCapability, newId, ps.streamId)
+		simulcast = ps.simulcast
+	} else if bitrates := ps.pipeline.VideoTiers(); kind == "video" && bitrates != nil {
+		track := newLayeredTrack(remoteTrack.Codec().RTPCodecCapability, newId, ps.streamId)
+		localTrack = track
+		tiers = newTierForwarder(bitrates, track, remoteTrack.Codec())
+	} else {
+		localTrack, err = webrtc.NewTrackLocalStaticRTP(remoteTrack.Codec().RTPCodecCapability, newId, ps.streamId)
+		if err != nil {
+			ms.logError().Str("context", "track").Err(err).Msg("new_mixer_slice_failed")
+			return
+		}
 	}
 
 	ms = &mixerSlice{
@@ -108,14 +142,17 @@ func newMixerSlice(ps *peerServer, remoteTrack *webrtc.TrackRemote, receiver *we
 		kind:         kind,
 		streamConfig: streamConfig,
 		// webrtc
-		input:    remoteTrack,
-		output:   localTrack,
-		receiver: receiver, // TODO read RTCP?
+		input:     remoteTrack,
+		output:    localTrack,
+		receiver:  receiver, // TODO read RTCP?
+		simulcast: simulcast,
+		tiers:     tiers,
 		// processing
 		pipeline:          ps.pipeline,
 		interpolatorIndex: make(map[string]*sequencing.LinearInterpolator),
 		// controller
 		senderControllerIndex: map[string]*senderController{},
+		aggregator:            newBitrateAggregator(ps.i.jp),
 		// plots
 		plotBuffers: slices.Contains(plotBuffersRecordingModes, ps.i.jp.RecordingMode),
 		// stats
@@ -123,6 +160,9 @@ func newMixerSlice(ps *peerServer, remoteTrack *webrtc.TrackRemote, receiver *we
 		// status
 		doneCh: make(chan struct{}),
 	}
+	if kind == "audio" {
+		ms.meter = newAudioMeter()
+	}
 	// analysis
 	if env.GeneratePlots {
 		ms.plot = plot.NewSlicePlot(ms, kind, ms.plotBuffers, ps.userId, ps.i.DataFolder()+"/plots")
@@ -165,6 +205,12 @@ func (ms *mixerSlice) addSender(pc *peerConn, sender *webrtc.RTPSender) {
 		ms.Lock()
 		ms.senderControllerIndex[toUserId] = sc
 		ms.Unlock()
+		if ms.simulcast != nil {
+			ms.simulcast.addReceiver(toUserId, sc.ssrc)
+		}
+		if ms.tiers != nil {
+			ms.tiers.addReceiver(toUserId, sc.ssrc)
+		}
 		go sc.loop()
 	} else {
 		ms.logError().Str("toUser", toUserId).Str("cause", "wrong number of encoding parameters").Msg("add_sender_failed")
@@ -184,7 +230,16 @@ func (l *mixerSlice) updateInputBits(n int) {
 	l.Unlock()
 }
 
-func (ms *mixerSlice) Write(buf []byte) (err error) {
+// written by the pipeline (or the bypass loop), possibly delayed and impaired before being forwarded
+func (ms *mixerSlice) Write(buf []byte) error {
+	return ms.fromPs.forward(ms.kind, buf, ms.write)
+}
+
+func (ms *mixerSlice) write(buf []byte) (err error) {
+	if ms.tiers != nil {
+		// main encoder output
+		return ms.tiers.write(ms, ms.tiers.main(), buf)
+	}
 	n, err := ms.output.Write(buf)
 
 	if err == nil {
@@ -226,6 +281,9 @@ func (ms *mixerSlice) loop() {
 		}
 	}
 	go ms.loopStats()
+	if ms.meter != nil {
+		go ms.loopSendAudioLevels()
+	}
 	if env.GeneratePlots {
 		go ms.plot.Loop()
 	}
@@ -233,7 +291,10 @@ func (ms *mixerSlice) loop() {
 	// main loop start
 	buf := make([]byte, config.SFU.Common.MTU)
 
-	if ms.fromPs.jp.RecordingMode == "bypass" {
+	if ms.simulcast != nil {
+		// blocking, layers are forwarded depending on receivers
+		ms.simulcast.run(ms)
+	} else if ms.fromPs.jp.RecordingMode == "bypass" {
 	bypass:
 		for {
 			select {
@@ -286,6 +347,9 @@ func (ms *mixerSlice) updateTargetBitrates(targetBitrate int) {
 	ms.targetBitrate = targetBitrate
 	ms.Unlock()
 	ms.pipeline.SetEncodingBitrate(ms.kind, targetBitrate)
+	if ms.kind == "video" && ms.pipeline.AdaptiveVideo() {
+		ms.adapt(targetBitrate)
+	}
 	// format and log
 	msg := fmt.Sprintf("%s_target_bitrate_updated", ms.kind)
 	ms.logInfo().Int("value", targetBitrate/1000).Str("unit", "kbit/s").Msg(msg)
@@ -342,18 +406,20 @@ func (ms *mixerSlice) loopEncoderController() {
 		case <-ms.Done():
 			return
 		case <-encoderTicker.C:
-			if len(ms.senderControllerIndex) > 0 {
-				rates := []int{}
-				for _, sc := range ms.senderControllerIndex {
+			if ms.tiers != nil {
+				ms.updateTiers()
+			} else if len(ms.senderControllerIndex) > 0 {
+				estimates := map[string]int{}
+				for toUserId, sc := range ms.senderControllerIndex {
 					if ms.kind == "video" {
-						rates = append(rates, sc.optimalRate())
+						estimates[toUserId] = sc.optimalRate()
 					}
 				}
 				// DISABLED no need to encode more than inputToOutputMaxFactor times the inputBitrate
 				// inputDependentRate := int(inputToOutputMaxFactor * (float64(ms.inputBitrate)))
 				// rates = append(rates, inputDependentRate)
 				// END DISABLED
-				newPotentialRate := minInt(rates)
+				newPotentialRate := ms.aggregator.Aggregate(estimates)
 
 				if ms.pipeline != nil && newPotentialRate > 0 {
 					ms.updateTargetBitrates(newPotentialRate)
@@ -399,6 +465,61 @@ func (ms *mixerSlice) loopBufferPlots() {
 	}
 }
 
+// video only, nil if no stream has been bound
+func (ms *mixerSlice) retransmissionStats() map[string]engine.RetransmissionStats {
+	ms.Lock()
+	defer ms.Unlock()
+
+	var report map[string]engine.RetransmissionStats
+	for toUserId, sc := range ms.senderControllerIndex {
+		if stats, ok := engine.RetransmissionStatsFor(uint32(sc.ssrc)); ok {
+			if report == nil {
+				report = make(map[string]engine.RetransmissionStats)
+			}
+			report[toUserId] = stats
+		}
+	}
+	return report
+}
+
+// optimal rates of receivers, indexed by sender SSRC
+func (ms *mixerSlice) senderEstimates() map[webrtc.SSRC]int {
+	ms.Lock()
+	defer ms.Unlock()
+
+	estimates := make(map[webrtc.SSRC]int)
+	for _, sc := range ms.senderControllerIndex {
+		estimates[sc.ssrc] = sc.optimalRate()
+	}
+	return estimates
+}
+
+// video only, kbit/s per receiving user, nil if no stream has been bound
+func (ms *mixerSlice) receiverEstimates() map[string]int {
+	if ms.kind != "video" {
+		return nil
+	}
+	ms.Lock()
+	defer ms.Unlock()
+
+	var report map[string]int
+	for toUserId, sc := range ms.senderControllerIndex {
+		if report == nil {
+			report = make(map[string]int)
+		}
+		report[toUserId] = sc.optimalRate() / 1000
+	}
+	return report
+}
+
+// nil if not simulcast
+func (ms *mixerSlice) simulcastLayers() map[string]string {
+	if ms.simulcast == nil {
+		return nil
+	}
+	return ms.simulcast.forwardedLayers()
+}
+
 func (ms *mixerSlice) loopStats() {
 	statsTicker := time.NewTicker(statsPeriod * time.Millisecond)
 	defer statsTicker.Stop()
@@ -436,8 +557,17 @@ func (ms *mixerSlice) loopStats() {
 			outputMsg := fmt.Sprintf("%s_out_bitrate", ms.output.Kind().String())
 			ms.Unlock()
 
+			if ms.simulcast != nil {
+				ms.simulcast.selectLayers(ms, sinceLastTick)
+			}
+			ms.fromPs.logDelayDrops(ms.kind)
 			ms.logDebug().Uint64("value", displayInputBitrateKbs).Str("unit", "kbit/s").Msg(inputMsg)
 			ms.logDebug().Uint64("value", displayOutputBitrateKbs).Uint64("target", displayOutputTargetBitrateKbs).Str("unit", "kbit/s").Msg(outputMsg)
+			for toUserId, stats := range ms.retransmissionStats() {
+				if stats.Nacked > 0 {
+					ms.logDebug().Str("toUser", toUserId).Uint64("nacked", stats.Nacked).Uint64("retransmitted", stats.Retransmitted).Uint64("missed", stats.Missed).Msg("video_out_retransmissions")
+				}
+			}
 		}
 	}
 }
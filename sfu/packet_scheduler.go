@@ -0,0 +1,79 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+// packets held back before being forwarded (see impairment.go and delay.go) are delivered
+// in order, at their scheduled time
+
+const packetSchedulerSize = 4096
+
+type scheduledPacket struct {
+	buf     []byte
+	sendAt  time.Time
+	deliver func([]byte) error
+}
+
+type packetScheduler struct {
+	sync.Mutex
+	pending    int
+	lastSendAt time.Time // packets are not reordered
+	queue      chan scheduledPacket
+}
+
+func newPacketScheduler() *packetScheduler {
+	return &packetScheduler{queue: make(chan scheduledPacket, packetSchedulerSize)}
+}
+
+// no packet is waiting
+func (s *packetScheduler) idle() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.pending == 0
+}
+
+// queues a copy of buf, returns false if the queue is full
+func (s *packetScheduler) schedule(buf []byte, sendAt time.Time, deliver func([]byte) error) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if sendAt.Before(s.lastSendAt) {
+		sendAt = s.lastSendAt
+	}
+	select {
+	case s.queue <- scheduledPacket{append([]byte(nil), buf...), sendAt, deliver}:
+		s.lastSendAt = sendAt
+		s.pending++
+		return true
+	default:
+		return false
+	}
+}
+
+// delivers queued packets until done, delivery errors are ignored
+func (s *packetScheduler) loop(done chan struct{}) {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case p := <-s.queue:
+			if wait := time.Until(p.sendAt); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-done:
+					return
+				case <-timer.C:
+				}
+			}
+			p.deliver(p.buf)
+			s.Lock()
+			s.pending--
+			s.Unlock()
+		}
+	}
+}
@@ -55,7 +55,9 @@ func (pc *peerConn) PLIRequest(cause string) {
 func newPionPeerConn(i *interaction) (ppc *webrtc.PeerConnection, ccEstimator cc.BandwidthEstimator, err error) {
 	// create RTC API
 	estimatorCh := make(chan cc.BandwidthEstimator, 1)
-	api, err := engine.NewWebRTCAPI(estimatorCh, i.logger)
+	// browsers don't send REMB if TWCC is negotiated
+	twcc := i.jp.BandwidthController != "remb"
+	api, err := engine.NewWebRTCAPI(i.opusConfig(), twcc, estimatorCh, i.logger)
 	if err != nil {
 		return
 	}
@@ -70,13 +72,21 @@ func newPionPeerConn(i *interaction) (ppc *webrtc.PeerConnection, ccEstimator cc
 
 func (pc *peerConn) prepareInTracks(jp types.JoinPayload) (err error) {
 	// accept one audio
-	_, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{
+	audioTransceiver, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{
 		Direction: webrtc.RTPTransceiverDirectionRecvonly,
 	})
 	if err != nil {
 		pc.logError().Str("context", "track").Err(err).Msg("add_audio_transceiver_failed")
 		return
 	}
+	// RED is only sent by the server, pipelines expecting Opus
+	if opus := pc.i.opusConfig(); opus.RED {
+		err = audioTransceiver.SetCodecPreferences(engine.OpusCodecs(opus))
+		if err != nil {
+			pc.logError().Str("context", "track").Err(err).Msg("set_codec_preferences_failed")
+			return
+		}
+	}
 
 	// accept one video
 	videoTransceiver, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
@@ -87,9 +97,22 @@ func (pc *peerConn) prepareInTracks(jp types.JoinPayload) (err error) {
 		return
 	}
 
-	// force codec preference if H264 (so VP8 won't prevail)
-	if jp.VideoFormat == "H264" {
-		err = videoTransceiver.SetCodecPreferences(engine.H264Codecs)
+	// restrict the offer to the interaction video format, so that browsers don't pick another
+	// registered one
+	var preferences []webrtc.RTPCodecParameters
+	switch jp.VideoFormat {
+	case "VP8":
+		preferences = engine.VP8Codecs
+	case "H264":
+		preferences = engine.H264Codecs
+	case "VP9":
+		// profile 0, the only one registered (and expected by GStreamer pipelines)
+		preferences = engine.VP9Codecs
+	case "AV1":
+		preferences = engine.AV1Codecs
+	}
+	if preferences != nil {
+		err = videoTransceiver.SetCodecPreferences(preferences)
 		if err != nil {
 			pc.logError().Str("context", "track").Err(err).Msg("set_codec_preferences_failed")
 			return
@@ -217,9 +240,12 @@ func (pc *peerConn) managedPLIRequest(cause string) {
 	pc.Lock()
 	defer pc.Unlock()
 
+	// several tracks per receiver if simulcast
 	for _, receiver := range pc.GetReceivers() {
-		track := receiver.Track()
-		if track != nil && track.Kind().String() == "video" {
+		for _, track := range receiver.Tracks() {
+			if track.Kind().String() != "video" {
+				continue
+			}
 			go pc.sendPLIRequest(track, cause)
 			// // throttling currently disabled
 			// durationSinceLastPLI := time.Since(pc.lastPLI)
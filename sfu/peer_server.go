@@ -36,6 +36,14 @@ type peerServer struct {
 	ws              *wsConn
 	audioSlice      *mixerSlice
 	videoSlice      *mixerSlice
+	simulcast       *simulcastForwarder // nil if simulcast is not enabled
+	audioOffset     *delayLine          // see av_offset.go
+	videoOffset     *delayLine
+	delayLine       *delayLine
+	latency         *latencyState
+	intervention    *interventionState
+	vad             *voiceDetector // nil if disabled
+	impairer        *impairer
 	closed          bool
 	doneCh          chan struct{}
 	// processing
@@ -49,8 +57,10 @@ func newPeerServer(
 	pc *peerConn,
 	ws *wsConn) *peerServer {
 
-	pipeline := gst.NewPipeline(jp, pc, i.DataFolder(), i.randomId, i.joinedCountForUser(jp.UserId), i.logger)
+	pipeline := gst.NewPipeline(jp, pc, i.DataFolder(), i.randomId, i.joinedCountForUser(jp.UserId), i.videoTierBitrates(jp), i.videoAdaptive(), i.logger)
+	i.addPipeline(pipeline)
 
+	audioOffset, videoOffset := avOffsetDelays(jp.AVOffset)
 	ps := &peerServer{
 		userId:            jp.UserId,
 		interactionName:   i.name,
@@ -63,6 +73,32 @@ func newPeerServer(
 		doneCh:            make(chan struct{}),
 		pipeline:          pipeline,
 		interpolatorIndex: make(map[string]*sequencing.LinearInterpolator),
+		audioOffset:       newDelayLine(audioOffset),
+		videoOffset:       newDelayLine(videoOffset),
+		delayLine:         newDelayLine(jp.Delay),
+		latency:           &latencyState{},
+		intervention:      &interventionState{},
+		vad:               newVoiceDetector(),
+		impairer:          newImpairer(jp.Impairment),
+	}
+	if jp.Simulcast {
+		ps.simulcast = newSimulcastForwarder()
+	}
+	go ps.audioOffset.scheduler.loop(ps.doneCh)
+	go ps.videoOffset.scheduler.loop(ps.doneCh)
+	go ps.delayLine.scheduler.loop(ps.doneCh)
+	go ps.impairer.scheduler.loop(ps.doneCh)
+	if len(jp.VideoInterventions) > 0 {
+		go ps.scheduleVideoInterventions()
+	}
+	if jp.AVOffset != 0 {
+		ps.logAVOffset(jp.AVOffset, 0, ps.userId, 0)
+	}
+	if jp.Delay > 0 {
+		ps.logDelay(jp.Delay, 0, ps.userId, 0)
+	}
+	if jp.Impairment != nil {
+		ps.logImpairment(*jp.Impairment, ps.userId, 0)
 	}
 
 	// connect for further communication
@@ -218,6 +254,11 @@ func (ps *peerServer) shareOffer(cause string, iceRestart bool) bool {
 		offer = *ps.pc.LocalDescription()
 	}
 
+	// only the offer sent to the browser is changed, see addSimulcastRecv
+	if ps.simulcast != nil {
+		offer.SDP = addSimulcastRecv(offer.SDP)
+	}
+
 	offerString, err := json.Marshal(offer)
 	if err != nil {
 		ps.logError().Str("context", "signaling").Str("user", userId).Err(err).Msg("marshal_offer_failed")
@@ -254,11 +295,86 @@ func (ps *peerServer) close(cause string) {
 		// clean up bound components
 		go ps.pc.Close() // TODO fix/check -> may block
 		ps.ws.Close()
+		ps.stopSpeechDetection()
 
 		ps.logInfo().Str("context", "peer").Str("cause", cause).Msg("peer_server_ended")
 	}
 	// cleanup anyway
-	ps.i.disconnectUser(ps)
+	ps.i.disconnectUser(ps, cause)
+}
+
+// peer server of the participant targeted by a control message (userId possibly being their
+// original id), ps itself if userId is empty or unknown
+func (ps *peerServer) resolveTarget(userId string) *peerServer {
+	if targetPs, ok := ps.i.peerServer(ps.i.resolveUserId(userId)); ok {
+		return targetPs
+	}
+	return ps
+}
+
+// returns false (and logs) if the payload of the control message m can't be unmarshaled
+func (ps *peerServer) unmarshalControl(m messageIn, payload any) bool {
+	if err := json.Unmarshal([]byte(m.Payload), payload); err != nil {
+		ps.logError().Str("context", "peer").Err(err).Msg("unmarshal_" + m.Kind + "_failed")
+		return false
+	}
+	return true
+}
+
+// log event of a change of ps requested by fromUserId (see also recordChange)
+func (ps *peerServer) logChange(fromUserId string) *zerolog.Event {
+	return ps.logInfo().Str("context", "track").Str("fromUser", fromUserId)
+}
+
+func (ps *peerServer) logImpairment(impairment types.Impairment, fromUserId string, dropped int) {
+	ps.logChange(fromUserId).
+		Int("delay", impairment.Delay).
+		Int("jitter", impairment.Jitter).
+		Float64("loss", impairment.Loss).
+		Float64("burst", impairment.Burst).
+		Int("bandwidth", impairment.Bandwidth).
+		Int("previouslyDropped", dropped).
+		Msg("impairment_applied")
+	recordChange(ps.i, &ps.i.impairments, types.ManifestImpairment{
+		ManifestChange: newChange(ps.userId, fromUserId),
+		Impairment:     impairment,
+	})
+}
+
+func (ps *peerServer) controlImpairment(payload impairmentPayload, fromUserId string) {
+	impairment := sanitizeImpairment(payload.Impairment)
+	dropped := ps.impairer.set(impairment)
+	ps.logImpairment(impairment, fromUserId, dropped)
+}
+
+// media forwarded from ps goes through the A/V offset delay line of its kind, the shared
+// delay line, then the impairer
+func (ps *peerServer) forward(kind string, buf []byte, deliver func([]byte) error) error {
+	return ps.offsetLine(kind).push(buf, func(buf []byte) error {
+		return ps.delayLine.push(buf, func(buf []byte) error {
+			return ps.impairer.push(buf, deliver)
+		})
+	})
+}
+
+func (ps *peerServer) logDelay(delay, ramp int, fromUserId string, previous int) {
+	ps.logChange(fromUserId).
+		Int("delay", delay).
+		Int("ramp", ramp).
+		Int("previousDelay", previous).
+		Msg("delay_applied")
+	recordChange(ps.i, &ps.i.delays, types.ManifestDelay{
+		ManifestChange: newChange(ps.userId, fromUserId),
+		Delay:          delay,
+		Ramp:           ramp,
+	})
+}
+
+func (ps *peerServer) controlDelay(payload delayPayload, fromUserId string) {
+	delay := sanitizeDelay(payload.Delay)
+	ramp := max(payload.Ramp, 0)
+	previous := ps.delayLine.set(delay, ramp)
+	ps.logDelay(delay, ramp, fromUserId, previous)
 }
 
 func (ps *peerServer) controlFx(payload controlPayload) {
@@ -270,6 +386,14 @@ func (ps *peerServer) controlFx(payload controlPayload) {
 		Float32("value", payload.Value).
 		Int("duration", payload.Duration).
 		Msg("client_fx_control")
+	recordChange(ps.i, &ps.i.fxChanges, types.ManifestFxChange{
+		ManifestChange: newChange(ps.userId, payload.fromUserId),
+		Name:           payload.Name,
+		Property:       payload.Property,
+		Kind:           "float",
+		Value:          strconv.FormatFloat(float64(payload.Value), 'f', -1, 32),
+		Duration:       payload.Duration,
+	})
 
 	interpolatorId := payload.Name + payload.Property
 	ps.Lock()
@@ -389,23 +513,40 @@ func (ps *peerServer) loop() {
 			ps.shareOffer(m.Kind, true)
 		case "client_selected_candidate_pair":
 			ps.logDebug().Str("context", "signaling").Str("source", "client").Str("value", m.Payload).Msg(m.Kind)
+		// control messages target another participant of the interaction (userId in the payload)
+		// or self by default
 		case "client_control":
 			payload := controlPayload{}
-			if err := json.Unmarshal([]byte(m.Payload), &payload); err != nil {
-				ps.logError().Str("context", "peer").Err(err).Msg("unmarshal_client_control_failed")
-			} else {
+			if ps.unmarshalControl(m, &payload) {
 				payload.fromUserId = ps.userId
-				if targetPs, ok := ps.i.peerServerIndex[payload.UserId]; ok { // control other ps in same interaction
-					go targetPs.controlFx(payload)
-				} else { // default case: control self ps
-					go ps.controlFx(payload)
+				go ps.resolveTarget(payload.UserId).controlFx(payload)
+			}
+		case "client_impairment":
+			payload := impairmentPayload{}
+			if ps.unmarshalControl(m, &payload) {
+				go ps.resolveTarget(payload.UserId).controlImpairment(payload, ps.userId)
+			}
+		case "client_delay":
+			payload := delayPayload{}
+			if ps.unmarshalControl(m, &payload) {
+				go ps.resolveTarget(payload.UserId).controlDelay(payload, ps.userId)
+			}
+		case "client_av_offset":
+			payload := avOffsetPayload{}
+			if ps.unmarshalControl(m, &payload) {
+				go ps.resolveTarget(payload.UserId).controlAVOffset(payload, ps.userId)
+			}
+		case "client_video_intervention":
+			payload := videoInterventionPayload{}
+			if ps.unmarshalControl(m, &payload) {
+				targetPs := ps.resolveTarget(payload.UserId)
+				if intervention, ok := sanitizeVideoIntervention(payload.VideoIntervention, targetPs.jp.Framerate); ok {
+					go targetPs.applyVideoIntervention(intervention, ps.userId)
 				}
 			}
 		case "client_polycontrol":
 			payload := polyControlPayload{}
-			if err := json.Unmarshal([]byte(m.Payload), &payload); err != nil {
-				ps.logError().Str("context", "peer").Err(err).Msg("unmarshal_client_polycontrol_failed")
-			} else {
+			if ps.unmarshalControl(m, &payload) {
 				go func() {
 					ps.pipeline.SetFxPolyProp(payload.Name, payload.Property, payload.Kind, payload.Value)
 					ps.logInfo().
@@ -415,6 +556,13 @@ func (ps *peerServer) loop() {
 						Str("kind", payload.Kind).
 						Str("value", payload.Value).
 						Msg("client_fx_control")
+					recordChange(ps.i, &ps.i.fxChanges, types.ManifestFxChange{
+						ManifestChange: newChange(ps.userId, ps.userId),
+						Name:           payload.Name,
+						Property:       payload.Property,
+						Kind:           payload.Kind,
+						Value:          payload.Value,
+					})
 				}()
 			}
 		case "client_video_resolution_updated":
@@ -432,6 +580,9 @@ func (ps *peerServer) loop() {
 			if env.GeneratePlots {
 				ps.videoSlice.plot.AddKeyFrame()
 			}
+		case "client_jitter_buffer_updated":
+			ps.logDebug().Str("context", "peer").Str("source", "client").Str("value", m.Payload).Str("unit", "ms").Msg(m.Kind)
+			ps.latency.updateJitterBuffer(m.Payload)
 		case "stop":
 			ps.close("client_stop_request")
 		default:
@@ -494,14 +645,18 @@ func RunPeerServer(origin string, unsafeConn ws.IGorilla) {
 			log.Error().Str("context", "signaling").Err(err).Str("namespace", namespace).Str("interaction", interactionName).Str("user", userId).Msg("join_failed")
 			return
 		}
+		opus := i.opusConfig()
+		joinPayload.Opus = &opus
 		uniqueUserId := i.id + "#" + userId
 		iceServers := iceservers.GetICEServers(uniqueUserId)
 		ws.sendWithPayload("joined", struct {
-			Context    string             `json:"context"`
-			IceServers []webrtc.ICEServer `json:"iceServers"`
+			Context             string             `json:"context"`
+			IceServers          []webrtc.ICEServer `json:"iceServers"`
+			LatencyEqualization bool               `json:"latencyEqualization,omitempty"`
 		}{
 			msg,
 			iceServers,
+			i.jp.LatencyEqualization && i.size > 1,
 		})
 
 		pc, err := newPeerConn(joinPayload, i)
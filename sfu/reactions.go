@@ -0,0 +1,171 @@
+package sfu
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+// Reactions: rules declared by the first participant (reactions in the join payload) changing an
+// fx property in the pipeline of a participant when the audio of another one meets a condition, for
+// instance lowering the pitch of A by 5% over 1s when B speaks above -30 dBFS for 500ms. Levels are
+// measured by the pipeline of the trigger participant (see audio_level.go), so that fx change
+// without client round-trips. A reaction fires once its condition has been met for its duration and
+// may only fire again once the condition has not been met for the same duration (when the change is
+// also reverted if required). Values (and factors) are relative to the fx value read the first time
+// the reaction fires. Reactions are only active once the interaction has started; every trigger is
+// logged and fx changes are listed in the interaction manifest
+
+var reactionFeatures = []string{"rms", "peak"}
+
+type reaction struct {
+	sync.Mutex
+	name     string
+	rule     types.Reaction
+	fired    bool
+	crossing time.Time // first level on the other side of the condition, zero if none
+	hasBase  bool
+	base     float32 // fx value before the first firing, used to scale and revert
+}
+
+// invalid reactions are dropped
+func sanitizeReaction(r types.Reaction) (types.Reaction, bool) {
+	if len(r.Trigger.Feature) == 0 {
+		r.Trigger.Feature = "rms"
+	}
+	if !slices.Contains(reactionFeatures, r.Trigger.Feature) {
+		return r, false
+	}
+	r.Trigger.UserId = ParseString(r.Trigger.UserId)
+	r.Action.UserId = ParseString(r.Action.UserId)
+	if len(r.Trigger.UserId) == 0 || len(r.Action.UserId) == 0 || len(r.Action.Name) == 0 || len(r.Action.Property) == 0 {
+		return r, false
+	}
+	r.Trigger.Duration = max(r.Trigger.Duration, 0)
+	r.Action.Duration = min(max(r.Action.Duration, 0), maxInterpolatorDuration)
+	return r, true
+}
+
+func newReactions(rules []types.Reaction) (reactions []*reaction) {
+	for index, rule := range rules {
+		name := rule.Name
+		if len(name) == 0 {
+			name = "reaction_" + strconv.Itoa(index)
+		}
+		reactions = append(reactions, &reaction{name: name, rule: rule})
+	}
+	return
+}
+
+// fire is true when the reaction is triggered, revert when its change has to be reverted
+func (r *reaction) update(at time.Time, level float64) (fire, revert bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	met := level >= r.rule.Trigger.Threshold
+	if r.rule.Trigger.Below {
+		met = level < r.rule.Trigger.Threshold
+	}
+	if met == r.fired {
+		r.crossing = time.Time{}
+		return
+	}
+	if r.crossing.IsZero() {
+		r.crossing = at
+	}
+	if at.Sub(r.crossing) < time.Duration(r.rule.Trigger.Duration)*time.Millisecond {
+		return
+	}
+	r.fired = met
+	r.crossing = time.Time{}
+	return met, !met && r.rule.Action.Revert
+}
+
+// read is only called the first time, so that a reaction firing during an interpolation (or
+// repeatedly with a factor) doesn't drift from the original value
+func (r *reaction) getBase(read func() float32) float32 {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.hasBase {
+		r.base = read()
+		r.hasBase = true
+	}
+	return r.base
+}
+
+// called with the dry levels of fromUserId, from the pipeline bus: fx changes (reading and setting
+// properties of another pipeline) are done in their own goroutine
+func (i *interaction) react(fromUserId string, at time.Time, rms, peak float64) {
+	select {
+	case <-i.isStarted():
+	default:
+		return
+	}
+	for _, r := range i.reactions {
+		if fromUserId != r.rule.Trigger.UserId {
+			continue
+		}
+		level := rms
+		if r.rule.Trigger.Feature == "peak" {
+			level = peak
+		}
+		if fire, revert := r.update(at, level); fire || revert {
+			go i.applyReaction(r, fromUserId, level, revert)
+		}
+	}
+}
+
+func (i *interaction) applyReaction(r *reaction, fromUserId string, level float64, revert bool) {
+	action := r.rule.Action
+	targetPs, ok := i.peerServer(action.UserId)
+	if ok {
+		// the fx may be missing (or lack the property) in the target pipeline
+		ok = targetPs.pipeline.HasFxPropFloat(action.Name, action.Property)
+	}
+	if !ok {
+		i.logger.Error().
+			Str("context", "interaction").
+			Str("user", fromUserId).
+			Str("reaction", r.name).
+			Str("name", action.Name).
+			Str("property", action.Property).
+			Msg("reaction_target_missing")
+		return
+	}
+
+	message := "reaction_triggered"
+	base := r.getBase(func() float32 {
+		return targetPs.pipeline.GetFxPropFloat(action.Name, action.Property)
+	})
+	value := action.Value
+	if revert {
+		message = "reaction_reverted"
+		value = base
+	} else if action.Factor != 0 {
+		value = base * action.Factor
+	}
+	i.logger.Info().
+		Str("context", "interaction").
+		Str("user", fromUserId).
+		Str("toUser", targetPs.userId).
+		Str("reaction", r.name).
+		Str("feature", r.rule.Trigger.Feature).
+		Float64("level", level).
+		Float64("threshold", r.rule.Trigger.Threshold).
+		Str("name", action.Name).
+		Str("property", action.Property).
+		Float32("value", value).
+		Int("duration", action.Duration).
+		Msg(message)
+	go targetPs.controlFx(controlPayload{
+		Name:       action.Name,
+		Property:   action.Property,
+		Value:      value,
+		Duration:   action.Duration,
+		fromUserId: fromUserId,
+	})
+}
@@ -0,0 +1,92 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func TestSanitizeReaction(t *testing.T) {
+	valid := types.Reaction{
+		Trigger: types.ReactionTrigger{UserId: "b", Threshold: -30, Duration: 500},
+		Action:  types.ReactionAction{UserId: "a", Name: "pitch", Property: "pitch", Factor: 0.95, Duration: 10000},
+	}
+	r, ok := sanitizeReaction(valid)
+	if !ok || r.Trigger.Feature != "rms" || r.Action.Duration != maxInterpolatorDuration {
+		t.Errorf("unexpected reaction: %v %+v", ok, r)
+	}
+	invalid := valid
+	invalid.Trigger.Feature = "pitch"
+	if _, ok := sanitizeReaction(invalid); ok {
+		t.Error("unknown feature accepted")
+	}
+	withIds := valid
+	withIds.Trigger.UserId = "b !"
+	withIds.Action.UserId = "<a>"
+	if r, ok := sanitizeReaction(withIds); !ok || r.Trigger.UserId != "b" || r.Action.UserId != "a" {
+		t.Errorf("unexpected user ids: %v %+v", ok, r)
+	}
+	invalid = valid
+	invalid.Action.Property = ""
+	if _, ok := sanitizeReaction(invalid); ok {
+		t.Error("missing property accepted")
+	}
+}
+
+func TestReactionUpdate(t *testing.T) {
+	r := newReactions([]types.Reaction{{
+		Trigger: types.ReactionTrigger{UserId: "b", Feature: "rms", Threshold: -30, Duration: 500},
+		Action:  types.ReactionAction{UserId: "a", Name: "pitch", Property: "pitch", Value: 0.9, Revert: true},
+	}})[0]
+	if r.name != "reaction_0" {
+		t.Errorf("unexpected default name: %v", r.name)
+	}
+	t0 := time.Now()
+	at := func(ms int) time.Time {
+		return t0.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	for ms := 0; ms < 500; ms += 50 {
+		if fire, _ := r.update(at(ms), -20); fire {
+			t.Fatalf("fired at %vms", ms)
+		}
+	}
+	if fire, revert := r.update(at(500), -20); !fire || revert {
+		t.Fatal("not fired after duration")
+	}
+	// fires only once while the condition is met
+	if fire, _ := r.update(at(1000), -20); fire {
+		t.Fatal("fired again")
+	}
+	// brief dips don't revert
+	r.update(at(1050), -50)
+	if _, revert := r.update(at(1100), -20); revert {
+		t.Fatal("reverted on a dip")
+	}
+	r.update(at(1150), -50)
+	if fire, revert := r.update(at(1650), -50); fire || !revert {
+		t.Fatal("not reverted after duration")
+	}
+}
+
+func TestReactionBase(t *testing.T) {
+	r := newReactions([]types.Reaction{{
+		Trigger: types.ReactionTrigger{UserId: "b"},
+		Action:  types.ReactionAction{UserId: "a", Name: "pitch", Property: "pitch", Factor: 0.9},
+	}})[0]
+	reads := 0
+	read := func() float32 {
+		reads++
+		// value changing, as during an interpolation
+		return float32(reads)
+	}
+	for n := 0; n < 3; n++ {
+		if base := r.getBase(read); base != 1 {
+			t.Errorf("unexpected base: %v", base)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("base read %v times", reads)
+	}
+}
@@ -0,0 +1,179 @@
+package sfu
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Opus packets are sent with redundancy (RED, see https://datatracker.ietf.org/doc/html/rfc2198)
+// to receivers that negotiated it, and as is to other ones
+
+const (
+	// number of previous packets repeated in each RED packet
+	redDistance = 2
+	// RED block header limits
+	redMaxTimestampOffset = 1<<14 - 1
+	redMaxBlockLength     = 1<<10 - 1
+)
+
+type redBlock struct {
+	timestamp uint32
+	payload   []byte
+}
+
+type redBinding struct {
+	id          string
+	ssrc        webrtc.SSRC
+	payloadType webrtc.PayloadType // RED or Opus one
+	opusType    uint8              // used in RED block headers
+	red         bool
+	writer      webrtc.TrackLocalWriter
+}
+
+// redTrack is a webrtc.TrackLocal that, like webrtc.TrackLocalStaticRTP, accepts Opus RTP packets
+// but chooses between RED and Opus per binding
+type redTrack struct {
+	mu       sync.RWMutex
+	id       string
+	streamID string
+	bindings []redBinding
+	previous []redBlock
+}
+
+func newRedTrack(id, streamID string) *redTrack {
+	return &redTrack{id: id, streamID: streamID}
+}
+
+func (t *redTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	var opus, red *webrtc.RTPCodecParameters
+	for _, c := range ctx.CodecParameters() {
+		c := c
+		switch strings.ToLower(c.MimeType) {
+		case strings.ToLower(webrtc.MimeTypeOpus):
+			opus = &c
+		case "audio/red":
+			red = &c
+		}
+	}
+	if opus == nil {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+
+	b := redBinding{
+		id:          ctx.ID(),
+		ssrc:        ctx.SSRC(),
+		payloadType: opus.PayloadType,
+		opusType:    uint8(opus.PayloadType),
+		writer:      ctx.WriteStream(),
+	}
+	selected := *opus
+	if red != nil {
+		b.red = true
+		b.payloadType = red.PayloadType
+		selected = *red
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bindings = append(t.bindings, b)
+	return selected, nil
+}
+
+func (t *redTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.bindings {
+		if t.bindings[i].id == ctx.ID() {
+			t.bindings = append(t.bindings[:i], t.bindings[i+1:]...)
+			return nil
+		}
+	}
+	return webrtc.ErrUnbindFailed
+}
+
+func (t *redTrack) ID() string {
+	return t.id
+}
+
+func (t *redTrack) RID() string {
+	return ""
+}
+
+func (t *redTrack) StreamID() string {
+	return t.streamID
+}
+
+func (t *redTrack) Kind() webrtc.RTPCodecType {
+	return webrtc.RTPCodecTypeAudio
+}
+
+func (t *redTrack) Write(buf []byte) (n int, err error) {
+	packet := &rtp.Packet{}
+	if err = packet.Unmarshal(buf); err != nil {
+		return 0, err
+	}
+
+	t.mu.Lock()
+	redundant := redundantBlocks(t.previous, packet.Timestamp)
+	if len(packet.Payload) > 0 {
+		t.previous = append(t.previous, redBlock{packet.Timestamp, append([]byte(nil), packet.Payload...)})
+		if len(t.previous) > redDistance {
+			t.previous = t.previous[1:]
+		}
+	}
+	bindings := append([]redBinding(nil), t.bindings...)
+	t.mu.Unlock()
+
+	var errs []error
+	for _, b := range bindings {
+		header := packet.Header
+		header.SSRC = uint32(b.ssrc)
+		header.PayloadType = uint8(b.payloadType)
+		payload := packet.Payload
+		if b.red {
+			payload = encodeRED(b.opusType, packet.Payload, packet.Timestamp, redundant)
+		}
+		if _, err := b.writer.WriteRTP(&header, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(buf), errors.Join(errs...)
+}
+
+// keeps previous blocks that can be described in a RED block header
+func redundantBlocks(previous []redBlock, timestamp uint32) (blocks []redBlock) {
+	for _, block := range previous {
+		offset := timestamp - block.timestamp
+		if offset == 0 || offset > redMaxTimestampOffset || len(block.payload) > redMaxBlockLength {
+			continue
+		}
+		blocks = append(blocks, block)
+	}
+	return
+}
+
+// see https://datatracker.ietf.org/doc/html/rfc2198#section-3, redundant blocks (oldest first)
+// come before the primary one
+func encodeRED(payloadType uint8, primary []byte, timestamp uint32, redundant []redBlock) []byte {
+	size := 1 + len(primary)
+	for _, block := range redundant {
+		size += 4 + len(block.payload)
+	}
+	out := make([]byte, 0, size)
+	for _, block := range redundant {
+		// F bit (1), block PT (7), timestamp offset (14), block length (10)
+		header := uint32(0x80|payloadType)<<24 | (timestamp-block.timestamp)<<10 | uint32(len(block.payload))
+		out = binary.BigEndian.AppendUint32(out, header)
+	}
+	out = append(out, payloadType&0x7f)
+	for _, block := range redundant {
+		out = append(out, block.payload...)
+	}
+	return append(out, primary...)
+}
@@ -0,0 +1,47 @@
+package sfu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncodeRED(t *testing.T) {
+	previous := []redBlock{
+		{timestamp: 1000, payload: []byte{1, 1}},
+		{timestamp: 1960, payload: []byte{2, 2, 2}},
+	}
+	redundant := redundantBlocks(previous, 2920)
+	out := encodeRED(111, []byte{3}, 2920, redundant)
+
+	// two 4-byte headers, 1-byte primary header, then blocks
+	if len(out) != 4+4+1+2+3+1 {
+		t.Fatalf("unexpected length: %v", len(out))
+	}
+	first := binary.BigEndian.Uint32(out[0:4])
+	if first>>31 != 1 || (first>>24)&0x7f != 111 || (first>>10)&0x3fff != 1920 || first&0x3ff != 2 {
+		t.Errorf("unexpected first block header: %x", first)
+	}
+	second := binary.BigEndian.Uint32(out[4:8])
+	if (second>>10)&0x3fff != 960 || second&0x3ff != 3 {
+		t.Errorf("unexpected second block header: %x", second)
+	}
+	if out[8] != 111 {
+		t.Errorf("unexpected primary header: %x", out[8])
+	}
+	if !bytes.Equal(out[9:], []byte{1, 1, 2, 2, 2, 3}) {
+		t.Errorf("unexpected blocks: %v", out[9:])
+	}
+}
+
+func TestRedundantBlocks(t *testing.T) {
+	previous := []redBlock{
+		{timestamp: 0, payload: []byte{1}},        // offset too large
+		{timestamp: 20000, payload: []byte{1}},    // kept
+		{timestamp: 20960, payload: []byte{}},     // kept
+		{timestamp: 21920, payload: []byte{1, 2}}, // same timestamp as primary
+	}
+	if blocks := redundantBlocks(previous, 21920); len(blocks) != 2 || blocks[0].timestamp != 20000 {
+		t.Errorf("unexpected blocks: %+v", blocks)
+	}
+}
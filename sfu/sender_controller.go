@@ -3,10 +3,8 @@ package sfu
 import (
 	"fmt"
 	"io"
-	"sync"
+	"time"
 
-	"github.com/ducksouplab/ducksoup/config"
-	"github.com/ducksouplab/ducksoup/env"
 	"github.com/pion/interceptor/pkg/cc"
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
@@ -14,16 +12,14 @@ import (
 )
 
 type senderController struct {
-	sync.Mutex
-	ms                 *mixerSlice
-	fromPs             *peerServer
-	toUserId           string
-	ssrc               webrtc.SSRC
-	kind               string
-	sender             *webrtc.RTPSender
-	ccEstimator        cc.BandwidthEstimator
-	ccOptimalBitrate   int
-	lossOptimalBitrate int
+	ms          *mixerSlice
+	fromPs      *peerServer
+	toUserId    string
+	ssrc        webrtc.SSRC
+	kind        string
+	sender      *webrtc.RTPSender
+	ccEstimator cc.BandwidthEstimator
+	controller  BandwidthController
 }
 
 func newSenderController(pc *peerConn, ms *mixerSlice, sender *webrtc.RTPSender) *senderController {
@@ -31,23 +27,17 @@ func newSenderController(pc *peerConn, ms *mixerSlice, sender *webrtc.RTPSender)
 	kind := ms.output.Kind().String()
 	ssrc := params.Encodings[0].SSRC
 
-	// loss-based bitrate estimation is done here
-	lossOptimalBitrate := config.SFU.Video.DefaultBitrate
-	if kind == "audio" {
-		lossOptimalBitrate = config.SFU.Audio.DefaultBitrate
-	}
-	// ccOptimalBitrate default value is set by ccEstimator
-
-	return &senderController{
-		ms:                 ms,
-		fromPs:             ms.fromPs,
-		toUserId:           pc.userId,
-		ssrc:               ssrc,
-		kind:               kind,
-		sender:             sender,
-		ccEstimator:        pc.ccEstimator,
-		lossOptimalBitrate: lossOptimalBitrate,
+	sc := &senderController{
+		ms:          ms,
+		fromPs:      ms.fromPs,
+		toUserId:    pc.userId,
+		ssrc:        ssrc,
+		kind:        kind,
+		sender:      sender,
+		ccEstimator: pc.ccEstimator,
 	}
+	sc.controller = newBandwidthController(sc)
+	return sc
 }
 
 func (sc *senderController) logError() *zerolog.Event {
@@ -76,66 +66,19 @@ func (sc *senderController) capRate(in int) int {
 }
 
 func (sc *senderController) optimalRate() int {
-	if env.GCC {
-		return sc.ccOptimalBitrate
-	}
-	return sc.lossOptimalBitrate
-}
-
-// see https://datatracker.ietf.org/doc/html/draft-ietf-rmcat-gcc-02
-// credits to https://github.com/jech/galene
-func (sc *senderController) updateRateFromLoss(loss int) {
-	sc.Lock()
-	defer sc.Unlock()
-
-	var newOptimalBitrate int
-	prevOptimalBitrate := sc.lossOptimalBitrate
-
-	if loss < 5 {
-		// loss < 0.02, multiply by 1.05
-		newOptimalBitrate = prevOptimalBitrate * 269 / 256
-	} else if loss > 25 {
-		// loss > 0.1, multiply by (1 - loss/2)
-		newOptimalBitrate = prevOptimalBitrate * (512 - loss) / 512
-		sc.logInfo().Int("value", loss).Msg("loss_threshold_exceeded")
-	} else {
-		newOptimalBitrate = prevOptimalBitrate
-	}
-
-	sc.lossOptimalBitrate = sc.capRate(newOptimalBitrate)
-	sc.logInfo().Str("kind", sc.ms.kind).Int("value", sc.lossOptimalBitrate).Msg("loss_optimal_bitrate_updated")
-	// plot
-	if env.GeneratePlots {
-		sc.ms.plot.AddSenderLossOptimal(sc.toUserId, sc.lossOptimalBitrate)
-	}
+	return sc.controller.Estimate()
 }
 
 func (sc *senderController) loop() {
 	if sc.kind == "video" {
-		if env.GCC {
-			go sc.simpleLoopReadRTCPOnVideo()
-		} else {
-			go sc.estimateLoopReadRTCPOnVideo()
-		}
+		go sc.loopReadRTCPOnVideo()
 	} else {
 		go sc.loopReadRTCPOnAudio()
 	}
 
 	<-sc.ms.i.isStarted()
-	if sc.kind == "video" && env.GCC {
-		sc.ccEstimator.OnTargetBitrateChange(func(bitrate int) {
-			sc.Lock()
-			// update optimal video bitrate
-			// we could leave room for audio and subtracting - config.Audio.MaxBitrate
-			sc.ccOptimalBitrate = sc.capRate(bitrate)
-			sc.logInfo().Str("kind", sc.ms.kind).Int("value", sc.ccOptimalBitrate).Msg("cc_optimal_bitrate_updated")
-			// plot
-			if env.GeneratePlots {
-				sc.ms.plot.AddSenderCCOptimal(sc.toUserId, sc.ccOptimalBitrate)
-			}
-			sc.Unlock()
-			sc.logDebug().Str("target", fmt.Sprintf("%v", sc.ccEstimator.GetTargetBitrate())).Str("stats", fmt.Sprintf("%v", sc.ccEstimator.GetStats())).Msg("gcc")
-		})
+	if sc.kind == "video" {
+		sc.controller.Start()
 	}
 }
 
@@ -145,7 +88,7 @@ func (sc *senderController) loopReadRTCPOnAudio() {
 		case <-sc.ms.Done():
 			return
 		default:
-			_, _, err := sc.sender.ReadRTCP()
+			packets, _, err := sc.sender.ReadRTCP()
 			if err != nil {
 				if err != io.EOF && err != io.ErrClosedPipe {
 					sc.logError().Err(err).Msg("rtcp_on_sender_failed")
@@ -154,11 +97,15 @@ func (sc *senderController) loopReadRTCPOnAudio() {
 					return
 				}
 			}
+			for _, packet := range packets {
+				sc.handleReceiverReport(packet)
+			}
 		}
 	}
 }
 
-func (sc *senderController) estimateLoopReadRTCPOnVideo() {
+func (sc *senderController) loopReadRTCPOnVideo() {
+	encoderPLI := sc.controller.EncoderPLI()
 	for {
 		select {
 		case <-sc.ms.Done():
@@ -175,51 +122,33 @@ func (sc *senderController) estimateLoopReadRTCPOnVideo() {
 			}
 
 			for _, packet := range packets {
-				switch rtcpPacket := packet.(type) {
-				case *rtcp.PictureLossIndication:
+				if _, ok := packet.(*rtcp.PictureLossIndication); ok {
 					sc.ms.fromPs.pc.managedPLIRequest("forward_from_receiving_peer")
-				// case *rtcp.ReceiverEstimatedMaximumBitrate:
-				// disabled due to TWCC
-				// sc.updateRateFromREMB(uint64(rtcpPacket.Bitrate))
-				case *rtcp.ReceiverReport:
-					for _, r := range rtcpPacket.Reports {
-						if r.SSRC == uint32(sc.ssrc) {
-							sc.updateRateFromLoss(int(r.FractionLost))
-						}
+					if encoderPLI {
+						sc.ms.pipeline.SendPLI()
 					}
 				}
+				sc.handleReceiverReport(packet)
+				sc.controller.HandleRTCP(packet)
 				sc.logTrace().Str("type", fmt.Sprintf("%T", packet)).Str("packet", fmt.Sprintf("%+v", packet)).Msg("received_rtcp_on_sender")
 			}
 		}
 	}
 }
 
-func (sc *senderController) simpleLoopReadRTCPOnVideo() {
-	for {
-		select {
-		case <-sc.ms.Done():
-			return
-		default:
-			packets, _, err := sc.sender.ReadRTCP()
-			if err != nil {
-				if err != io.EOF && err != io.ErrClosedPipe {
-					sc.logError().Err(err).Msg("rtcp_on_sender_failed")
-					continue
-				} else {
-					return
-				}
-			}
-
-			for _, packet := range packets {
-				switch packet.(type) {
-				case *rtcp.PictureLossIndication:
-					sc.ms.fromPs.pc.managedPLIRequest("forward_from_receiving_peer")
-					sc.ms.pipeline.SendPLI()
-					// case *rtcp.ReceiverEstimatedMaximumBitrate:
-					// disabled due to TWCC
-					// sc.updateRateFromREMB(uint64(rtcpPacket.Bitrate))
-				}
-				sc.logTrace().Str("type", fmt.Sprintf("%T", packet)).Str("packet", fmt.Sprintf("%+v", packet)).Msg("received_rtcp_on_sender")
+// measures the RTT to the receiving peer (see latency.go)
+func (sc *senderController) handleReceiverReport(packet rtcp.Packet) {
+	rr, ok := packet.(*rtcp.ReceiverReport)
+	if !ok || !sc.ms.i.jp.LatencyEqualization {
+		return
+	}
+	for _, report := range rr.Reports {
+		if report.SSRC != uint32(sc.ssrc) {
+			continue
+		}
+		if rtt, ok := rttFromReport(time.Now(), report); ok {
+			if toPs, ok := sc.ms.i.peerServer(sc.toUserId); ok {
+				toPs.latency.updateRTT(rtt)
 			}
 		}
 	}
@@ -1,5 +1,10 @@
 package sfu
 
+import (
+	"github.com/ducksouplab/ducksoup/engine"
+	"github.com/ducksouplab/ducksoup/types"
+)
+
 func Inspect() any {
 	return interactionStoreSingleton.inspect()
 }
@@ -35,16 +40,42 @@ func (m *mixer) inspect() any {
 func (ms *mixerSlice) inspect() any {
 	// capitalize for JSON export
 	return struct {
-		From      string
-		Kind      string
-		IntputKbs int
-		OutputKbs int
-		TargetKbs int
+		From            string
+		Kind            string
+		IntputKbs       int
+		OutputKbs       int
+		TargetKbs       int
+		EstimatesKbs    map[string]int                        `json:",omitempty"` // per receiving user
+		Retransmissions map[string]engine.RetransmissionStats `json:",omitempty"` // per receiving user
+		SimulcastLayers map[string]string                     `json:",omitempty"` // rid per receiving user
+		VideoTiers      map[string]int                        `json:",omitempty"` // tier per receiving user
+		DelayMs         int                                   `json:",omitempty"`
+		DelayDropped    int                                   `json:",omitempty"` // queue of delay lines full
+		AVOffsetMs      int                                   `json:",omitempty"`
+		Intervention    string                                `json:",omitempty"` // current video intervention
+		Impairment      *types.Impairment                     `json:",omitempty"`
+		AudioLevels     *audioLevels                          `json:",omitempty"` // dBFS
 	}{
 		ms.fromPs.userId,
 		ms.input.Kind().String(),
 		ms.inputBitrate / 1000,
 		ms.outputBitrate / 1000,
 		ms.targetBitrate / 1000,
+		ms.receiverEstimates(),
+		ms.retransmissionStats(),
+		ms.simulcastLayers(),
+		ms.videoTiers(),
+		ms.fromPs.delayLine.current(),
+		ms.fromPs.delayDrops(ms.kind),
+		ms.fromPs.currentAVOffset(),
+		ms.videoIntervention(),
+		ms.fromPs.impairer.current(),
+		ms.audioLevels(),
 	}
 }
+
+// IsRunning tells if an interaction (whatever its origin) is currently using the given data folder,
+// including deleted interactions that are still finalizing (recording, encryption, upload)
+func IsRunning(namespace, interactionName string) bool {
+	return interactionStoreSingleton.running(namespace, interactionName)
+}
@@ -0,0 +1,265 @@
+package sfu
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// Simulcast: the browser sends several encodings of its video, identified by RTP stream ids
+// (see https://datatracker.ietf.org/doc/html/rfc8853). It's only accepted in bypass and direct
+// modes since video is then forwarded without GStreamer processing. Each receiver is forwarded
+// the best layer fitting its senderController estimate, switching layers on keyframes
+
+// from low to high quality, scaling is configured by the browser (see ducksoup.js)
+var simulcastRids = []string{"l", "m", "h"}
+
+// pion v3 does not offer simulcast, but reads rids from the answer: we add rid and simulcast
+// attributes to the first video section of the offer sent to the browser
+func addSimulcastRecv(sdp string) string {
+	lines := strings.Split(strings.TrimSuffix(sdp, "\r\n"), "\r\n")
+	out := make([]string, 0, len(lines)+len(simulcastRids)+1)
+
+	var inVideo, receiving, done bool
+	flush := func() {
+		if inVideo && receiving && !done {
+			for _, rid := range simulcastRids {
+				out = append(out, "a=rid:"+rid+" recv")
+			}
+			out = append(out, "a=simulcast:recv "+strings.Join(simulcastRids, ";"))
+		}
+		if inVideo {
+			done = true
+		}
+	}
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "m="):
+			flush()
+			inVideo, receiving = strings.HasPrefix(line, "m=video"), false
+		case line == "a=recvonly" || line == "a=sendrecv":
+			receiving = true
+		case strings.HasPrefix(line, "a=simulcast:"):
+			done = true
+		}
+		out = append(out, line)
+	}
+	flush()
+	return strings.Join(out, "\r\n") + "\r\n"
+}
+
+// see https://datatracker.ietf.org/doc/html/rfc7741#section-4.3 (VP8),
+// https://datatracker.ietf.org/doc/html/rfc6184#section-5.2 (H264),
+// https://datatracker.ietf.org/doc/html/draft-ietf-payload-vp9-16#section-4.2 (VP9)
+// and https://aomediacodec.github.io/av1-rtp-spec/#44-av1-aggregation-header (AV1)
+func isKeyframe(mimeType string, payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		p := &codecs.VP8Packet{}
+		if _, err := p.Unmarshal(payload); err != nil {
+			return false
+		}
+		return p.S == 1 && p.PID == 0 && len(p.Payload) > 0 && p.Payload[0]&0x01 == 0
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		p := &codecs.VP9Packet{}
+		if _, err := p.Unmarshal(payload); err != nil {
+			return false
+		}
+		return !p.P && p.B && p.SID == 0
+	case strings.ToLower(webrtc.MimeTypeH264):
+		switch payload[0] & 0x1f {
+		case 5, 7: // IDR, SPS
+			return true
+		case 24: // STAP-A
+			for offset := 1; offset+2 < len(payload); {
+				size := int(binary.BigEndian.Uint16(payload[offset:]))
+				if nalType := payload[offset+2] & 0x1f; nalType == 5 || nalType == 7 {
+					return true
+				}
+				offset += 2 + size
+			}
+		case 28: // FU-A, start of an IDR
+			return len(payload) > 1 && payload[1]&0x80 != 0 && payload[1]&0x1f == 5
+		}
+	case strings.ToLower(webrtc.MimeTypeAV1):
+		// N bit: first packet of a coded video sequence
+		return payload[0]&0x08 != 0
+	}
+	return false
+}
+
+type simulcastLayer struct {
+	rid     string
+	track   *webrtc.TrackRemote
+	bits    int
+	bitrate int
+}
+
+type simulcastForwarder struct {
+	sync.Mutex
+	ms        *mixerSlice // set once the slice is running
+	output    *layeredTrack
+	layers    map[string]*simulcastLayer
+	receivers map[webrtc.SSRC]*layerReceiver
+}
+
+func newSimulcastForwarder() *simulcastForwarder {
+	return &simulcastForwarder{
+		layers:    make(map[string]*simulcastLayer),
+		receivers: make(map[webrtc.SSRC]*layerReceiver),
+	}
+}
+
+// returns true for the first layer, the one that prepares the mixerSlice
+func (f *simulcastForwarder) addLayer(track *webrtc.TrackRemote) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	layer := &simulcastLayer{rid: track.RID(), track: track}
+	f.layers[layer.rid] = layer
+	if f.ms != nil {
+		go f.read(f.ms, layer)
+	}
+	return len(f.layers) == 1
+}
+
+func (f *simulcastForwarder) addReceiver(toUserId string, ssrc webrtc.SSRC) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.receivers[ssrc] = &layerReceiver{toUserId: toUserId}
+}
+
+// active layers from low to high quality
+func (f *simulcastForwarder) activeLayers() (options []layerOption) {
+	for _, rid := range simulcastRids {
+		if l, ok := f.layers[rid]; ok && l.bitrate > 0 {
+			options = append(options, layerOption{rid, l.bitrate})
+		}
+	}
+	return
+}
+
+// blocks while reading the slice input, other layers being read in their own goroutines
+func (f *simulcastForwarder) run(ms *mixerSlice) {
+	f.Lock()
+	f.ms = ms
+	f.output = ms.output.(*layeredTrack)
+	var own *simulcastLayer
+	for _, layer := range f.layers {
+		if layer.track == ms.input {
+			own = layer
+		} else {
+			go f.read(ms, layer)
+		}
+	}
+	f.Unlock()
+
+	if own != nil {
+		f.read(ms, own)
+	}
+}
+
+func (f *simulcastForwarder) read(ms *mixerSlice, layer *simulcastLayer) {
+	buf := make([]byte, config.SFU.Common.MTU)
+	mimeType, clockRate := layer.track.Codec().MimeType, layer.track.Codec().ClockRate
+	for {
+		select {
+		case <-ms.fromPs.isDone():
+			return
+		default:
+			n, _, err := layer.track.Read(buf)
+			if err != nil {
+				return
+			}
+			ms.updateInputBits(n)
+			ms.fromPs.forward(ms.kind, buf[:n], func(buf []byte) error {
+				packet := &rtp.Packet{}
+				if err := packet.Unmarshal(buf); err != nil {
+					return err
+				}
+				f.forward(ms, layer, packet, isKeyframe(mimeType, packet.Payload), clockRate)
+				return nil
+			})
+		}
+	}
+}
+
+func (f *simulcastForwarder) forward(ms *mixerSlice, layer *simulcastLayer, packet *rtp.Packet, keyframe bool, clockRate uint32) {
+	f.Lock()
+	output := f.output
+	layer.bits += len(packet.Payload) * 8
+	writes := rewriteForReceivers(f.receivers, layer.rid, packet.Header, keyframe, time.Now(), clockRate)
+	f.Unlock()
+
+	if err := ms.writeLayers(output, writes, packet.Payload); err != nil {
+		ms.logError().Err(err).Msg("simulcast_write_failed")
+	}
+}
+
+// updates layer bitrates and selects layers according to receivers' estimates
+func (f *simulcastForwarder) selectLayers(ms *mixerSlice, elapsed float64) {
+	estimates := ms.senderEstimates()
+
+	type selection struct {
+		toUserId, from, to string
+		estimate           int
+		track              *webrtc.TrackRemote
+	}
+	var selections []selection
+
+	f.Lock()
+	for _, l := range f.layers {
+		l.bitrate = int(float64(l.bits) / elapsed)
+		l.bits = 0
+	}
+	options := f.activeLayers()
+	for ssrc, r := range f.receivers {
+		estimate, ok := estimates[ssrc]
+		if !ok {
+			// sender has been replaced (reconnection)
+			delete(f.receivers, ssrc)
+			continue
+		}
+		if target := selectLayer(options, r.current, estimate); r.current != "" && target != r.target {
+			r.target = target
+			selections = append(selections, selection{r.toUserId, r.current, target, estimate, f.layers[target].track})
+		}
+	}
+	f.Unlock()
+
+	for _, s := range selections {
+		ms.logInfo().Str("toUser", s.toUserId).Str("from", s.from).Str("to", s.to).Int("estimate", s.estimate/1000).Str("unit", "kbit/s").Msg("simulcast_layer_selected")
+		if s.to != s.from {
+			// the switch happens on the next keyframe of the target layer
+			go ms.fromPs.pc.sendPLIRequest(s.track, "simulcast_layer_selected")
+		}
+	}
+}
+
+// rid forwarded to each receiving user
+func (f *simulcastForwarder) forwardedLayers() map[string]string {
+	f.Lock()
+	defer f.Unlock()
+
+	var report map[string]string
+	for _, r := range f.receivers {
+		if r.current == "" {
+			continue
+		}
+		if report == nil {
+			report = make(map[string]string)
+		}
+		report[r.toUserId] = r.current
+	}
+	return report
+}
@@ -0,0 +1,98 @@
+package sfu
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestAddSimulcastRecv(t *testing.T) {
+	offer := "v=0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=recvonly\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\na=recvonly\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\na=sendonly\r\n"
+	out := addSimulcastRecv(offer)
+
+	expected := "m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=recvonly\r\na=rid:l recv\r\na=rid:m recv\r\na=rid:h recv\r\na=simulcast:recv l;m;h\r\nm=video"
+	if !strings.Contains(out, expected) {
+		t.Errorf("simulcast attributes not added to the first video section:\n%v", out)
+	}
+	if strings.Count(out, "a=simulcast:") != 1 {
+		t.Errorf("simulcast attributes added more than once:\n%v", out)
+	}
+	if again := addSimulcastRecv(out); again != out {
+		t.Errorf("offer changed twice:\n%v", again)
+	}
+}
+
+func TestSelectSimulcastLayer(t *testing.T) {
+	options := []layerOption{{"l", 150000}, {"m", 500000}, {"h", 1500000}}
+
+	cases := []struct {
+		current  string
+		estimate int
+		expected string
+	}{
+		{"l", 100000, "l"},
+		{"l", 550000, "l"},  // no headroom to upgrade
+		{"l", 650000, "m"},  // upgrade
+		{"m", 550000, "m"},  // keep
+		{"h", 1000000, "m"}, // downgrade
+		{"", 2000000, "h"},
+	}
+	for _, c := range cases {
+		if got := selectLayer(options, c.current, c.estimate); got != c.expected {
+			t.Errorf("from %q with estimate %v: got %q, expected %q", c.current, c.estimate, got, c.expected)
+		}
+	}
+}
+
+func TestIsKeyframe(t *testing.T) {
+	cases := []struct {
+		mimeType string
+		payload  []byte
+		expected bool
+	}{
+		{webrtc.MimeTypeVP8, []byte{0x10, 0x00}, true},              // start of partition 0, P bit unset
+		{webrtc.MimeTypeVP8, []byte{0x10, 0x01}, false},             // P bit set
+		{webrtc.MimeTypeVP8, []byte{0x00, 0x00}, false},             // not a start
+		{webrtc.MimeTypeH264, []byte{0x65}, true},                   // IDR
+		{webrtc.MimeTypeH264, []byte{0x41}, false},                  // non-IDR slice
+		{webrtc.MimeTypeH264, []byte{0x18, 0x00, 0x01, 0x67}, true}, // STAP-A with SPS
+		{webrtc.MimeTypeH264, []byte{0x7c, 0x85}, true},             // FU-A start of IDR
+		{webrtc.MimeTypeH264, []byte{0x7c, 0x05}, false},            // FU-A continuation
+		{webrtc.MimeTypeAV1, []byte{0x08}, true},
+		{webrtc.MimeTypeAV1, []byte{0x10}, false},
+	}
+	for _, c := range cases {
+		if got := isKeyframe(c.mimeType, c.payload); got != c.expected {
+			t.Errorf("%v %x: got %v, expected %v", c.mimeType, c.payload, got, c.expected)
+		}
+	}
+}
+
+func TestSimulcastReceiverRewrite(t *testing.T) {
+	r := &layerReceiver{}
+	now := time.Now()
+
+	if _, ok := r.rewrite("m", rtp.Header{SequenceNumber: 10, Timestamp: 1000}, false, now, 90000); ok {
+		t.Fatal("forwarded before a keyframe")
+	}
+	h, ok := r.rewrite("m", rtp.Header{SequenceNumber: 11, Timestamp: 1000}, true, now, 90000)
+	if !ok || h.SequenceNumber != 11 || h.Timestamp != 1000 || r.current != "m" || r.target != "m" {
+		t.Fatalf("unexpected start: %+v %+v", h, r)
+	}
+
+	// switch to "h" only on one of its keyframes
+	r.target = "h"
+	if _, ok := r.rewrite("h", rtp.Header{SequenceNumber: 500, Timestamp: 70000}, false, now, 90000); ok {
+		t.Error("switched before a keyframe")
+	}
+	h, ok = r.rewrite("h", rtp.Header{SequenceNumber: 501, Timestamp: 70000}, true, now.Add(100*time.Millisecond), 90000)
+	if !ok || h.SequenceNumber != 12 || h.Timestamp != 1000+9000 || r.current != "h" {
+		t.Fatalf("unexpected switch: %+v %+v", h, r)
+	}
+	if _, ok := r.rewrite("m", rtp.Header{SequenceNumber: 12, Timestamp: 4000}, true, now, 90000); ok {
+		t.Error("previous layer still forwarded")
+	}
+}
@@ -0,0 +1,200 @@
+package sfu
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Tiers: in groups of 3 or more, instead of encoding one video output at the minimum of all
+// receivers' estimates, the pipeline encodes processed video at several bitrates (video.tiers in
+// sfu.yml, see also gst/tiers.go). Each receiver is mapped to the tier that fits its estimate,
+// and switched on keyframes like simulcast layers
+
+const tiersMinSize = 3
+
+// nil if the interaction or join payload does not need tiers, the pipeline also
+// needs to encode video (video fx) for tiers to be enabled. Tiers would bound the
+// bitrate of the fixed controller, so they are disabled with it
+func (i *interaction) videoTierBitrates(jp types.JoinPayload) []int {
+	if i.size < tiersMinSize || jp.AudioOnly || jp.Simulcast || jp.RecordingMode == "bypass" || i.jp.BandwidthController == "fixed" || len(config.SFU.Video.Tiers) < 2 {
+		return nil
+	}
+	return config.SFU.Video.Tiers
+}
+
+type tierSelection struct {
+	toUserId string
+	from, to int // from is -1 if nothing has been forwarded yet
+	estimate int
+}
+
+type tierForwarder struct {
+	sync.Mutex
+	bitrates  []int // selection thresholds, the last tier being the main encoder
+	output    *layeredTrack
+	mimeType  string
+	clockRate uint32
+	receivers map[webrtc.SSRC]*layerReceiver
+}
+
+func newTierForwarder(bitrates []int, output *layeredTrack, codec webrtc.RTPCodecParameters) *tierForwarder {
+	return &tierForwarder{
+		bitrates:  bitrates,
+		output:    output,
+		mimeType:  codec.MimeType,
+		clockRate: codec.ClockRate,
+		receivers: make(map[webrtc.SSRC]*layerReceiver),
+	}
+}
+
+func tierId(tier int) string {
+	return strconv.Itoa(tier)
+}
+
+// -1 for an empty id
+func tierIndex(id string) int {
+	if tier, err := strconv.Atoi(id); err == nil {
+		return tier
+	}
+	return -1
+}
+
+func (tf *tierForwarder) main() int {
+	return len(tf.bitrates) - 1
+}
+
+func (tf *tierForwarder) addReceiver(toUserId string, ssrc webrtc.SSRC) {
+	tf.Lock()
+	defer tf.Unlock()
+
+	tf.receivers[ssrc] = &layerReceiver{toUserId: toUserId}
+}
+
+func (tf *tierForwarder) write(ms *mixerSlice, tier int, buf []byte) error {
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(buf); err != nil {
+		return err
+	}
+	keyframe := isKeyframe(tf.mimeType, packet.Payload)
+
+	tf.Lock()
+	writes := rewriteForReceivers(tf.receivers, tierId(tier), packet.Header, keyframe, time.Now(), tf.clockRate)
+	tf.Unlock()
+
+	return ms.writeLayers(tf.output, writes, packet.Payload)
+}
+
+// selects a tier for each receiver and returns the encoding bitrate of each tier: the lowest
+// estimate of its receivers, bounded by its threshold and the next tier one
+func (tf *tierForwarder) update(estimates map[webrtc.SSRC]int, bounds config.SFUStream) (selections []tierSelection, targets []int) {
+	tf.Lock()
+	defer tf.Unlock()
+
+	options := make([]layerOption, len(tf.bitrates))
+	for tier, bitrate := range tf.bitrates {
+		options[tier] = layerOption{tierId(tier), bitrate}
+	}
+	lowestEstimates := make([]int, len(tf.bitrates))
+	for ssrc, r := range tf.receivers {
+		estimate, ok := estimates[ssrc]
+		if !ok {
+			// sender has been replaced (reconnection)
+			delete(tf.receivers, ssrc)
+			continue
+		}
+		target := selectLayer(options, r.current, estimate)
+		if target != r.target {
+			r.target = target
+			selections = append(selections, tierSelection{r.toUserId, tierIndex(r.current), tierIndex(target), estimate})
+		}
+		tier := tierIndex(target)
+		if lowestEstimates[tier] == 0 || estimate < lowestEstimates[tier] {
+			lowestEstimates[tier] = estimate
+		}
+	}
+
+	targets = make([]int, len(tf.bitrates))
+	for tier, bitrate := range tf.bitrates {
+		low, high := bitrate, bounds.MaxBitrate
+		if tier == 0 {
+			low = bounds.MinBitrate
+		}
+		if tier < tf.main() {
+			high = tf.bitrates[tier+1]
+		}
+		target := lowestEstimates[tier]
+		if target == 0 {
+			// no receiver
+			target = bitrate
+		}
+		targets[tier] = min(max(target, low), high)
+	}
+	return
+}
+
+// tier forwarded to each receiving user
+func (tf *tierForwarder) forwardedTiers() map[string]int {
+	tf.Lock()
+	defer tf.Unlock()
+
+	var report map[string]int
+	for _, r := range tf.receivers {
+		if r.current == "" {
+			continue
+		}
+		if report == nil {
+			report = make(map[string]int)
+		}
+		report[r.toUserId] = tierIndex(r.current)
+	}
+	return report
+}
+
+// replaces the minimum-across-receivers encoding bitrate update
+func (ms *mixerSlice) updateTiers() {
+	selections, targets := ms.tiers.update(ms.senderEstimates(), ms.streamConfig)
+
+	for _, s := range selections {
+		ms.logInfo().Str("toUser", s.toUserId).Int("from", s.from).Int("to", s.to).Int("estimate", s.estimate/1000).Str("unit", "kbit/s").Msg("video_tier_selected")
+		if env.GeneratePlots {
+			ms.plot.AddReceiverTier(s.toUserId, ms.tiers.bitrates[s.to])
+		}
+		// the switch happens on the next keyframe of the target tier
+		if s.from != -1 && s.from != s.to {
+			ms.pipeline.RequestTierKeyFrame(s.to)
+		}
+	}
+
+	main := ms.tiers.main()
+	for tier, target := range targets[:main] {
+		ms.pipeline.SetTierEncodingBitrate(tier, target)
+		if env.GeneratePlots {
+			ms.plot.AddTierTarget(tier, target)
+		}
+	}
+	ms.updateTargetBitrates(targets[main])
+}
+
+// nil if there are no tiers
+func (ms *mixerSlice) videoTiers() map[string]int {
+	if ms.tiers == nil {
+		return nil
+	}
+	return ms.tiers.forwardedTiers()
+}
+
+func (ms *mixerSlice) WriteTier(tier int, buf []byte) error {
+	if ms.tiers == nil {
+		return nil
+	}
+	return ms.fromPs.forward(ms.kind, buf, func(buf []byte) error {
+		return ms.tiers.write(ms, tier, buf)
+	})
+}
@@ -0,0 +1,50 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestTierForwarderUpdate(t *testing.T) {
+	tf := newTierForwarder([]int{300000, 700000, 1200000}, nil, webrtc.RTPCodecParameters{})
+	tf.addReceiver("low", 1)
+	tf.addReceiver("mid", 2)
+	tf.addReceiver("high", 3)
+	tf.addReceiver("gone", 4)
+
+	estimates := map[webrtc.SSRC]int{1: 200000, 2: 900000, 3: 5000000}
+	bounds := config.SFUStream{MinBitrate: 100000, MaxBitrate: 2000000}
+	selections, targets := tf.update(estimates, bounds)
+
+	if len(tf.receivers) != 3 {
+		t.Errorf("receiver without estimate not removed: %v", len(tf.receivers))
+	}
+	expectedTiers := map[string]int{"low": 0, "mid": 1, "high": 2}
+	if len(selections) != len(expectedTiers) {
+		t.Fatalf("unexpected selections: %+v", selections)
+	}
+	for _, s := range selections {
+		if s.from != -1 || s.to != expectedTiers[s.toUserId] {
+			t.Errorf("unexpected selection: %+v", s)
+		}
+	}
+	// lowest estimate per tier, bounded by the tier threshold and the next one (or max bitrate)
+	expectedTargets := []int{200000, 900000, 2000000}
+	for tier, target := range targets {
+		if target != expectedTargets[tier] {
+			t.Errorf("tier %v: got target %v, expected %v", tier, target, expectedTargets[tier])
+		}
+	}
+
+	// no new selection if estimates don't change
+	if selections, _ := tf.update(estimates, bounds); len(selections) != 0 {
+		t.Errorf("unexpected selections: %+v", selections)
+	}
+	// tier without receivers is encoded at its threshold
+	delete(estimates, 2)
+	if _, targets := tf.update(estimates, bounds); targets[1] != 700000 {
+		t.Errorf("got target %v for empty tier", targets[1])
+	}
+}
@@ -0,0 +1,141 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+// Voice activity detection: the level of the decoded (dry) audio of each participant is measured by
+// the pipeline (see audio.level and audio.vad in config/sfu.yml, and gst/template.go). Speech starts
+// once the level has been above the threshold for minSpeech ms and ends once it has been below for
+// minSilence ms, both changes being dated back to the first crossing of the threshold (levels are
+// dated with the end of the measured audio, see gst/gst.c). Speech segments are logged and listed
+// in the interaction manifest, with a turn-taking summary (see types.TurnTaking)
+
+type voiceDetector struct {
+	sync.Mutex
+	threshold  float64 // dBFS
+	minSpeech  time.Duration
+	minSilence time.Duration
+	speaking   bool
+	since      time.Time // start of the current segment, when speaking
+	crossing   time.Time // first level on the other side of the threshold, zero if none
+}
+
+// speech started (end is zero) or ended
+type speechChange struct {
+	speaking bool
+	start    time.Time
+	end      time.Time
+}
+
+// nil if voice activity detection is disabled
+func newVoiceDetector() *voiceDetector {
+	c := config.SFU.Audio.VAD
+	if !c.Enabled || config.SFU.Audio.Level.Interval <= 0 {
+		return nil
+	}
+	return &voiceDetector{
+		threshold:  c.Threshold,
+		minSpeech:  time.Duration(c.MinSpeech) * time.Millisecond,
+		minSilence: time.Duration(c.MinSilence) * time.Millisecond,
+	}
+}
+
+// ok is true when speech starts or ends
+func (v *voiceDetector) update(at time.Time, rms float64) (change speechChange, ok bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	loud := rms >= v.threshold
+	if loud == v.speaking {
+		v.crossing = time.Time{}
+		return
+	}
+	if v.crossing.IsZero() {
+		v.crossing = at
+	}
+	hold := v.minSpeech
+	if v.speaking {
+		hold = v.minSilence
+	}
+	if at.Sub(v.crossing) < hold {
+		return
+	}
+	v.speaking = loud
+	if loud {
+		v.since = v.crossing
+		change = speechChange{speaking: true, start: v.crossing}
+	} else {
+		change = speechChange{start: v.since, end: v.crossing}
+	}
+	v.crossing = time.Time{}
+	return change, true
+}
+
+// ends the current segment if any, returning its start
+func (v *voiceDetector) stop() (since time.Time, ok bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	ok = v.speaking
+	since = v.since
+	v.speaking = false
+	v.crossing = time.Time{}
+	return
+}
+
+func (ps *peerServer) endSpeech(start, end time.Time) {
+	ps.logInfo().
+		Str("context", "track").
+		Time("start", start).
+		Int("duration", int(end.Sub(start)/time.Millisecond)).
+		Msg("speech_ended")
+	ps.i.endSpeech(ps.userId, end)
+}
+
+func (ps *peerServer) detectSpeech(at time.Time, rms float64) {
+	change, ok := ps.vad.update(at, rms)
+	if !ok {
+		return
+	}
+	if change.speaking {
+		ps.logInfo().Str("context", "track").Time("start", change.start).Msg("speech_started")
+		ps.i.startSpeech(ps.userId, change.start)
+	} else {
+		ps.endSpeech(change.start, change.end)
+	}
+}
+
+// the segment is closed when the peer server is, speech from a reconnection being a new segment
+func (ps *peerServer) stopSpeechDetection() {
+	if ps.vad == nil {
+		return
+	}
+	if since, ok := ps.vad.stop(); ok {
+		ps.endSpeech(since, time.Now())
+	}
+}
+
+func (i *interaction) startSpeech(userId string, start time.Time) {
+	i.Lock()
+	defer i.Unlock()
+
+	i.speech = append(i.speech, types.ManifestSpeech{UserId: userId, Start: start})
+}
+
+// closes the last open segment of the user
+func (i *interaction) endSpeech(userId string, end time.Time) {
+	i.Lock()
+	defer i.Unlock()
+
+	for index := len(i.speech) - 1; index >= 0; index-- {
+		if s := &i.speech[index]; s.UserId == userId && s.End.IsZero() {
+			s.End = end
+			return
+		}
+	}
+}
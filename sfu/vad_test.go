@@ -0,0 +1,47 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVoiceDetector(t *testing.T) {
+	v := &voiceDetector{threshold: -45, minSpeech: 150 * time.Millisecond, minSilence: 500 * time.Millisecond}
+	t0 := time.Now()
+	at := func(ms int) time.Time {
+		return t0.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	// a short burst is not speech
+	for _, ms := range []int{0, 50, 100} {
+		if _, ok := v.update(at(ms), -30); ok {
+			t.Fatalf("speech started at %vms", ms)
+		}
+	}
+	v.update(at(150), -60)
+	for ms := 200; ms < 350; ms += 50 {
+		if _, ok := v.update(at(ms), -30); ok {
+			t.Fatalf("speech started at %vms", ms)
+		}
+	}
+	change, ok := v.update(at(350), -30)
+	if !ok || !change.speaking || !change.start.Equal(at(200)) {
+		t.Fatalf("unexpected speech start: %v %+v", ok, change)
+	}
+
+	// short silences don't end speech
+	v.update(at(400), -60)
+	v.update(at(800), -30)
+	for ms := 1000; ms < 1500; ms += 50 {
+		if _, ok := v.update(at(ms), -60); ok {
+			t.Fatalf("speech ended at %vms", ms)
+		}
+	}
+	change, ok = v.update(at(1500), -60)
+	if !ok || change.speaking || !change.start.Equal(at(200)) || !change.end.Equal(at(1000)) {
+		t.Fatalf("unexpected speech end: %v %+v", ok, change)
+	}
+	if _, ok := v.stop(); ok {
+		t.Error("stopped while silent")
+	}
+}
@@ -8,6 +8,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/datastore"
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/gst"
 	"github.com/ducksouplab/ducksoup/types"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
@@ -20,6 +24,14 @@ const (
 )
 
 var recordingModes = []string{"forced", "free", "reenc", "split", "rtpbin_only", "none", "direct", "bypass"}
+var videoFormats = []string{"H264", "VP8", "VP9", "AV1"}
+
+// video is not processed by GStreamer in these modes
+var simulcastRecordingModes = []string{"bypass", "direct"}
+
+// see bandwidth.go
+var bandwidthControllers = []string{"loss", "gcc", "remb", "fixed"}
+var bitrateAggregators = []string{"min", "percentile", "weighted"}
 
 // Helper to make Gorilla Websockets threadsafe
 type wsConn struct {
@@ -60,8 +72,9 @@ type polyControlPayload struct {
 	Value    string `json:"value"`
 }
 
-// remove special characters like / . *
-func parseString(str string) string {
+// ParseString removes special characters like / . * from client strings (namespace,
+// interaction and user ids)
+func ParseString(str string) string {
 	reg, _ := regexp.Compile("[^a-zA-Z0-9-_]+")
 	clean := reg.ReplaceAllString(str, "")
 	if len(clean) > MaxParsedLength {
@@ -72,7 +85,7 @@ func parseString(str string) string {
 
 func parseVideoFormat(jp types.JoinPayload) (videoFormat string) {
 	videoFormat = jp.VideoFormat
-	if videoFormat != "VP8" && videoFormat != "H264" {
+	if !slices.Contains(videoFormats, videoFormat) {
 		videoFormat = defaultVideoFormat
 	}
 	return
@@ -86,6 +99,92 @@ func parseRecordingMode(jp types.JoinPayload) string {
 	}
 }
 
+func parseSimulcast(jp types.JoinPayload) bool {
+	return jp.Simulcast && !jp.AudioOnly && slices.Contains(simulcastRecordingModes, jp.RecordingMode)
+}
+
+// the GCC estimator is only available if enabled with DUCKSOUP_GCC (and then used by default)
+func parseBandwidthController(jp types.JoinPayload) string {
+	if slices.Contains(bandwidthControllers, jp.BandwidthController) && (jp.BandwidthController != "gcc" || env.GCC) {
+		return jp.BandwidthController
+	} else if env.GCC {
+		return "gcc"
+	} else {
+		return "loss"
+	}
+}
+
+func parseBitrateAggregator(jp types.JoinPayload) string {
+	if slices.Contains(bitrateAggregators, jp.BitrateAggregator) {
+		return jp.BitrateAggregator
+	} else {
+		return "min"
+	}
+}
+
+func parseBitratePercentile(jp types.JoinPayload) int {
+	if jp.BitratePercentile <= 0 || jp.BitratePercentile > 100 {
+		return defaultBitratePercentile
+	}
+	return jp.BitratePercentile
+}
+
+// weights target receivers by their userId (or pseudonym, see readJoin)
+func parseBitrateWeights(jp types.JoinPayload) (weights map[string]float64) {
+	for userId, weight := range jp.BitrateWeights {
+		if weights == nil {
+			weights = make(map[string]float64)
+		}
+		userId = ParseString(userId)
+		if datastore.PseudonymsEnabled() {
+			userId = datastore.Pseudonym(jp.Namespace, userId)
+		}
+		weights[userId] = weight
+	}
+	return
+}
+
+// drops invalid interventions, fails if interventions can't be applied to the pipeline
+// (no video fx or recording mode without video encoding)
+func parseVideoInterventions(jp types.JoinPayload) (interventions []types.VideoIntervention, err error) {
+	for _, iv := range jp.VideoInterventions {
+		if intervention, ok := sanitizeVideoIntervention(iv, jp.Framerate); ok {
+			interventions = append(interventions, intervention)
+		}
+	}
+	if len(interventions) > 0 && !gst.VideoInterventionsAvailable(jp) {
+		return nil, errors.New("wrong_join_payload_video_interventions")
+	}
+	return
+}
+
+// drops invalid reactions, participants being referred to by their pseudonym (see readJoin)
+// so that join payloads don't leak original user ids
+func parseReactions(jp types.JoinPayload) (reactions []types.Reaction) {
+	for _, r := range jp.Reactions {
+		if reaction, ok := sanitizeReaction(r); ok {
+			if datastore.PseudonymsEnabled() {
+				reaction.Trigger.UserId = datastore.Pseudonym(jp.Namespace, reaction.Trigger.UserId)
+				reaction.Action.UserId = datastore.Pseudonym(jp.Namespace, reaction.Action.UserId)
+			}
+			reactions = append(reactions, reaction)
+		}
+	}
+	return
+}
+
+// nil if there is no impairment
+func parseImpairment(jp types.JoinPayload) *types.Impairment {
+	if jp.Impairment == nil {
+		return nil
+	}
+	impairment := sanitizeImpairment(*jp.Impairment)
+	if !impaired(impairment) {
+		return nil
+	}
+	return &impairment
+}
+
 func parseWidth(jp types.JoinPayload) (width int) {
 	width = jp.Width
 	if width == 0 {
@@ -126,7 +225,21 @@ func (ws *wsConn) logError() *zerolog.Event {
 	return ws.logger.Error().Str("user", ws.userId)
 }
 
-// peer server has not been created yet
+// the join "opus" object overrides the template (or default) properties it sets
+func parseOpus(payload string, jp types.JoinPayload) (*types.OpusConfig, error) {
+	opus, _ := config.OpusTemplate(jp.OpusTemplate)
+	if jp.Opus != nil {
+		overrides := struct {
+			Opus *types.OpusConfig `json:"opus"`
+		}{&opus}
+		if err := json.Unmarshal([]byte(payload), &overrides); err != nil {
+			return nil, err
+		}
+	}
+	opus = config.SanitizeOpus(opus)
+	return &opus, nil
+}
+
 func (ws *wsConn) readJoin(origin string) (jp types.JoinPayload, err error) {
 	var m messageIn
 
@@ -145,14 +258,37 @@ func (ws *wsConn) readJoin(origin string) (jp types.JoinPayload, err error) {
 	err = json.Unmarshal([]byte(m.Payload), &jp)
 
 	// restrict to authorized values
-	jp.Namespace = parseString(jp.Namespace)
-	jp.InteractionName = parseString(jp.InteractionName)
-	jp.UserId = parseString(jp.UserId)
+	jp.Namespace = ParseString(jp.Namespace)
+	jp.InteractionName = ParseString(jp.InteractionName)
+	jp.UserId = ParseString(jp.UserId)
 	jp.VideoFormat = parseVideoFormat(jp)
 	jp.RecordingMode = parseRecordingMode(jp)
+	jp.Simulcast = parseSimulcast(jp)
 	jp.Width = parseWidth(jp)
 	jp.Height = parseHeight(jp)
 	jp.Framerate = parseFramerate(jp)
+	opus, opusErr := parseOpus(m.Payload, jp)
+	if opusErr != nil {
+		err = opusErr
+		ws.rawSend("error-join")
+		return
+	}
+	jp.Opus = opus
+	jp.BandwidthController = parseBandwidthController(jp)
+	jp.BitrateAggregator = parseBitrateAggregator(jp)
+	jp.BitratePercentile = parseBitratePercentile(jp)
+	jp.BitrateWeights = parseBitrateWeights(jp)
+	jp.Impairment = parseImpairment(jp)
+	jp.Delay = sanitizeDelay(jp.Delay)
+	jp.AVOffset = sanitizeAVOffset(jp.AVOffset)
+	interventions, interventionsErr := parseVideoInterventions(jp)
+	if interventionsErr != nil {
+		err = interventionsErr
+		ws.rawSend("error-join")
+		return
+	}
+	jp.VideoInterventions = interventions
+	jp.Reactions = parseReactions(jp)
 	// add property
 	jp.Origin = origin
 
@@ -167,6 +303,10 @@ func (ws *wsConn) readJoin(origin string) (jp types.JoinPayload, err error) {
 		return
 	}
 
+	// from now on (logs, file names, SSRC index, TURN) the participant is only known by
+	// their pseudonym, if enabled
+	jp.UserId = datastore.PseudonymizeUser(jp.Namespace, jp.UserId)
+
 	// bind fields
 	ws.interactionName = jp.InteractionName
 	ws.userId = jp.UserId
@@ -0,0 +1,73 @@
+package sfu
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ducksouplab/ducksoup/config"
+	"github.com/ducksouplab/ducksoup/datastore"
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/types"
+)
+
+func TestParseOpus(t *testing.T) {
+	parse := func(payload string) *types.OpusConfig {
+		var jp types.JoinPayload
+		if err := json.Unmarshal([]byte(payload), &jp); err != nil {
+			t.Fatal(err)
+		}
+		opus, err := parseOpus(payload, jp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return opus
+	}
+
+	t.Run("default", func(t *testing.T) {
+		expected, _ := config.OpusTemplate("")
+		if opus := parse(`{"userId": "u1"}`); *opus != expected {
+			t.Errorf("unexpected default: %+v", opus)
+		}
+	})
+
+	t.Run("overrides keep other template properties", func(t *testing.T) {
+		template, ok := config.OpusTemplate("music")
+		if !ok {
+			t.Fatal("music template not loaded")
+		}
+		opus := parse(`{"opusTemplate": "music", "opus": {"dtx": true, "ptime": 40}}`)
+		if !opus.DTX || opus.PTime != 40 || opus.Stereo != template.Stereo || opus.MaxBitrate != template.MaxBitrate {
+			t.Errorf("unexpected overrides: %+v", opus)
+		}
+	})
+
+	t.Run("sanitized", func(t *testing.T) {
+		opus := parse(`{"opus": {"ptime": 15, "complexity": 12, "minBitrate": 100000, "maxBitrate": 50000}}`)
+		if opus.PTime != 20 || opus.Complexity != 10 || opus.MinBitrate > opus.MaxBitrate {
+			t.Errorf("unexpected sanitized values: %+v", opus)
+		}
+		if opus.DefaultBitrate < opus.MinBitrate || opus.DefaultBitrate > opus.MaxBitrate {
+			t.Errorf("default bitrate out of bounds: %+v", opus)
+		}
+	})
+}
+
+func TestParseReactionsPseudonyms(t *testing.T) {
+	jp := types.JoinPayload{Namespace: "ns", Reactions: []types.Reaction{{
+		Trigger: types.ReactionTrigger{UserId: "worker-1"},
+		Action:  types.ReactionAction{UserId: "worker-2", Name: "pitch", Property: "pitch", Factor: 0.9},
+	}}}
+	if r := parseReactions(jp); len(r) != 1 || r[0].Trigger.UserId != "worker-1" || r[0].Action.UserId != "worker-2" {
+		t.Errorf("user ids should be kept without pseudonyms: %+v", r)
+	}
+
+	defer func(secret string) { env.PseudonymSecret = secret }(env.PseudonymSecret)
+	env.PseudonymSecret = "secret"
+	r := parseReactions(jp)
+	if len(r) != 1 || r[0].Trigger.UserId != datastore.Pseudonym("ns", "worker-1") || r[0].Action.UserId != datastore.Pseudonym("ns", "worker-2") {
+		t.Errorf("user ids should be pseudonymized: %+v", r)
+	}
+	if jp.Reactions[0].Trigger.UserId != "worker-1" {
+		t.Errorf("join payload reactions should not be modified in place")
+	}
+}
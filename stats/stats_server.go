@@ -24,13 +24,24 @@ func RunStatsServer(ws *websocket.Conn) {
 
 	ticker := time.NewTicker(period * time.Millisecond)
 	defer ticker.Stop()
-
-	for range ticker.C {
-		payload := sfu.Inspect()
-		if payload != nil {
-			m := &messageOut{Kind: "update", Payload: payload}
+	// alerts and other notable events
+	events, unsubscribe := sfu.SubscribeEvents()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ticker.C:
+			payload := sfu.Inspect()
+			if payload != nil {
+				m := &messageOut{Kind: "update", Payload: payload}
+				if err := ws.WriteJSON(m); err != nil {
+					return
+				}
+			}
+		case e := <-events:
+			m := &messageOut{Kind: "event", Payload: e}
 			if err := ws.WriteJSON(m); err != nil {
-				break
+				return
 			}
 		}
 	}
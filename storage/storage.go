@@ -0,0 +1,112 @@
+// Package storage uploads interaction data (recordings, logs, plots, pipeline descriptions) to a remote backend
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/ducksouplab/ducksoup/env"
+	"github.com/ducksouplab/ducksoup/types"
+	"github.com/rs/zerolog"
+)
+
+const (
+	maxAttempts     = 4
+	firstRetryDelay = 2 * time.Second
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Backend stores files remotely, under a given object key
+type Backend interface {
+	// Name identifies the backend in logs and manifests
+	Name() string
+	// Location describes where objects are stored (for instance the bucket URL)
+	Location() string
+	// Put uploads the local file at path under key, and has to fail if the stored
+	// object does not match the given checksum
+	Put(key, path string, sum Checksum) error
+	// Delete removes the object stored under key (and succeeds if there is none)
+	Delete(key string) error
+}
+
+type Checksum struct {
+	Size   int64
+	SHA256 []byte
+	MD5    []byte
+}
+
+// FromEnv returns the backend configured with DUCKSOUP_STORAGE, or nil if data is only kept locally
+func FromEnv() (Backend, error) {
+	switch env.Storage {
+	case "", "local":
+		return nil, nil
+	case "s3":
+		return NewS3(env.S3Endpoint, env.S3Bucket, env.S3Region, env.S3AccessKey, env.S3SecretKey)
+	default:
+		return nil, errors.New("unknown storage backend: " + env.Storage)
+	}
+}
+
+// ObjectKey follows [DUCKSOUP_S3_PREFIX/]namespace/interaction/relative/path
+func ObjectKey(namespace, interaction, relPath string) string {
+	return path.Join(env.S3Prefix, namespace, interaction, filepath.ToSlash(relPath))
+}
+
+func ComputeChecksum(path string) (sum Checksum, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	sha256Hash := sha256.New()
+	md5Hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(sha256Hash, md5Hash), f)
+	if err != nil {
+		return
+	}
+	return Checksum{size, sha256Hash.Sum(nil), md5Hash.Sum(nil)}, nil
+}
+
+// Upload sends the local file at path to b under key, retrying with an exponential backoff,
+// and reports the outcome. It is blocking and meant to be called once the file won't change anymore
+func Upload(b Backend, path, key string, logger zerolog.Logger) (object types.StoredObject) {
+	object.Key = key
+	object.Status = types.UploadFailed
+
+	sum, err := ComputeChecksum(path)
+	if err != nil {
+		object.Error = err.Error()
+		logger.Error().Str("context", "storage").Err(err).Str("file", path).Msg("storage_checksum_failed")
+		return
+	}
+	object.Size = sum.Size
+	object.SHA256 = hex.EncodeToString(sum.SHA256)
+
+	delay := firstRetryDelay
+	for object.Attempts < maxAttempts {
+		object.Attempts++
+		err = b.Put(key, path, sum)
+		if err == nil {
+			object.Status = types.UploadVerified
+			object.Error = ""
+			logger.Info().Str("context", "storage").Str("file", path).Str("key", key).Int64("size", sum.Size).Msg("storage_file_uploaded")
+			return
+		}
+		object.Error = err.Error()
+		logger.Error().Str("context", "storage").Err(err).Str("file", path).Str("key", key).Int("attempt", object.Attempts).Msg("storage_upload_failed")
+		if object.Attempts < maxAttempts {
+			<-time.After(delay)
+			delay *= 2
+		}
+	}
+	return
+}
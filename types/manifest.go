@@ -0,0 +1,167 @@
+package types
+
+import "time"
+
+const (
+	UploadVerified = "verified"
+	UploadFailed   = "failed"
+)
+
+// causes of a participant not being recorded (see ManifestParticipant.NotRecordedCause)
+const (
+	NotRecordedNoConsent          = "no_consent"
+	NotRecordedDisabled           = "recording_disabled"
+	NotRecordedModeNone           = "recording_mode_none"
+	NotRecordedPipelineNotStarted = "pipeline_not_started"
+	NotRecordedNoFile             = "no_recording_file"
+)
+
+// Manifest is written as manifest.json in the interaction data folder when the interaction is over
+type Manifest struct {
+	DuckSoupVersion string                      `json:"ducksoupVersion"`
+	Namespace       string                      `json:"namespace"`
+	Interaction     string                      `json:"interaction"`
+	RandomId        string                      `json:"randomId"`
+	Origin          string                      `json:"origin"`
+	Size            int                         `json:"size"`
+	Duration        int                         `json:"duration"` // in seconds
+	CreatedAt       time.Time                   `json:"createdAt"`
+	StartedAt       *time.Time                  `json:"startedAt,omitempty"` // nil if the interaction did not start
+	EndedAt         time.Time                   `json:"endedAt"`
+	EndCause        string                      `json:"endCause"`
+	Participants    []*ManifestParticipant      `json:"participants"`
+	NotRecorded     []string                    `json:"notRecorded"` // ids of participants with no recording
+	Pipelines       []ManifestPipeline          `json:"pipelines"`
+	Recordings      []ManifestFile              `json:"recordings"`
+	FxChanges       []ManifestFxChange          `json:"fxChanges"`
+	Impairments     []ManifestImpairment        `json:"impairments"`
+	Delays          []ManifestDelay             `json:"delays"`
+	AVOffsets       []ManifestAVOffset          `json:"avOffsets"`
+	Interventions   []ManifestVideoIntervention `json:"videoInterventions"`
+	Speech          []ManifestSpeech            `json:"speech"`
+	TurnTaking      []ManifestTurnTaking        `json:"turnTaking"` // computed from Speech
+	Encryption      *ManifestEncryption         `json:"encryption,omitempty"`
+	Storage         *ManifestStorage            `json:"storage,omitempty"`
+}
+
+type ManifestParticipant struct {
+	UserId string `json:"userId"`
+	// false if the participant has not consented to being recorded (see JoinPayload.NoRecording),
+	// if recordings are disabled or if no pipeline of this participant has started
+	Recorded bool `json:"recorded"`
+	// why the participant has not been recorded (one of the NotRecorded* constants), empty if recorded
+	NotRecordedCause string          `json:"notRecordedCause,omitempty"`
+	Joins            []JoinPayload   `json:"joins"` // resolved join payload of each connection
+	Events           []ManifestEvent `json:"events"`
+}
+
+type ManifestEvent struct {
+	Kind  string    `json:"kind"` // "join", "reconnect" or "leave"
+	At    time.Time `json:"at"`
+	Cause string    `json:"cause,omitempty"`
+}
+
+type ManifestPipeline struct {
+	Id              string   `json:"id"`
+	UserId          string   `json:"userId"`
+	ConnectionCount int      `json:"connectionCount"`
+	Template        string   `json:"template"`
+	Description     string   `json:"description"` // pipeline-u-*.txt dump
+	Started         bool     `json:"started"`
+	Recordings      []string `json:"recordings"`
+}
+
+type ManifestFile struct {
+	Path   string `json:"path"` // relative to the interaction data folder
+	UserId string `json:"userId"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// common fields of changes (fx, impairments, delays...) applied to the pipeline or the forwarded
+// media of a participant
+type ManifestChange struct {
+	At         time.Time `json:"at"`
+	UserId     string    `json:"userId"` // whose pipeline or forwarded media is changed
+	FromUserId string    `json:"fromUserId"`
+}
+
+// Involves is true if userId made or underwent the change
+func (c ManifestChange) Involves(userId string) bool {
+	return c.UserId == userId || c.FromUserId == userId
+}
+
+type ManifestFxChange struct {
+	ManifestChange
+	Name     string `json:"name"`
+	Property string `json:"property"`
+	Kind     string `json:"kind"`
+	Value    string `json:"value"`
+	Duration int    `json:"duration,omitempty"` // interpolation duration in ms
+}
+
+type ManifestImpairment struct {
+	ManifestChange
+	Impairment Impairment `json:"impairment"`
+}
+
+type ManifestDelay struct {
+	ManifestChange
+	Delay int `json:"delay"`          // ms
+	Ramp  int `json:"ramp,omitempty"` // ms
+}
+
+type ManifestAVOffset struct {
+	ManifestChange
+	Offset int `json:"offset"`         // ms
+	Ramp   int `json:"ramp,omitempty"` // ms
+}
+
+type ManifestVideoIntervention struct {
+	ManifestChange
+	Kind     string `json:"kind"`
+	Duration int    `json:"duration,omitempty"` // ms
+	Fps      int    `json:"fps,omitempty"`
+}
+
+// speech segment detected on the (dry) audio of a participant, with server times
+type ManifestSpeech struct {
+	UserId string    `json:"userId"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+
+// per participant summary of speech segments, times in ms
+type ManifestTurnTaking struct {
+	UserId       string `json:"userId"`
+	Segments     int    `json:"segments"`
+	SpeakingTime int    `json:"speakingTime"`
+	OverlapTime  int    `json:"overlapTime"` // while someone else speaks
+	PauseTime    int    `json:"pauseTime"`   // silences between two segments of this participant
+	GapTime      int    `json:"gapTime"`     // silences before this participant takes the turn from someone else
+}
+
+// recordings (and only them) are encrypted for the namespace public key
+type ManifestEncryption struct {
+	Scheme         string   `json:"scheme"`
+	KeyFingerprint string   `json:"keyFingerprint"`   // hex SHA-256 of the public key
+	Failed         []string `json:"failed,omitempty"` // recordings left unencrypted after an error or a pipeline deletion timeout
+}
+
+type ManifestStorage struct {
+	Backend      string         `json:"backend"`
+	Location     string         `json:"location"`
+	Status       string         `json:"status"` // UploadVerified if all objects have been verified
+	LocalDeleted bool           `json:"localDeleted"`
+	Objects      []StoredObject `json:"objects"`
+}
+
+type StoredObject struct {
+	Path     string `json:"path"` // relative to the interaction data folder
+	Key      string `json:"key"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
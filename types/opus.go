@@ -0,0 +1,22 @@
+package types
+
+// OpusConfig is shared by all participants of an interaction and reflected in the SDP fmtp line,
+// opusenc properties and audio bitrate bounds
+type OpusConfig struct {
+	Stereo         bool `json:"stereo" yaml:"stereo"`
+	FEC            bool `json:"fec" yaml:"fec"` // in-band forward error correction
+	DTX            bool `json:"dtx" yaml:"dtx"` // discontinuous transmission
+	RED            bool `json:"red" yaml:"red"` // redundant audio, only on server-sent tracks
+	PTime          int  `json:"ptime" yaml:"ptime"`
+	Complexity     int  `json:"complexity" yaml:"complexity"`
+	DefaultBitrate int  `json:"defaultBitrate" yaml:"defaultBitrate"` // in bit/s, as other bitrates
+	MinBitrate     int  `json:"minBitrate" yaml:"minBitrate"`
+	MaxBitrate     int  `json:"maxBitrate" yaml:"maxBitrate"`
+}
+
+func (c OpusConfig) Channels() int {
+	if c.Stereo {
+		return 2
+	}
+	return 1
+}
@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 type JoinPayload struct {
 	InteractionName string `json:"interactionName"`
 	UserId          string `json:"userId"`
@@ -17,15 +19,97 @@ type JoinPayload struct {
 	GPU           bool   `json:"gpu"`
 	Overlay       bool   `json:"overlay"`
 	AudioOnly     bool   `json:"audioOnly"`
+	NoRecording   bool   `json:"noRecording"` // participant has not consented to being recorded
+	OpusTemplate  string `json:"opusTemplate"`
+	// resolved from OpusTemplate and overridden by the join "opus" object,
+	// then set for all participants by the first one joining the interaction
+	Opus *OpusConfig `json:"opus,omitempty"`
+	// only kept in bypass and direct recording modes
+	Simulcast bool `json:"simulcast"`
+	// bitrate estimation per receiver and aggregation across receivers, set for
+	// all participants by the first one joining the interaction
+	BandwidthController string             `json:"bandwidthController"` // loss, gcc, remb or fixed
+	FixedBitrate        int                `json:"fixedBitrate"`        // bit/s, with the fixed controller
+	BitrateAggregator   string             `json:"bitrateAggregator"`   // min, percentile or weighted
+	BitratePercentile   int                `json:"bitratePercentile"`
+	BitrateWeights      map[string]float64 `json:"bitrateWeights"` // per receiving user id
+	// network conditions simulated on media forwarded from this participant
+	Impairment *Impairment `json:"impairment,omitempty"`
+	// ms, precise delay added to media forwarded from this participant
+	Delay int `json:"delay"`
+	// ms, shift of audio relative to video in media forwarded from this participant (positive
+	// when audio is late), possibly applied to the wet recording too
+	AVOffset       int  `json:"avOffset"`
+	RecordAVOffset bool `json:"recordAVOffset"`
+	// scheduled freeze, black or reduced fps of the video forwarded from this participant
+	VideoInterventions []VideoIntervention `json:"videoInterventions"`
+	// compensating delays so that every participant pair has the same effective delay, set for
+	// all participants by the first one joining the interaction
+	LatencyEqualization bool `json:"latencyEqualization"`
+	// fx changes triggered by audio features of participants, set for all participants by the first
+	// one joining the interaction
+	Reactions []Reaction `json:"reactions"`
 	// Not from JSON
 	Origin string
 }
 
+type Impairment struct {
+	Delay     int     `json:"delay"`     // ms
+	Jitter    int     `json:"jitter"`    // ms, random extra delay up to this value
+	Loss      float64 `json:"loss"`      // share of dropped packets, from 0 to 1
+	Burst     float64 `json:"burst"`     // mean length of loss bursts, in packets (1 for independent losses)
+	Bandwidth int     `json:"bandwidth"` // bit/s, 0 for no cap
+}
+
+type VideoIntervention struct {
+	Kind     string `json:"kind"`     // none, freeze, black or fps
+	At       int    `json:"at"`       // ms after the interaction start, when scheduled at join
+	Duration int    `json:"duration"` // ms, 0 until the next intervention
+	Fps      int    `json:"fps"`      // with the fps kind
+}
+
+// changes an fx property in the pipeline of a participant when the audio of a participant
+// (possibly the same one) meets a condition
+type Reaction struct {
+	Name    string          `json:"name"` // optional, identifies the reaction in logs
+	Trigger ReactionTrigger `json:"trigger"`
+	Action  ReactionAction  `json:"action"`
+}
+
+type ReactionTrigger struct {
+	UserId    string  `json:"userId"`    // whose (dry) audio is analyzed
+	Feature   string  `json:"feature"`   // rms (default) or peak level
+	Threshold float64 `json:"threshold"` // dBFS
+	Below     bool    `json:"below"`     // the condition is met below the threshold instead of above
+	Duration  int     `json:"duration"`  // ms the condition has to be met (or not anymore) for
+}
+
+type ReactionAction struct {
+	UserId   string  `json:"userId"` // whose pipeline is changed
+	Name     string  `json:"name"`   // fx name
+	Property string  `json:"property"`
+	Value    float32 `json:"value"`
+	Factor   float32 `json:"factor"`   // if set, the current value is multiplied by factor instead
+	Duration int     `json:"duration"` // ms, interpolation duration
+	Revert   bool    `json:"revert"`   // back to the previous value once the condition is not met anymore
+}
+
 type TrackWriter interface {
 	ID() string
 	Write(buf []byte) error
 }
 
+// video tiers are written by the pipeline with their index (see gst/tiers.go)
+type TierWriter interface {
+	WriteTier(tier int, buf []byte) error
+}
+
+// audio levels (dBFS) are written by the pipeline with their source, dry or wet (see gst/template.go),
+// at being the end of the measured audio
+type AudioLevelWriter interface {
+	WriteAudioLevel(source string, rms, peak float64, at time.Time)
+}
+
 type Terminable interface {
 	Done() chan struct{}
 }
@@ -0,0 +1,83 @@
+package types
+
+import (
+	"sort"
+	"time"
+)
+
+type speechEdge struct {
+	at     time.Time
+	userId string
+	start  bool
+}
+
+func milliseconds(d time.Duration) int {
+	return int(d / time.Millisecond)
+}
+
+// TurnTaking summarizes speech segments per participant (in order of first appearance). A silence
+// (nobody speaking) is a pause if it ends with the participant who spoke last, and a gap otherwise
+func TurnTaking(speech []ManifestSpeech) []ManifestTurnTaking {
+	index := make(map[string]*ManifestTurnTaking)
+	order := []string{}
+	edges := []speechEdge{}
+	for _, s := range speech {
+		t, ok := index[s.UserId]
+		if !ok {
+			t = &ManifestTurnTaking{UserId: s.UserId}
+			index[s.UserId] = t
+			order = append(order, s.UserId)
+		}
+		if !s.End.After(s.Start) {
+			continue
+		}
+		t.Segments++
+		t.SpeakingTime += milliseconds(s.End.Sub(s.Start))
+		edges = append(edges, speechEdge{s.Start, s.UserId, true}, speechEdge{s.End, s.UserId, false})
+	}
+	// ends first, so that back-to-back segments don't overlap
+	sort.SliceStable(edges, func(a, b int) bool {
+		if !edges[a].at.Equal(edges[b].at) {
+			return edges[a].at.Before(edges[b].at)
+		}
+		return !edges[a].start && edges[b].start
+	})
+
+	active := make(map[string]int)
+	var last, silentSince time.Time
+	var lastSpeaker string
+	for _, e := range edges {
+		if len(active) > 1 {
+			for userId := range active {
+				index[userId].OverlapTime += milliseconds(e.at.Sub(last))
+			}
+		}
+		last = e.at
+		if e.start {
+			if len(active) == 0 && len(lastSpeaker) > 0 {
+				silence := milliseconds(e.at.Sub(silentSince))
+				if e.userId == lastSpeaker {
+					index[e.userId].PauseTime += silence
+				} else {
+					index[e.userId].GapTime += silence
+				}
+			}
+			active[e.userId]++
+		} else {
+			active[e.userId]--
+			if active[e.userId] <= 0 {
+				delete(active, e.userId)
+			}
+			if len(active) == 0 {
+				silentSince = e.at
+				lastSpeaker = e.userId
+			}
+		}
+	}
+
+	summaries := []ManifestTurnTaking{}
+	for _, userId := range order {
+		summaries = append(summaries, *index[userId])
+	}
+	return summaries
+}
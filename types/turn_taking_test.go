@@ -0,0 +1,37 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTurnTaking(t *testing.T) {
+	t0 := time.Now()
+	segment := func(userId string, start, end int) ManifestSpeech {
+		return ManifestSpeech{
+			UserId: userId,
+			Start:  t0.Add(time.Duration(start) * time.Millisecond),
+			End:    t0.Add(time.Duration(end) * time.Millisecond),
+		}
+	}
+	speech := []ManifestSpeech{
+		segment("a", 0, 1000),
+		segment("a", 1200, 2000), // 200ms pause
+		segment("b", 1800, 3000), // 200ms overlap
+		segment("a", 3500, 4000), // 500ms gap
+		segment("b", 4000, 4500), // back-to-back
+	}
+	summaries := TurnTaking(speech)
+	expected := []ManifestTurnTaking{
+		{UserId: "a", Segments: 3, SpeakingTime: 2300, OverlapTime: 200, PauseTime: 200, GapTime: 500},
+		{UserId: "b", Segments: 2, SpeakingTime: 1700, OverlapTime: 200},
+	}
+	if len(summaries) != len(expected) {
+		t.Fatalf("unexpected summaries: %+v", summaries)
+	}
+	for i := range expected {
+		if summaries[i] != expected[i] {
+			t.Errorf("got %+v, expected %+v", summaries[i], expected[i])
+		}
+	}
+}